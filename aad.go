@@ -0,0 +1,57 @@
+package cose
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ExternalAADBuilder builds the external_aad of a Sig_structure e.g.
+// from transport metadata with a profile specific encoding, so it is
+// computed the same way when signing and verifying
+//
+// https://tools.ietf.org/html/rfc8152#section-4.3
+type ExternalAADBuilder interface {
+	Build() (external []byte, err error)
+}
+
+// RawExternalAAD is an ExternalAADBuilder for external_aad bytes
+type RawExternalAAD []byte
+
+// Build returns the external_aad bytes
+func (a RawExternalAAD) Build() (external []byte, err error) {
+	return a, nil
+}
+
+// buildExternalAAD returns the external_aad from aad or nil when aad
+// is nil
+func buildExternalAAD(aad ExternalAADBuilder) (external []byte, err error) {
+	if aad == nil {
+		return nil, nil
+	}
+	external, err = aad.Build()
+	if err != nil {
+		return nil, errors.Wrap(err, "error building external_aad")
+	}
+	return external, nil
+}
+
+// SignWithAAD signs a SignMessage like Sign with the external_aad
+// built by aad
+func (m *SignMessage) SignWithAAD(rand io.Reader, aad ExternalAADBuilder, signers []Signer) (err error) {
+	external, err := buildExternalAAD(aad)
+	if err != nil {
+		return err
+	}
+	return m.Sign(rand, external, signers)
+}
+
+// VerifyWithAAD verifies the signatures on a SignMessage like Verify
+// with the external_aad built by aad
+func (m *SignMessage) VerifyWithAAD(aad ExternalAADBuilder, verifiers []Verifier) (err error) {
+	external, err := buildExternalAAD(aad)
+	if err != nil {
+		return err
+	}
+	return m.Verify(external, verifiers)
+}
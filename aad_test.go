@@ -0,0 +1,62 @@
+package cose
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// requestAAD builds external_aad from request metadata
+type requestAAD struct {
+	method string
+	path   string
+	err    error
+}
+
+func (a requestAAD) Build() ([]byte, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	return Marshal([]interface{}{a.method, a.path})
+}
+
+func TestSignAndVerifyWithAAD(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+
+	aad := requestAAD{method: "POST", path: "/firmware"}
+	assert.Nil(msg.SignWithAAD(rand.Reader, aad, []Signer{*signer}))
+	assert.Nil(msg.VerifyWithAAD(aad, verifiers))
+	assert.Equal(ErrECDSAVerification, msg.VerifyWithAAD(requestAAD{method: "GET", path: "/firmware"}, verifiers))
+
+	// the built AAD is the external bytes to Verify
+	external, err := aad.Build()
+	assert.Nil(err)
+	assert.Nil(msg.Verify(external, verifiers))
+	assert.Nil(msg.VerifyWithAAD(RawExternalAAD(external), verifiers))
+
+	err = msg.VerifyWithAAD(requestAAD{err: errors.New("missing path")}, verifiers)
+	assert.Equal("error building external_aad: missing path", err.Error())
+
+	// a nil builder is no external_aad
+	msg.ClearSignatures()
+	assert.Nil(msg.SignWithAAD(rand.Reader, nil, []Signer{*signer}))
+	assert.Nil(msg.Verify(nil, verifiers))
+	assert.Nil(msg.VerifyWithAAD(RawExternalAAD(nil), verifiers))
+
+	msg.ClearSignatures()
+	err = msg.SignWithAAD(rand.Reader, requestAAD{err: fmt.Errorf("no request")}, []Signer{*signer})
+	assert.Equal("error building external_aad: no request", err.Error())
+	assert.Nil(msg.Signatures[0].SignatureBytes)
+}
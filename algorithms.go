@@ -6,6 +6,11 @@ package cose
 import (
 	"crypto"
 	"crypto/elliptic"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
 )
 
 // KeyType is the type to use in keyOptions to tell MakeDEREndEntity
@@ -44,14 +49,243 @@ type Algorithm struct {
 	// optional fields
 	HashFunc           crypto.Hash    // hash function for SignMessages
 	privateKeyType     KeyType        // private key type to generate for new Signers
+	isHash             bool           // hash algorithm e.g. for an x5t hashAlg
 
 	minRSAKeyBitLen    int            // minimimum RSA key size to generate in bits
+	rsaPKCS1v15        bool           // sign with RSASSA-PKCS1-v1_5 instead of RSASSA-PSS
 
 	privateKeyECDSACurve    elliptic.Curve // ecdsa private key curve type
 }
 
+// AlgorithmInfo returns a copy of the Algorithm for an IANA value
+func AlgorithmInfo(value int) (alg Algorithm, err error) {
+	found, err := getAlgByValue(value)
+	if err != nil {
+		return Algorithm{}, err
+	}
+	return *found, nil
+}
+
+// algorithmAliases maps other spellings of algorithm names to their
+// IANA names for AlgorithmInfoByName. It is guarded by algorithmsMu.
+var algorithmAliases = map[string]string{
+	"Ed25519": "EdDSA",
+	"Ed448":   "EdDSA",
+	"SHA256":  "SHA-256",
+	"SHA384":  "SHA-384",
+	"SHA512":  "SHA-512",
+}
+
+// RegisterAlgorithmAlias adds alias as another spelling of the IANA
+// algorithm name for AlgorithmInfoByName e.g. the spelling of another
+// tool
+//
+// It returns an error when name is not a known algorithm or alias is
+// the name of an algorithm or already an alias of another one. It is
+// safe to call concurrently with looking up names.
+func RegisterAlgorithmAlias(alias, name string) (err error) {
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+
+	if alias == "" {
+		return errors.New("algorithm alias is empty")
+	}
+	if _, ok := algorithmIndexByName[name]; !ok {
+		return errors.Errorf("Algorithm named %s not found", name)
+	}
+	if _, ok := algorithmIndexByName[alias]; ok {
+		return errors.Errorf("alias %s is the name of an algorithm", alias)
+	}
+	if existing, ok := algorithmAliases[alias]; ok && existing != name {
+		return errors.Errorf("alias %s is already an alias of %s", alias, existing)
+	}
+	algorithmAliases[alias] = name
+	return nil
+}
+
+// AlgorithmNameOpts are options for AlgorithmInfoByName
+type AlgorithmNameOpts struct {
+	// CaseInsensitive matches IANA names and aliases ignoring case
+	// e.g. es256 for ES256 when there is no exact match
+	CaseInsensitive bool
+}
+
+// AlgorithmInfoByName returns a copy of the Algorithm for an IANA name
+// or an alias of one e.g. Ed25519 or one from RegisterAlgorithmAlias
+//
+// The returned Algorithm has the IANA name e.g. EdDSA for Ed25519.
+// Exact matches come first, then case-insensitive matches of IANA
+// names in table order and aliases in sorted order. The error for an
+// unknown name includes the closest known name.
+func AlgorithmInfoByName(name string, opts AlgorithmNameOpts) (alg Algorithm, err error) {
+	ianaName, closest := resolveAlgorithmName(name, opts.CaseInsensitive)
+	if ianaName == "" {
+		return Algorithm{}, errors.Errorf("Algorithm named %s not found; the closest known name is %s", name, closest)
+	}
+
+	found, err := getAlgByName(ianaName)
+	if err != nil {
+		return Algorithm{}, errors.Wrapf(err, "alias %s", name)
+	}
+	return *found, nil
+}
+
+// resolveAlgorithmName returns the IANA name for an IANA name or alias
+// or "" and the closest known name when there is none
+func resolveAlgorithmName(name string, caseInsensitive bool) (ianaName, closest string) {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+
+	if _, ok := algorithmIndexByName[name]; ok {
+		return name, ""
+	}
+	if aliasName, ok := algorithmAliases[name]; ok {
+		return aliasName, ""
+	}
+
+	aliases := make([]string, 0, len(algorithmAliases))
+	for alias := range algorithmAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	if caseInsensitive {
+		for _, alg := range algorithms {
+			if strings.EqualFold(alg.Name, name) {
+				return alg.Name, ""
+			}
+		}
+		for _, alias := range aliases {
+			if strings.EqualFold(alias, name) {
+				return algorithmAliases[alias], ""
+			}
+		}
+	}
+	return "", closestAlgorithmName(name, aliases)
+}
+
+// closestAlgorithmName returns the IANA name or alias with the
+// smallest case-insensitive edit distance to name
+//
+// The caller must hold algorithmsMu.
+func closestAlgorithmName(name string, aliases []string) (closest string) {
+	best := -1
+	consider := func(known string) {
+		d := editDistance(strings.ToLower(name), strings.ToLower(known))
+		if best < 0 || d < best || (d == best && known < closest) {
+			closest, best = known, d
+		}
+	}
+	for _, alg := range algorithms {
+		consider(alg.Name)
+	}
+	for _, alias := range aliases {
+		consider(alias)
+	}
+	return closest
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// KeyType returns the type of private key used with the Algorithm or
+// KeyTypeUnsupported
+func (alg Algorithm) KeyType() KeyType {
+	return alg.privateKeyType
+}
+
+// isHashAlgorithm returns true for hash algorithms e.g. SHA-256 and
+// not signature algorithms that use a hash function
+func isHashAlgorithm(alg *Algorithm) bool {
+	return alg != nil && alg.isHash && alg.HashFunc != 0
+}
+
+// Curve returns the elliptic curve for an ECDSA Algorithm or nil
+func (alg Algorithm) Curve() elliptic.Curve {
+	return alg.privateKeyECDSACurve
+}
+
+// CoordinateSize returns the size in bytes of an ECDSA Algorithm's
+// curve coordinates and signature integers r and s or 0 for other
+// algorithms
+func (alg Algorithm) CoordinateSize() int {
+	if alg.privateKeyECDSACurve == nil {
+		return 0
+	}
+	return ecdsaCurveKeyBytesSize(alg.privateKeyECDSACurve)
+}
+
+// MinRSAKeyBitLen returns the minimum RSA key size in bits for an RSA
+// Algorithm or 0 for other algorithms
+func (alg Algorithm) MinRSAKeyBitLen() int {
+	return alg.minRSAKeyBitLen
+}
+
 // algorithms is an array/slice of IANA algorithms
 var algorithms = []Algorithm{
+	Algorithm{
+		Name:            "RS512", // RSASSA-PKCS1-v1_5 using SHA-512 from [RFC8812]
+		Value:           -259,
+		HashFunc:        crypto.SHA512,
+		privateKeyType:  KeyTypeRSA,
+		minRSAKeyBitLen: 2048,
+		rsaPKCS1v15:     true,
+	},
+	Algorithm{
+		Name:            "RS384", // RSASSA-PKCS1-v1_5 using SHA-384 from [RFC8812]
+		Value:           -258,
+		HashFunc:        crypto.SHA384,
+		privateKeyType:  KeyTypeRSA,
+		minRSAKeyBitLen: 2048,
+		rsaPKCS1v15:     true,
+	},
+	Algorithm{
+		Name:            "RS256", // RSASSA-PKCS1-v1_5 using SHA-256 from [RFC8812]
+		Value:           -257,
+		HashFunc:        crypto.SHA256,
+		privateKeyType:  KeyTypeRSA,
+		minRSAKeyBitLen: 2048,
+		rsaPKCS1v15:     true,
+	},
+	Algorithm{
+		Name:     "SHA-512", // SHA-2 512-bit Hash from [RFC9054]
+		Value:    -44,
+		HashFunc: crypto.SHA512,
+		isHash:   true,
+	},
+	Algorithm{
+		Name:     "SHA-384", // SHA-2 384-bit Hash from [RFC9054]
+		Value:    -43,
+		HashFunc: crypto.SHA384,
+		isHash:   true,
+	},
 	Algorithm{
 		Name:  "RSAES-OAEP w/ SHA-512", // RSAES-OAEP w/ SHA-512 from [RFC8230]
 		Value: -42,
@@ -133,6 +367,12 @@ var algorithms = []Algorithm{
 		Name:  "ECDH-ES + HKDF-256", // ECDH ES w/ HKDF - generate key directly from [RFC8152]
 		Value: -25,
 	},
+	Algorithm{
+		Name:     "SHA-256", // SHA-2 256-bit Hash from [RFC9054]
+		Value:    -16,
+		HashFunc: crypto.SHA256,
+		isHash:   true,
+	},
 	Algorithm{
 		Name:  "direct+HKDF-AES-256", // Shared secret w/ AES-MAC 256-bit key from [RFC8152]
 		Value: -13,
@@ -257,3 +497,35 @@ var algorithms = []Algorithm{
 		Value: 33,
 	},
 }
+
+// algorithmIndexByName and algorithmIndexByValue map IANA algorithm
+// names and values to their index in algorithms for getAlgByName and
+// getAlgByValue
+var (
+	algorithmIndexByName  = indexAlgorithms(func(alg Algorithm) interface{} { return alg.Name })
+	algorithmIndexByValue = indexAlgorithms(func(alg Algorithm) interface{} { return alg.Value })
+)
+
+// algorithmsMu guards algorithms and its indexes for
+// RegisterAlgorithm. Registering replaces them instead of modifying
+// them, so a slice from algorithmTable is safe to use unlocked.
+var algorithmsMu sync.RWMutex
+
+// algorithmTable returns the current algorithms
+func algorithmTable() []Algorithm {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+	return algorithms
+}
+
+// indexAlgorithms returns a map from key(alg) to the index of the
+// first alg in algorithms with that key
+func indexAlgorithms(key func(Algorithm) interface{}) map[interface{}]int {
+	index := map[interface{}]int{}
+	for i, alg := range algorithms {
+		if _, ok := index[key(alg)]; !ok {
+			index[key(alg)] = i
+		}
+	}
+	return index
+}
@@ -0,0 +1,127 @@
+package cose
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestAlgorithmInfo(t *testing.T) {
+	assert := assert.New(t)
+
+	var tests = []struct {
+		value           int
+		name            string
+		hashFunc        crypto.Hash
+		keyType         KeyType
+		curve           elliptic.Curve
+		coordinateSize  int
+		minRSAKeyBitLen int
+	}{
+		{-7, "ES256", crypto.SHA256, KeyTypeECDSA, elliptic.P256(), 32, 0},
+		{-35, "ES384", crypto.SHA384, KeyTypeECDSA, elliptic.P384(), 48, 0},
+		{-36, "ES512", crypto.SHA512, KeyTypeECDSA, elliptic.P521(), 66, 0},
+		{-37, "PS256", crypto.SHA256, KeyTypeRSA, nil, 0, 2048},
+		{-257, "RS256", crypto.SHA256, KeyTypeRSA, nil, 0, 2048},
+		{-16, "SHA-256", crypto.SHA256, KeyTypeUnsupported, nil, 0, 0},
+	}
+	for _, test := range tests {
+		alg, err := AlgorithmInfo(test.value)
+		assert.Nil(err, test.name)
+		assert.Equal(test.name, alg.Name)
+		assert.Equal(test.value, alg.Value)
+		assert.Equal(test.hashFunc, alg.HashFunc, test.name)
+		assert.Equal(test.keyType, alg.KeyType(), test.name)
+		assert.Equal(test.curve, alg.Curve(), test.name)
+		assert.Equal(test.coordinateSize, alg.CoordinateSize(), test.name)
+		assert.Equal(test.minRSAKeyBitLen, alg.MinRSAKeyBitLen(), test.name)
+	}
+
+	// returns a copy of the table entry
+	alg, err := AlgorithmInfo(ES256.Value)
+	assert.Nil(err)
+	alg.Name = "modified"
+	alg, err = AlgorithmInfo(ES256.Value)
+	assert.Nil(err)
+	assert.Equal("ES256", alg.Name)
+
+	alg, err = AlgorithmInfo(-1000000)
+	assert.Equal(Algorithm{}, alg)
+	assert.Equal("Algorithm with value -1000000 not found", err.Error())
+}
+
+func TestAlgorithmInfoByName(t *testing.T) {
+	assert := assert.New(t)
+
+	var tests = []struct {
+		name            string
+		caseInsensitive bool
+		expected        string
+	}{
+		{"ES256", false, "ES256"},
+		{"Ed25519", false, "EdDSA"},
+		{"SHA256", false, "SHA-256"},
+		{"es256", true, "ES256"},
+		{"ed25519", true, "EdDSA"},
+		{"eddsa", true, "EdDSA"},
+	}
+	for _, test := range tests {
+		alg, err := AlgorithmInfoByName(test.name, AlgorithmNameOpts{CaseInsensitive: test.caseInsensitive})
+		assert.Nil(err, test.name)
+		assert.Equal(test.expected, alg.Name, test.name)
+	}
+
+	// case-sensitive by default
+	_, err := AlgorithmInfoByName("es256", AlgorithmNameOpts{})
+	assert.Equal("Algorithm named es256 not found; the closest known name is ES256", err.Error())
+	_, err = AlgorithmInfoByName("ES265", AlgorithmNameOpts{CaseInsensitive: true})
+	assert.Equal("Algorithm named ES265 not found; the closest known name is ES256", err.Error())
+	_, err = AlgorithmInfoByName("Ed2551", AlgorithmNameOpts{})
+	assert.Equal("Algorithm named Ed2551 not found; the closest known name is Ed25519", err.Error())
+
+	defer restoreAlgorithms()()
+	assert.Nil(RegisterAlgorithmAlias("ECDSA-P256-SHA256", "ES256"))
+	assert.Nil(RegisterAlgorithmAlias("ECDSA-P256-SHA256", "ES256"))
+	alg, err := AlgorithmInfoByName("ECDSA-P256-SHA256", AlgorithmNameOpts{})
+	assert.Nil(err)
+	assert.Equal(ES256.Value, alg.Value)
+
+	assert.Equal("Algorithm named FOOOO not found", RegisterAlgorithmAlias("bad alias", "FOOOO").Error())
+	assert.Equal("alias ES384 is the name of an algorithm", RegisterAlgorithmAlias("ES384", "ES256").Error())
+	assert.Equal("alias ECDSA-P256-SHA256 is already an alias of ES256", RegisterAlgorithmAlias("ECDSA-P256-SHA256", "ES384").Error())
+	assert.Equal("algorithm alias is empty", RegisterAlgorithmAlias("", "ES256").Error())
+
+	// exact matches come first then case-insensitive matches in a
+	// deterministic order
+	assert.Nil(RegisterAlgorithmAlias("p384", "ES384"))
+	assert.Nil(RegisterAlgorithmAlias("P384", "ES512"))
+	alg, err = AlgorithmInfoByName("p384", AlgorithmNameOpts{CaseInsensitive: true})
+	assert.Nil(err)
+	assert.Equal("ES384", alg.Name)
+	alg, err = AlgorithmInfoByName("P384", AlgorithmNameOpts{CaseInsensitive: true})
+	assert.Nil(err)
+	assert.Equal("ES512", alg.Name)
+	assert.Nil(RegisterAlgorithmAlias("ed25519", "ES256"))
+	alg, err = AlgorithmInfoByName("ED25519", AlgorithmNameOpts{CaseInsensitive: true})
+	assert.Nil(err)
+	assert.Equal("EdDSA", alg.Name, "Ed25519 sorts before ed25519")
+
+	// registering is safe while looking up names
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(RegisterAlgorithmAlias(fmt.Sprintf("alias-%d", i), "ES256"))
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, err := AlgorithmInfoByName("sha256", AlgorithmNameOpts{CaseInsensitive: true})
+			assert.Nil(err)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,151 @@
+package cose
+
+import (
+	"container/list"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+)
+
+// VerificationCache remembers signatures that verified, so
+// VerifyWithOpts can skip verifying them again e.g. for a service
+// that sees the same tokens many times
+//
+// Keys are the SHA-256 digest of the exact algorithm, public key,
+// Sig_structure digest, and signature bytes. Only
+// signatures that verified are added, so a failed verification is
+// never cached and a changed byte in any of them is a miss. Anyone who
+// can add to the cache can make signatures verify, so do not share it
+// with untrusted code.
+type VerificationCache interface {
+	Contains(key string) bool
+	Add(key string)
+}
+
+// lruVerificationCache is a VerificationCache that evicts the least
+// recently used key
+type lruVerificationCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+// NewLRUVerificationCache returns a VerificationCache that is safe for
+// concurrent use and holds up to capacity keys
+func NewLRUVerificationCache(capacity int) VerificationCache {
+	return &lruVerificationCache{
+		capacity: capacity,
+		order:    list.New(),
+		elements: map[string]*list.Element{},
+	}
+}
+
+func (c *lruVerificationCache) Contains(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.elements[key]
+	if ok {
+		c.order.MoveToFront(element)
+	}
+	return ok
+}
+
+func (c *lruVerificationCache) Add(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.capacity < 1 {
+		return
+	}
+	if element, ok := c.elements[key]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.elements[key] = c.order.PushFront(key)
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.elements, oldest.Value.(string))
+	}
+}
+
+// verificationCacheKey returns the VerificationCache key for verifying
+// signature over digest with verifier or false when the verifier key
+// is not a supported public key type
+func verificationCacheKey(verifier *Verifier, signature *Signature, digest []byte) (key string, ok bool) {
+	if verifier.Alg == nil {
+		return "", false
+	}
+	keyFields, ok := publicKeyCacheFields(verifier.PublicKey)
+	if !ok {
+		return "", false
+	}
+
+	hasher := sha256.New()
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(int64(verifier.Alg.Value)))
+	_, _ = hasher.Write(b[:]) // Write() on hash never fails
+	for _, field := range append(keyFields, digest, signature.SignatureBytes) {
+		// length prefix the fields, so they cannot be shifted
+		binary.BigEndian.PutUint64(b[:], uint64(len(field)))
+		_, _ = hasher.Write(b[:])
+		_, _ = hasher.Write(field)
+	}
+	if verifier.DEREncoded {
+		_, _ = hasher.Write([]byte("DER"))
+	}
+	return string(hasher.Sum(nil)), true
+}
+
+// publicKeyCacheFields returns the key type and raw public key values
+// of pub to identify it in a verification cache key
+//
+// It is cheaper than KeyThumbprint, which CBOR encodes and hashes the
+// key, since it runs on every cached verification.
+func publicKeyCacheFields(pub crypto.PublicKey) (fields [][]byte, ok bool) {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		if k == nil || k.Curve == nil || k.X == nil || k.Y == nil {
+			return nil, false
+		}
+		return [][]byte{[]byte("EC2"), []byte(k.Curve.Params().Name), k.X.Bytes(), k.Y.Bytes()}, true
+	case ed25519.PublicKey:
+		return [][]byte{[]byte("OKP"), k}, true
+	case *rsa.PublicKey:
+		if k == nil || k.N == nil {
+			return nil, false
+		}
+		var e [8]byte
+		binary.BigEndian.PutUint64(e[:], uint64(k.E))
+		return [][]byte{[]byte("RSA"), k.N.Bytes(), e[:]}, true
+	default:
+		return nil, false
+	}
+}
+
+// verifySignatureDigestCached verifies signature like
+// verifySignatureDigest skipping the signature verification when
+// cache has it and adding it to cache when it verifies
+func verifySignatureDigestCached(verifier *Verifier, signature *Signature, digest []byte, cache VerificationCache) (err error) {
+	key, ok := verificationCacheKey(verifier, signature, digest)
+	if !ok {
+		return verifySignatureDigest(verifier, signature, digest)
+	}
+	if cache.Contains(key) {
+		// the certificate thumbprint may be in the unprotected
+		// headers, so it is always checked
+		if verifier.Certificate != nil {
+			return verifyCertThumbprint(signature.Headers, verifier.Certificate)
+		}
+		return nil
+	}
+	err = verifySignatureDigest(verifier, signature, digest)
+	if err == nil {
+		cache.Add(key)
+	}
+	return err
+}
@@ -0,0 +1,174 @@
+package cose
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// countingCache counts the cache hits of a VerificationCache
+type countingCache struct {
+	VerificationCache
+	hits int
+	adds int
+}
+
+func (c *countingCache) Contains(key string) bool {
+	ok := c.VerificationCache.Contains(key)
+	if ok {
+		c.hits++
+	}
+	return ok
+}
+
+func (c *countingCache) Add(key string) {
+	c.adds++
+	c.VerificationCache.Add(key)
+}
+
+func TestLRUVerificationCache(t *testing.T) {
+	assert := assert.New(t)
+
+	cache := NewLRUVerificationCache(2)
+	cache.Add("a")
+	cache.Add("b")
+	assert.True(cache.Contains("a"))
+	cache.Add("c") // evicts b, the least recently used
+	assert.True(cache.Contains("a"))
+	assert.False(cache.Contains("b"))
+	assert.True(cache.Contains("c"))
+	cache.Add("c")
+	cache.Add("d") // evicts a
+	assert.False(cache.Contains("a"))
+	assert.True(cache.Contains("c"))
+	assert.True(cache.Contains("d"))
+
+	empty := NewLRUVerificationCache(0)
+	empty.Add("a")
+	assert.False(empty.Contains("a"))
+}
+
+func signedToken(t testing.TB, signer *Signer, payload string) *SignMessage {
+	msg := NewSignMessage()
+	msg.Payload = []byte(payload)
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = signer.alg.Value
+	msg.AddSignature(sig)
+	assert.Nil(t, msg.Sign(rand.Reader, nil, []Signer{*signer}))
+	return msg
+}
+
+func TestVerifyWithOptsCache(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	otherSigner, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+	cache := &countingCache{VerificationCache: NewLRUVerificationCache(10)}
+	opts := VerifyOpts{Cache: cache}
+
+	msg := signedToken(t, signer, "token")
+	assert.Nil(msg.VerifyWithOpts(nil, verifiers, opts))
+	assert.Equal(0, cache.hits)
+	assert.Equal(1, cache.adds)
+	assert.Nil(msg.VerifyWithOpts(nil, verifiers, opts))
+	assert.Equal(1, cache.hits)
+	assert.Equal(1, cache.adds)
+
+	// a different external, key, or signature is a miss
+	assert.Equal(ErrECDSAVerification, msg.VerifyWithOpts([]byte("external"), verifiers, opts))
+	assert.Equal(ErrECDSAVerification, msg.VerifyWithOpts(nil, []Verifier{*otherSigner.Verifier()}, opts))
+	msg.Signatures[0].SignatureBytes[0] ^= 0xff
+	assert.Equal(ErrECDSAVerification, msg.VerifyWithOpts(nil, verifiers, opts))
+	assert.Equal(1, cache.hits)
+	assert.Equal(1, cache.adds)
+
+	// failures are not cached
+	assert.Equal(ErrECDSAVerification, msg.VerifyWithOpts(nil, verifiers, opts))
+	assert.Equal(1, cache.hits)
+	msg.Signatures[0].SignatureBytes[0] ^= 0xff
+
+	// the x5t header is checked on a cache hit
+	_, _, cert, _ := testCertChain(t)
+	verifier := *signer.Verifier()
+	verifier.Certificate = cert
+	assert.Nil(msg.VerifyWithOpts(nil, []Verifier{verifier}, opts))
+	assert.Equal(2, cache.hits)
+	msg.Signatures[0].Headers.Unprotected["x5t"] = []interface{}{-16, []byte("other cert")}
+	assert.Equal(ErrCertThumbprintMismatch, msg.VerifyWithOpts(nil, []Verifier{verifier}, opts))
+	assert.Equal(3, cache.hits)
+}
+
+func TestVerificationCacheKey(t *testing.T) {
+	assert := assert.New(t)
+
+	signature := NewSignature()
+	signature.SignatureBytes = []byte("signature")
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+	verifiers := []*Verifier{{PublicKey: edKey, Alg: getAlgByNameOrPanic("EdDSA")}}
+	for _, alg := range []*Algorithm{ES256, PS256} {
+		signer, err := NewSigner(alg, nil)
+		assert.Nil(err, "Error creating signer")
+		verifiers = append(verifiers, signer.Verifier())
+	}
+
+	var keys []string
+	for _, verifier := range verifiers {
+		key, ok := verificationCacheKey(verifier, signature, []byte("digest"))
+		assert.True(ok, verifier.Alg.Name)
+		assert.NotContains(keys, key, verifier.Alg.Name)
+		keys = append(keys, key)
+
+		// a copy of the same public key has the same key
+		data, err := MarshalCOSEKeySet([]COSEKey{{PublicKey: verifier.PublicKey}})
+		assert.Nil(err)
+		parsed, err := ParseCOSEKeySet(data)
+		assert.Nil(err)
+		other, ok := verificationCacheKey(&Verifier{PublicKey: parsed[0].PublicKey, Alg: verifier.Alg}, signature, []byte("digest"))
+		assert.True(ok, verifier.Alg.Name)
+		assert.Equal(key, other, verifier.Alg.Name)
+	}
+
+	_, ok := verificationCacheKey(&Verifier{PublicKey: "not a key", Alg: ES256}, signature, []byte("digest"))
+	assert.False(ok)
+	_, ok = verificationCacheKey(&Verifier{PublicKey: (*ecdsa.PublicKey)(nil), Alg: ES256}, signature, []byte("digest"))
+	assert.False(ok)
+	_, ok = verificationCacheKey(&Verifier{PublicKey: (*rsa.PublicKey)(nil), Alg: PS256}, signature, []byte("digest"))
+	assert.False(ok)
+}
+
+func BenchmarkVerifyHotTokens(b *testing.B) {
+	signer, err := NewSigner(ES256, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	verifiers := []Verifier{*signer.Verifier()}
+	var tokens []*SignMessage
+	for i := 0; i < 10; i++ {
+		tokens = append(tokens, signedToken(b, signer, fmt.Sprintf("token %d", i)))
+	}
+
+	for _, benchmark := range []struct {
+		name string
+		opts VerifyOpts
+	}{
+		{"NoCache", VerifyOpts{}},
+		{"LRUCache", VerifyOpts{Cache: NewLRUVerificationCache(100)}},
+	} {
+		b.Run(benchmark.name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				err := tokens[n%len(tokens)].VerifyWithOpts(nil, verifiers, benchmark.opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
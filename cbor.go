@@ -2,7 +2,9 @@ package cose
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"math"
 	"reflect"
 
 	"github.com/fxamacker/cbor/v2"
@@ -35,12 +37,37 @@ func IsSignMessage(data []byte) bool {
 	return bytes.HasPrefix(data, signMessagePrefix)
 }
 
+// Limits for decoding untrusted COSE messages. Decoding returns
+// ErrTooManySignatures, ErrTooManyHeaders, or ErrMaxDepthExceeded
+// before decoding the signatures or headers when a message exceeds
+// them.
+var (
+	// MaxSignatures is the maximum number of signatures in a
+	// COSE_Sign message
+	MaxSignatures = 1024
+
+	// MaxHeaderMapPairs is the maximum number of labels in a
+	// protected or unprotected header map
+	MaxHeaderMapPairs = 256
+
+	// MaxHeaderDepth is the maximum nesting depth of CBOR arrays,
+	// maps, and tags in a protected or unprotected header map
+	// counting the header map itself
+	MaxHeaderDepth = 16
+)
+
 // Readonly CBOR encoding and decoding modes.
 var (
 	encMode, encModeError = initCBOREncMode()
 	decMode, decModeError = initCBORDecMode()
 )
 
+// initCBOREncMode returns the mode for encoding messages and the
+// signed structures i.e. protected header maps and Sig_structures
+//
+// Maps and arrays are always encoded with definite lengths, and
+// indefinite length encoding is forbidden, so signed bytes match other
+// implementations' deterministic encoding.
 func initCBOREncMode() (en cbor.EncMode, err error) {
 	encOpt := cbor.EncOptions{
 		IndefLength: cbor.IndefLengthForbidden, // no streaming
@@ -97,12 +124,68 @@ func Marshal(o interface{}) (b []byte, err error) {
 	return encMode.Marshal(o)
 }
 
+// MarshalExternalAAD returns the canonical CBOR encoding of param o to
+// use as the external data when signing or verifying
+//
+// Signers and verifiers that encode the same structure get identical
+// external_aad bytes.
+//
+// https://tools.ietf.org/html/rfc8152#section-4.3
+func MarshalExternalAAD(o interface{}) (external []byte, err error) {
+	external, err = Marshal(o)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling external_aad")
+	}
+	return external, nil
+}
+
 // Unmarshal returns the CBOR decoding of a []byte into param o
+//
+// Indefinite length items are rejected with ErrNonCanonicalEncoding.
 func Unmarshal(b []byte) (o interface{}, err error) {
 	err = decMode.Unmarshal(b, &o)
+	if isIndefiniteLengthError(err) {
+		return nil, ErrNonCanonicalEncoding
+	} else if isMaxNestedLevelError(err) {
+		return nil, ErrMaxDepthExceeded
+	}
 	return o, err
 }
 
+// DecodeOptions are options for decoding untrusted COSE messages with
+// UnmarshalWithOptions
+//
+// Indefinite length items in the message or its protected headers are
+// always rejected with ErrNonCanonicalEncoding, since the signed bytes
+// must be the deterministic encoding other implementations produce.
+type DecodeOptions struct {
+	// Strict rejects data with bytes after the top-level CBOR item
+	// with ErrTrailingData
+	Strict bool
+}
+
+// UnmarshalWithOptions returns the CBOR decoding of a []byte into
+// param o using the DecodeOptions opts
+func UnmarshalWithOptions(b []byte, opts DecodeOptions) (o interface{}, err error) {
+	if !opts.Strict {
+		return Unmarshal(b)
+	}
+
+	decoder := decMode.NewDecoder(bytes.NewReader(b))
+	err = decoder.Decode(&o)
+	if isIndefiniteLengthError(err) {
+		return nil, ErrNonCanonicalEncoding
+	} else if isMaxNestedLevelError(err) {
+		return nil, ErrMaxDepthExceeded
+	} else if err != nil {
+		return nil, err
+	}
+	if decoder.NumBytesRead() != len(b) {
+		return nil, ErrTrailingData
+	}
+	return o, nil
+}
+
 type signature struct {
 	_              struct{} `cbor:",toarray"`
 	Protected      []byte
@@ -136,6 +219,10 @@ func (message *SignMessage) MarshalCBOR() ([]byte, error) {
 		if dup != nil {
 			return nil, fmt.Errorf("cbor: Duplicate signature header %+v found", dup)
 		}
+		// an unsigned signature is not a valid COSE_Signature
+		if len(s.SignatureBytes) < 1 {
+			return nil, ErrNoSignatureBytes
+		}
 
 		sigs[i] = signature{
 			Protected:      s.Headers.EncodeProtected(),
@@ -158,6 +245,9 @@ func (message *SignMessage) MarshalCBOR() ([]byte, error) {
 
 // UnmarshalCBOR decodes data into SignMessage.
 //
+// It returns an error wrapping ErrWrongMessageType for another COSE
+// message e.g. a COSE_Sign1 from its tag or the arity of its array.
+//
 // Unpacks a SignMessage described by CDDL fragments:
 //
 // COSE_Sign = [
@@ -201,37 +291,65 @@ func (message *SignMessage) UnmarshalCBOR(data []byte) (err error) {
 	// Decode to cbor.RawTag to extract tag number and tag content as []byte.
 	var raw cbor.RawTag
 	err = decMode.Unmarshal(data, &raw)
-	if err != nil {
+	if isIndefiniteLengthError(err) {
+		return ErrNonCanonicalEncoding
+	} else if isMaxNestedLevelError(err) {
+		return ErrMaxDepthExceeded
+	} else if err != nil {
+		// Reject another untagged COSE message e.g. a COSE_Sign1 by
+		// its arity instead of failing on the missing tag
+		if t := cborArrayMessageType(data); t != coseMessageUnknown && t != coseMessageSign {
+			return errors.Wrapf(ErrWrongMessageType, "expected COSE_Sign (tag 98); got untagged %s", t)
+		}
 		return err
 	}
 
 	// Verify tag number.
 	if raw.Number != SignMessageCBORTag {
+		if t, ok := coseMessageTags[raw.Number]; ok {
+			return errors.Wrapf(ErrWrongMessageType, "expected COSE_Sign (tag 98); got %s (tag %d)", t, raw.Number)
+		}
 		return fmt.Errorf("cbor: wrong tag number %d", raw.Number)
 	}
 
+	err = checkSignMessageLimits(raw.Content)
+	if err != nil {
+		return err
+	}
+	if cborArrayMessageType(raw.Content) == coseMessageSign1 {
+		return errors.Wrap(ErrWrongMessageType, "expected COSE_Sign (tag 98); got COSE_Sign1 content tagged as COSE_Sign")
+	}
+
 	// Decode tag content to signMessage.
 	var m signMessage
 	err = decMode.Unmarshal(raw.Content, &m)
-	if err != nil {
+	if isIndefiniteLengthError(err) {
+		return ErrNonCanonicalEncoding
+	} else if err != nil {
 		return err
 	}
 
 	// Create Headers from signMessage.
 	msgHeaders := &Headers{}
 	err = msgHeaders.Decode([]interface{}{m.Protected, m.Unprotected})
-	if err != nil {
+	if err == ErrNonCanonicalEncoding {
+		return err
+	} else if err != nil {
 		return fmt.Errorf("cbor: %s", err.Error())
 	}
+	keepNonCanonicalProtected(msgHeaders, m.Protected)
 
 	// Create Signature from signMessage.
 	var sigs []Signature
 	for _, s := range m.Signatures {
 		sh := &Headers{}
 		err = sh.Decode([]interface{}{s.Protected, s.Unprotected})
-		if err != nil {
+		if err == ErrNonCanonicalEncoding {
+			return err
+		} else if err != nil {
 			return fmt.Errorf("cbor: %s", err.Error())
 		}
+		keepNonCanonicalProtected(sh, s.Protected)
 
 		sigs = append(sigs, Signature{
 			Headers:        sh,
@@ -246,3 +364,386 @@ func (message *SignMessage) UnmarshalCBOR(data []byte) (err error) {
 	}
 	return nil
 }
+
+// isIndefiniteLengthError returns true when err is from decoding an
+// indefinite length CBOR item, which decMode does not allow
+func isIndefiniteLengthError(err error) bool {
+	_, ok := err.(*cbor.IndefiniteLengthError)
+	return ok
+}
+
+// isMaxNestedLevelError returns true when err is from decoding CBOR
+// nested deeper than the decMode limit, which is above MaxHeaderDepth
+func isMaxNestedLevelError(err error) bool {
+	_, ok := err.(*cbor.MaxNestedLevelError)
+	return ok
+}
+
+// keepNonCanonicalProtected sets h.RawProtected to the decoded
+// protected bytes when they are not the canonical encoding of
+// h.Protected so signatures over them still verify
+//
+// Bytes with duplicate labels are not kept, since other
+// implementations may read a different value for a label than the
+// last one in h.Protected, so signatures over them do not verify.
+func keepNonCanonicalProtected(h *Headers, protected []byte) {
+	if len(protected) == 0 {
+		return
+	}
+	n, ok := cborContainerLen(protected, cborMajorTypeMap)
+	if !ok || n != uint64(len(h.Protected)) {
+		return
+	}
+	canonical, err := encMode.Marshal(h.Protected)
+	if err != nil || !bytes.Equal(canonical, protected) {
+		h.RawProtected = protected
+	}
+}
+
+// checkRawProtected returns an error wrapping ErrRawProtectedMismatch
+// when h.RawProtected is set and does not decode to h.Protected or has
+// duplicate labels
+func checkRawProtected(h *Headers) (err error) {
+	if h.RawProtected == nil {
+		return nil
+	}
+	expected, err := encMode.Marshal(CompressHeaders(h.Protected))
+	if err != nil {
+		return errors.Wrap(err, "error encoding protected headers")
+	}
+	if len(h.RawProtected) == 0 {
+		if len(h.Protected) > 0 {
+			return errors.Wrap(ErrRawProtectedMismatch, "RawProtected is empty")
+		}
+		return nil
+	}
+
+	decoded, err := Unmarshal(h.RawProtected)
+	if err != nil {
+		return errors.Wrapf(ErrRawProtectedMismatch, "error decoding RawProtected: %s", err)
+	}
+	decodedMap, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return errors.Wrapf(ErrRawProtectedMismatch, "RawProtected is not a map; got %T", decoded)
+	}
+	n, ok := cborContainerLen(h.RawProtected, cborMajorTypeMap)
+	if !ok || n != uint64(len(decodedMap)) {
+		return errors.Wrap(ErrRawProtectedMismatch, "RawProtected has duplicate labels")
+	}
+	compressed, err := compressHeadersChecked(decodedMap)
+	if err != nil {
+		return errors.Wrapf(ErrRawProtectedMismatch, "RawProtected: %s", err)
+	}
+	actual, err := encMode.Marshal(compressed)
+	if err != nil || !bytes.Equal(expected, actual) {
+		return ErrRawProtectedMismatch
+	}
+	return nil
+}
+
+const (
+	cborMajorTypeByteString = 2
+	cborMajorTypeTextString = 3
+	cborMajorTypeArray      = 4
+	cborMajorTypeMap        = 5
+	cborMajorTypeTag        = 6
+)
+
+// cborHead returns the shortest CBOR head for an item of majorType
+// with argument n e.g. the length of a byte string
+func cborHead(majorType byte, n uint64) (head []byte) {
+	switch {
+	case n < 24:
+		return []byte{majorType<<5 | byte(n)}
+	case n <= math.MaxUint8:
+		return []byte{majorType<<5 | 24, byte(n)}
+	case n <= math.MaxUint16:
+		head = []byte{majorType<<5 | 25, 0, 0}
+		binary.BigEndian.PutUint16(head[1:], uint16(n))
+	case n <= math.MaxUint32:
+		head = []byte{majorType<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(head[1:], uint32(n))
+	default:
+		head = []byte{majorType<<5 | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(head[1:], n)
+	}
+	return head
+}
+
+// cborHeadArgument returns the argument (e.g. a length) and the size
+// in bytes of the definite length CBOR head at the start of b
+func cborHeadArgument(b []byte) (n uint64, headLen int, ok bool) {
+	if len(b) < 1 {
+		return 0, 0, false
+	}
+	ai := b[0] & 0x1f
+	switch {
+	case ai < 24:
+		return uint64(ai), 1, true
+	case ai == 24 && len(b) >= 2:
+		return uint64(b[1]), 2, true
+	case ai == 25 && len(b) >= 3:
+		return uint64(binary.BigEndian.Uint16(b[1:3])), 3, true
+	case ai == 26 && len(b) >= 5:
+		return uint64(binary.BigEndian.Uint32(b[1:5])), 5, true
+	case ai == 27 && len(b) >= 9:
+		return binary.BigEndian.Uint64(b[1:9]), 9, true
+	}
+	return 0, 0, false
+}
+
+// cborContainerLen returns the number of items in the CBOR array or
+// map (i.e. majorType) encoded in b from its head without decoding
+// the items
+func cborContainerLen(b []byte, majorType byte) (n uint64, ok bool) {
+	if len(b) < 1 || b[0]>>5 != majorType {
+		return 0, false
+	}
+	n, _, ok = cborHeadArgument(b)
+	return n, ok
+}
+
+// cborNestingExceeds returns true when the CBOR item encoded in b
+// nests arrays, maps, and tags more than maxDepth levels deep
+//
+// It walks the encoding without recursion or decoding the items.
+// Malformed items are left for the full decode to report.
+func cborNestingExceeds(b []byte, maxDepth int) bool {
+	// the number of items left in each open container with the top
+	// level item first
+	remaining := []uint64{1}
+	offset := 0
+	for len(remaining) > 0 {
+		top := len(remaining) - 1
+		if remaining[top] == 0 {
+			remaining = remaining[:top]
+			continue
+		}
+		remaining[top]--
+
+		if offset >= len(b) {
+			return false
+		}
+		majorType := b[offset] >> 5
+		n, headLen, ok := cborHeadArgument(b[offset:])
+		if !ok {
+			return false
+		}
+		offset += headLen
+		// every byte or item of a string or container takes at least
+		// one byte
+		if majorType >= cborMajorTypeByteString && majorType <= cborMajorTypeMap && n > uint64(len(b)) {
+			return false
+		}
+
+		switch majorType {
+		case cborMajorTypeByteString, cborMajorTypeTextString:
+			offset += int(n)
+		case cborMajorTypeArray:
+			remaining = append(remaining, n)
+		case cborMajorTypeMap:
+			remaining = append(remaining, 2*n)
+		case cborMajorTypeTag:
+			remaining = append(remaining, 1)
+		}
+		if len(remaining)-1 > maxDepth {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHeaderMapLimit returns ErrTooManyHeaders when b is a CBOR map
+// with more than MaxHeaderMapPairs labels or ErrMaxDepthExceeded when
+// it nests more than MaxHeaderDepth levels deep
+func checkHeaderMapLimit(b []byte) error {
+	if n, ok := cborContainerLen(b, cborMajorTypeMap); ok && n > uint64(MaxHeaderMapPairs) {
+		return ErrTooManyHeaders
+	}
+	if cborNestingExceeds(b, MaxHeaderDepth) {
+		return ErrMaxDepthExceeded
+	}
+	return nil
+}
+
+// checkHeadersLimit checks the raw protected bstr and unprotected map
+// of a COSE_Sign or COSE_Signature against MaxHeaderMapPairs
+func checkHeadersLimit(protected, unprotected cbor.RawMessage) error {
+	var b []byte
+	if decMode.Unmarshal(protected, &b) == nil {
+		err := checkHeaderMapLimit(b)
+		if err != nil {
+			return err
+		}
+	}
+	return checkHeaderMapLimit(unprotected)
+}
+
+// checkSignMessageLimits checks the number of signatures and headers
+// in the content of a COSE_Sign message against MaxSignatures and
+// MaxHeaderMapPairs
+//
+// Malformed content is left for the full decode to report.
+func checkSignMessageLimits(content []byte) (err error) {
+	var items []cbor.RawMessage
+	if decMode.Unmarshal(content, &items) != nil || len(items) != 4 {
+		return nil
+	}
+	err = checkHeadersLimit(items[0], items[1])
+	if err != nil {
+		return err
+	}
+
+	n, ok := cborContainerLen(items[3], cborMajorTypeArray)
+	if !ok {
+		return nil
+	}
+	if n > uint64(MaxSignatures) {
+		return ErrTooManySignatures
+	}
+
+	var sigs []cbor.RawMessage
+	if decMode.Unmarshal(items[3], &sigs) != nil {
+		return nil
+	}
+	for _, sig := range sigs {
+		var sigItems []cbor.RawMessage
+		if decMode.Unmarshal(sig, &sigItems) != nil || len(sigItems) != 3 {
+			return nil
+		}
+		err = checkHeadersLimit(sigItems[0], sigItems[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// coseMessageType is a COSE structure identified from the CBOR heads
+// of its encoding
+type coseMessageType int
+
+const (
+	coseMessageUnknown coseMessageType = iota
+	coseMessageSign
+	coseMessageSign1
+	coseMessageSignature
+	coseMessageEncrypt
+	coseMessageEncrypt0
+	coseMessageMac
+	coseMessageMac0
+)
+
+// coseMessageTags are the CBOR tags for COSE message types
+// from https://tools.ietf.org/html/rfc8152#section-2
+var coseMessageTags = map[uint64]coseMessageType{
+	16:                 coseMessageEncrypt0,
+	17:                 coseMessageMac0,
+	18:                 coseMessageSign1,
+	96:                 coseMessageEncrypt,
+	97:                 coseMessageMac,
+	SignMessageCBORTag: coseMessageSign,
+}
+
+func (t coseMessageType) String() string {
+	switch t {
+	case coseMessageSign:
+		return "COSE_Sign"
+	case coseMessageSign1:
+		return "COSE_Sign1"
+	case coseMessageSignature:
+		return "COSE_Signature"
+	case coseMessageEncrypt:
+		return "COSE_Encrypt"
+	case coseMessageEncrypt0:
+		return "COSE_Encrypt0"
+	case coseMessageMac:
+		return "COSE_Mac"
+	case coseMessageMac0:
+		return "COSE_Mac0"
+	}
+	return "unknown COSE message"
+}
+
+// cborArrayMessageType returns the COSE signing structure for the
+// arity of the CBOR array in data and the major type of its last item
+// or coseMessageUnknown
+//
+// COSE_Sign and COSE_Sign1 both have 4 items and end with an array of
+// COSE_Signature and a bstr signature respectively. Only the item
+// heads are read.
+func cborArrayMessageType(data []byte) coseMessageType {
+	n, ok := cborContainerLen(data, cborMajorTypeArray)
+	if !ok {
+		return coseMessageUnknown
+	}
+	switch n {
+	case 3:
+		return coseMessageSignature
+	case 4:
+	default:
+		return coseMessageUnknown
+	}
+
+	_, offset, _ := cborHeadArgument(data)
+	for i := 0; i < 3; i++ {
+		itemLen, ok := cborItemLen(data[offset:])
+		if !ok {
+			return coseMessageUnknown
+		}
+		offset += itemLen
+	}
+	if offset >= len(data) {
+		return coseMessageUnknown
+	}
+	switch data[offset] >> 5 {
+	case cborMajorTypeByteString:
+		return coseMessageSign1
+	case cborMajorTypeArray:
+		return coseMessageSign
+	}
+	return coseMessageUnknown
+}
+
+// cborItemLen returns the length in bytes of the definite length CBOR
+// item at the start of b from the heads of it and its nested items
+// without decoding them
+func cborItemLen(b []byte) (length int, ok bool) {
+	// the number of items left in each open container with the top
+	// level item first
+	remaining := []uint64{1}
+	offset := 0
+	for len(remaining) > 0 {
+		top := len(remaining) - 1
+		if remaining[top] == 0 {
+			remaining = remaining[:top]
+			continue
+		}
+		remaining[top]--
+
+		if offset >= len(b) {
+			return 0, false
+		}
+		majorType := b[offset] >> 5
+		n, headLen, ok := cborHeadArgument(b[offset:])
+		if !ok {
+			return 0, false
+		}
+		offset += headLen
+		if majorType >= cborMajorTypeByteString && majorType <= cborMajorTypeMap && n > uint64(len(b)-offset) {
+			return 0, false
+		}
+
+		switch majorType {
+		case cborMajorTypeByteString, cborMajorTypeTextString:
+			offset += int(n)
+		case cborMajorTypeArray:
+			remaining = append(remaining, n)
+		case cborMajorTypeMap:
+			remaining = append(remaining, 2*n)
+		case cborMajorTypeTag:
+			remaining = append(remaining, 1)
+		}
+	}
+	return offset, true
+}
@@ -1,8 +1,12 @@
 package cose
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/stretchr/testify/assert"
@@ -152,6 +156,29 @@ func TestCBORMarshalSignMessageWithNilHeadersErrors(t *testing.T) {
 	assert.Equal("cbor: SignMessage has nil Headers", err.Error())
 }
 
+func TestCBORMarshalUnsignedSignatureErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+	_, err := Marshal(msg)
+	assert.Equal(ErrNoSignatureBytes, err)
+
+	msg.Signatures[0].SignatureBytes = []byte{}
+	_, err = Marshal(msg)
+	assert.Equal(ErrNoSignatureBytes, err)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	msg.Signatures[0].SignatureBytes = nil
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+	_, err = Marshal(msg)
+	assert.Nil(err)
+}
+
 func TestCBORMarshalDuplicateKeysErrs(t *testing.T) {
 	assert := assert.New(t)
 
@@ -526,12 +553,32 @@ func TestCBORDecodingToSignMessageErrors(t *testing.T) {
 			HexToBytesOrDie("D8638440A0F680"), // tag(99) + array(4) [ bytes(0), map(0), nil, array(0)]
 			"cbor: wrong tag number 99",
 		},
+		{
+			"COSE_Sign1 tag number",
+			HexToBytesOrDie("D28440A0F640"), // tag(18) + array(4) [ bytes(0), map(0), nil, bytes(0)]
+			"expected COSE_Sign (tag 98); got COSE_Sign1 (tag 18): COSE message type does not match the decoder",
+		},
+		{
+			"untagged COSE_Sign1",
+			HexToBytesOrDie("8440A0F640"), // array(4) [ bytes(0), map(0), nil, bytes(0)]
+			"expected COSE_Sign (tag 98); got untagged COSE_Sign1: COSE message type does not match the decoder",
+		},
+		{
+			"COSE_Sign1 content with the COSE_Sign tag number",
+			HexToBytesOrDie("D8628440A0F640"), // tag(98) + array(4) [ bytes(0), map(0), nil, bytes(0)]
+			"expected COSE_Sign (tag 98); got COSE_Sign1 content tagged as COSE_Sign: COSE message type does not match the decoder",
+		},
+		{
+			"COSE_Mac tag number",
+			HexToBytesOrDie("D8618540A0F68080"), // tag(97) + array(5)
+			"expected COSE_Sign (tag 98); got COSE_Mac (tag 97): COSE message type does not match the decoder",
+		},
 	}
 
 	for _, testCase := range cases {
 		var msg SignMessage
 		err := cbor.Unmarshal(testCase.bytes, &msg)
-		assert.Equal(testCase.errorMessage, err.Error())
+		assert.Equal(testCase.errorMessage, err.Error(), testCase.name)
 	}
 }
 
@@ -553,3 +600,546 @@ func TestUnmarshalToNilSignMessage(t *testing.T) {
 	err := msg.UnmarshalCBOR(b)
 	assert.Equal("cbor: UnmarshalCBOR on nil SignMessage pointer", err.Error())
 }
+
+func TestCBORMarshalUnprotectedHeadersDeterministic(t *testing.T) {
+	assert := assert.New(t)
+
+	newMsg := func() *SignMessage {
+		msg := NewSignMessage()
+		msg.Payload = []byte("payload")
+		msg.Headers.Unprotected = map[interface{}]interface{}{
+			"kid":          []byte("11"),
+			"content type": "text/plain",
+			-70000:         "negative",
+			"private":      true,
+			100:            []interface{}{1, 2, 3},
+			-1:             map[interface{}]interface{}{"b": 2, "a": 1, 3: 0},
+		}
+		sig := NewSignature()
+		sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+		sig.Headers.Unprotected = map[interface{}]interface{}{
+			"IV":         []byte("iv"),
+			"Partial IV": []byte("piv"),
+			77:           "x",
+			-77:          "y",
+		}
+		sig.SignatureBytes = []byte("signature")
+		msg.AddSignature(sig)
+		return msg
+	}
+
+	first, err := Marshal(newMsg())
+	assert.Nil(err)
+	firstHash := sha256.Sum256(first)
+
+	for i := 0; i < 20; i++ {
+		b, err := Marshal(newMsg())
+		assert.Nil(err)
+		assert.Equal(firstHash, sha256.Sum256(b), "marshalled message bytes differ")
+	}
+
+	// decoding and re-encoding gives the same bytes
+	decoded, err := Unmarshal(first)
+	assert.Nil(err)
+	roundtrip, err := Marshal(decoded)
+	assert.Nil(err)
+	assert.Equal(firstHash, sha256.Sum256(roundtrip))
+}
+
+func TestCBOREncodesDefiniteLengths(t *testing.T) {
+	assert := assert.New(t)
+
+	// the major type of the CBOR head in b and whether it has a
+	// definite length i.e. not additional information 31
+	head := func(b []byte) (majorType byte, definite bool) {
+		return b[0] >> 5, b[0]&0x1f != 31
+	}
+
+	for _, n := range []int{1, 23, 24, 300} {
+		h := &Headers{Protected: map[interface{}]interface{}{CommonHeaderIDAlg: ES256.Value}}
+		for i := 1; i < n; i++ {
+			h.Protected[-1000-i] = []interface{}{i, map[interface{}]interface{}{"n": i}}
+		}
+		protected := h.EncodeProtected()
+		majorType, definite := head(protected)
+		assert.Equal(byte(cborMajorTypeMap), majorType, "%d headers", n)
+		assert.True(definite, "%d headers", n)
+		if n < 24 {
+			assert.Equal(byte(0xa0+n), protected[0])
+		}
+		length, ok := cborContainerLen(protected, cborMajorTypeMap)
+		assert.True(ok)
+		assert.Equal(uint64(n), length)
+	}
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	msg.Headers.Protected["content type"] = "text/plain"
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+
+	ToBeSigned, err := msg.SigStructure(nil, &msg.Signatures[0])
+	assert.Nil(err)
+	assert.Equal(byte(0x85), ToBeSigned[0])
+
+	msg.Signatures[0].SignatureBytes = []byte("signature")
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+	assert.Equal(HexToBytesOrDie("D862"+"84"), msgBytes[:3])
+	// unprotected headers are a definite length map too
+	assert.Equal(byte(0xa0), msgBytes[3+1+len(msg.Headers.EncodeProtected())])
+}
+
+func TestMarshalExternalAAD(t *testing.T) {
+	assert := assert.New(t)
+
+	// map keys are sorted canonically
+	external, err := MarshalExternalAAD(map[interface{}]interface{}{
+		"method": "GET",
+		1:        []byte("\x01"),
+	})
+	assert.Nil(err)
+	assert.Equal(HexToBytesOrDie("A2"+"01"+"4101"+"666D6574686F64"+"63474554"), external)
+
+	type aad struct {
+		Method string `cbor:"1,keyasint"`
+		Path   string `cbor:"2,keyasint"`
+	}
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+
+	signerExternal, err := MarshalExternalAAD(aad{Method: "GET", Path: "/"})
+	assert.Nil(err)
+	assert.Nil(msg.Sign(rand.Reader, signerExternal, []Signer{*signer}))
+
+	verifierExternal, err := MarshalExternalAAD(aad{Method: "GET", Path: "/"})
+	assert.Nil(err)
+	assert.Nil(msg.Verify(verifierExternal, []Verifier{*signer.Verifier()}))
+
+	_, err = MarshalExternalAAD(make(chan int))
+	assert.NotNil(err)
+}
+
+func TestUnmarshalWithOptionsStrictRejectsTrailingData(t *testing.T) {
+	assert := assert.New(t)
+
+	b := HexToBytesOrDie("D862" + "84" + "40" + "A0" + "F6" + "80")
+
+	result, err := UnmarshalWithOptions(b, DecodeOptions{Strict: true})
+	assert.Nil(err)
+	assert.Equal(*NewSignMessage(), result)
+
+	trailing := append(append([]byte{}, b...), 0x00)
+
+	// the default decoding ignores trailing data
+	result, err = UnmarshalWithOptions(trailing, DecodeOptions{})
+	assert.Nil(err)
+	assert.Equal(*NewSignMessage(), result)
+
+	result, err = UnmarshalWithOptions(trailing, DecodeOptions{Strict: true})
+	assert.Nil(result)
+	assert.Equal(ErrTrailingData, err)
+
+	// a second message is trailing data too
+	result, err = UnmarshalWithOptions(append(append([]byte{}, b...), b...), DecodeOptions{Strict: true})
+	assert.Nil(result)
+	assert.Equal(ErrTrailingData, err)
+
+	result, err = UnmarshalWithOptions(b[:len(b)-1], DecodeOptions{Strict: true})
+	assert.Nil(result)
+	assert.NotNil(err)
+}
+
+func TestUnmarshalRejectsTooManySignatures(t *testing.T) {
+	assert := assert.New(t)
+
+	// COSE_Sign with an empty protected bstr, empty unprotected map,
+	// nil payload, and n empty signatures
+	message := func(n int) []byte {
+		b := HexToBytesOrDie("D862" + "84" + "40" + "A0" + "F6" + "99")
+		b = append(b, byte(n>>8), byte(n))
+		for i := 0; i < n; i++ {
+			b = append(b, HexToBytesOrDie("83"+"40"+"A0"+"40")...)
+		}
+		return b
+	}
+
+	result, err := Unmarshal(message(MaxSignatures))
+	assert.Nil(err)
+	assert.Len(result.(SignMessage).Signatures, MaxSignatures)
+
+	result, err = Unmarshal(message(MaxSignatures + 1))
+	assert.Nil(result)
+	assert.Equal(ErrTooManySignatures, err)
+
+	// the limit is checked from the array head before the
+	// signatures are decoded
+	b := HexToBytesOrDie("D862" + "84" + "40" + "A0" + "F6" + "9B" + "00000000FFFFFFFF")
+	result, err = Unmarshal(b)
+	assert.Nil(result)
+	assert.NotNil(err)
+}
+
+func TestUnmarshalRejectsTooManyHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	// a map of n int labels from start
+	headerMap := func(n, start int) []byte {
+		b := []byte{0xB9, byte(n >> 8), byte(n)}
+		for i := start; i < start+n; i++ {
+			b = append(b, 0x19, byte(i>>8), byte(i), 0x00)
+		}
+		return b
+	}
+	bstr := func(b []byte) []byte {
+		return append([]byte{0x59, byte(len(b) >> 8), byte(len(b))}, b...)
+	}
+	message := func(protected, unprotected, sigUnprotected []byte) []byte {
+		b := HexToBytesOrDie("D862" + "84")
+		b = append(b, bstr(protected)...)
+		b = append(b, unprotected...)
+		b = append(b, HexToBytesOrDie("F6"+"81"+"83"+"40")...)
+		b = append(b, sigUnprotected...)
+		return append(b, 0x40)
+	}
+	empty := HexToBytesOrDie("A0")
+
+	var tests = []struct {
+		name        string
+		protected   []byte
+		unprotected []byte
+		sigHeaders  []byte
+	}{
+		{"protected", headerMap(MaxHeaderMapPairs+1, 100), empty, empty},
+		{"unprotected", empty, headerMap(MaxHeaderMapPairs+1, 100), empty},
+		{"signature unprotected", empty, empty, headerMap(MaxHeaderMapPairs+1, 100)},
+	}
+	for _, test := range tests {
+		result, err := Unmarshal(message(test.protected, test.unprotected, test.sigHeaders))
+		assert.Nil(result, test.name)
+		assert.Equal(ErrTooManyHeaders, err, test.name)
+	}
+
+	result, err := Unmarshal(message(headerMap(MaxHeaderMapPairs, 100), headerMap(MaxHeaderMapPairs, 1000), headerMap(MaxHeaderMapPairs, 100)))
+	assert.Nil(err)
+	assert.Len(result.(SignMessage).Headers.Protected, MaxHeaderMapPairs)
+	assert.Len(result.(SignMessage).Headers.Unprotected, MaxHeaderMapPairs)
+}
+
+func TestUnmarshalRejectsDeepHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	// a header map with a label nesting arrays in a total of depth
+	// levels
+	headerMap := func(depth int) []byte {
+		return HexToBytesOrDie("A1" + "1863" + strings.Repeat("81", depth-1) + "F93C00")
+	}
+	message := func(protected, unprotected, sigUnprotected []byte) []byte {
+		b := HexToBytesOrDie("D862" + "84")
+		b = append(b, cborHead(cborMajorTypeByteString, uint64(len(protected)))...)
+		b = append(b, protected...)
+		b = append(b, unprotected...)
+		b = append(b, HexToBytesOrDie("F6"+"81"+"83"+"40")...)
+		b = append(b, sigUnprotected...)
+		return append(b, 0x40)
+	}
+	empty := HexToBytesOrDie("A0")
+
+	var tests = []struct {
+		name        string
+		protected   []byte
+		unprotected []byte
+		sigHeaders  []byte
+	}{
+		{"protected", headerMap(MaxHeaderDepth + 1), empty, empty},
+		{"unprotected", empty, headerMap(MaxHeaderDepth + 1), empty},
+		{"signature unprotected", empty, empty, headerMap(MaxHeaderDepth + 1)},
+		{"protected past the CBOR decoder limit", headerMap(100), empty, empty},
+		{"unprotected past the CBOR decoder limit", empty, headerMap(100), empty},
+	}
+	for _, test := range tests {
+		result, err := Unmarshal(message(test.protected, test.unprotected, test.sigHeaders))
+		assert.Nil(result, test.name)
+		assert.Equal(ErrMaxDepthExceeded, err, test.name)
+	}
+
+	result, err := Unmarshal(message(headerMap(MaxHeaderDepth), empty, headerMap(MaxHeaderDepth)))
+	assert.Nil(err)
+	assert.Len(result.(SignMessage).Headers.Protected, 1)
+	result, err = Unmarshal(message(empty, headerMap(MaxHeaderDepth), empty))
+	assert.Nil(err)
+	assert.Len(result.(SignMessage).Headers.Unprotected, 1)
+
+	var depths = []struct {
+		hex   string
+		depth int
+	}{
+		{"01", 0},
+		{"F93C00", 0},
+		{"FB3FF0000000000000", 0},
+		{"A0", 1},
+		{"A1" + "01" + "80", 2},
+		{"82" + "81" + "00" + "81" + "81" + "00", 3},
+		{"A1" + "1863" + "C1" + "1A514B67B0", 2},
+		{"A2" + "01" + "A1" + "01" + "80" + "02" + "00", 3},
+	}
+	for _, test := range depths {
+		b := HexToBytesOrDie(test.hex)
+		assert.False(cborNestingExceeds(b, test.depth), test.hex)
+		if test.depth > 0 {
+			assert.True(cborNestingExceeds(b, test.depth-1), test.hex)
+		}
+	}
+}
+
+func TestRawProtectedHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+
+	// kid (4) before alg (1) is not canonically sorted
+	rawProtected := HexToBytesOrDie("A2" + "04" + "426964" + "01" + "26")
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	sig.Headers.Protected[CommonHeaderIDKeyID] = []byte("id")
+	sig.Headers.RawProtected = rawProtected
+	msg.AddSignature(sig)
+	assert.Equal(rawProtected, sig.Headers.EncodeProtected())
+
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+	assert.Nil(msg.Verify(nil, verifiers))
+
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+	assert.True(bytes.Contains(msgBytes, append([]byte{0x47}, rawProtected...)))
+
+	// decoding keeps the non-canonical bytes so the signature
+	// verifies and the message re-marshals to the same bytes
+	decoded, err := Unmarshal(msgBytes)
+	assert.Nil(err)
+	decodedMsg := decoded.(SignMessage)
+	assert.Equal(rawProtected, decodedMsg.Signatures[0].Headers.RawProtected)
+	assert.Nil(decodedMsg.Verify(nil, verifiers))
+
+	remarshaled, err := Marshal(decodedMsg)
+	assert.Nil(err)
+	assert.Equal(msgBytes, remarshaled)
+
+	// canonical protected headers are not kept
+	assert.Nil(decodedMsg.Headers.RawProtected)
+	decodedMsg.Signatures[0].Headers.RawProtected = nil
+	assert.Equal(ErrECDSAVerification, decodedMsg.Verify(nil, verifiers))
+
+	// duplicate labels are not kept since implementations may read
+	// either alg e.g. ES384 then ES256
+	duplicateAlg := HexToBytesOrDie("A2" + "01" + "3822" + "01" + "26")
+	decodedSig, err := DecodeSignature(append(HexToBytesOrDie("83"+"46"), append(duplicateAlg, HexToBytesOrDie("A0"+"40")...)...))
+	assert.Nil(err)
+	assert.Nil(decodedSig.Headers.RawProtected)
+	assert.Equal(ES256.Value, decodedSig.Headers.Protected[CommonHeaderIDAlg])
+
+	// signing checks RawProtected decodes to Protected
+	var tests = []struct {
+		rawProtected string
+		err          string
+	}{
+		{"A2" + "01" + "3822" + "01" + "26", "SignMessage signature 0: RawProtected has duplicate labels: Headers.RawProtected does not decode to Headers.Protected"},
+		{"A1" + "01" + "3822", "SignMessage signature 0: Headers.RawProtected does not decode to Headers.Protected"},
+		{"A2" + "63" + "616C67" + "26" + "01" + "26", "SignMessage signature 0: RawProtected: Duplicate compressed and uncompressed common header 1 found: Headers.RawProtected does not decode to Headers.Protected"},
+		{"80", "SignMessage signature 0: RawProtected is not a map; got []interface {}: Headers.RawProtected does not decode to Headers.Protected"},
+		{"", "SignMessage signature 0: RawProtected is empty: Headers.RawProtected does not decode to Headers.Protected"},
+		{"A1" + "63" + "616C67" + "65" + "4553323536", ""},
+	}
+	for _, test := range tests {
+		msg := NewSignMessage()
+		msg.Payload = []byte("payload")
+		sig := NewSignature()
+		sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+		sig.Headers.RawProtected = HexToBytesOrDie(test.rawProtected)
+		msg.AddSignature(sig)
+		err := msg.Sign(rand.Reader, nil, []Signer{*signer})
+		if test.err == "" {
+			assert.Nil(err, test.rawProtected)
+			assert.Nil(msg.Verify(nil, verifiers))
+		} else {
+			assert.Equal(test.err, err.Error(), test.rawProtected)
+		}
+	}
+}
+
+func TestCBOREmptyUnprotectedHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	// nil and empty unprotected headers marshal to an empty map
+	for _, unprotected := range []map[interface{}]interface{}{nil, {}} {
+		msg := &SignMessage{
+			Headers: &Headers{Unprotected: unprotected},
+			Payload: []byte(""),
+			Signatures: []Signature{{
+				Headers:        &Headers{Protected: map[interface{}]interface{}{1: -7}, Unprotected: unprotected},
+				SignatureBytes: []byte("\x01"),
+			}},
+		}
+		b, err := Marshal(msg)
+		assert.Nil(err)
+		assert.Equal(HexToBytesOrDie("D862"+"84"+"40"+"A0"+"40"+"81"+"83"+"43A10126"+"A0"+"4101"), b)
+	}
+
+	// empty and null unprotected headers decode to an empty map
+	expected := SignMessage{
+		Headers: &Headers{
+			Protected:   map[interface{}]interface{}{},
+			Unprotected: map[interface{}]interface{}{},
+		},
+		Payload: []byte(""),
+		Signatures: []Signature{{
+			Headers: &Headers{
+				Protected:   map[interface{}]interface{}{1: -7},
+				Unprotected: map[interface{}]interface{}{},
+			},
+			SignatureBytes: []byte("\x01"),
+		}},
+	}
+	for _, unprotected := range []string{"A0", "F6"} {
+		result, err := Unmarshal(HexToBytesOrDie("D862" + "84" + "40" + unprotected + "40" + "81" + "83" + "43A10126" + unprotected + "4101"))
+		assert.Nil(err, unprotected)
+		assert.Equal(expected, result, unprotected)
+	}
+
+	sig := NewSignature()
+	sig.Decode([]interface{}{[]byte("\xA1\x01\x26"), nil, []byte("\x01")})
+	assert.Equal(map[interface{}]interface{}{}, sig.Headers.Unprotected)
+}
+
+func TestUnmarshalIndefiniteLengthItems(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, testCase := range []struct {
+		name string
+		hex  string
+	}{
+		{"indefinite length message array", "D8629F40A0F6818343A10126A042ABCDFF"},
+		{"indefinite length payload", "D8628440A05F4101FF818343A10126A042ABCD"},
+		{"indefinite length protected bstr", "D862845F4101FFA0F6818343A10126A042ABCD"},
+		{"indefinite length protected map", "D8628445BF0126FFFFA0F6818343A10126A042ABCD"},
+		{"indefinite length signature protected map", "D8628440A0F6818345BF0126FFFFA042ABCD"},
+		{"indefinite length unprotected map", "D8628440BF01F6FFF6818343A10126A042ABCD"},
+	} {
+		data := HexToBytesOrDie(testCase.hex)
+
+		_, err := UnmarshalWithOptions(data, DecodeOptions{Strict: true})
+		assert.Equal(ErrNonCanonicalEncoding, err, testCase.name)
+
+		// lenient decoding rejects them the same way
+		_, err = UnmarshalWithOptions(data, DecodeOptions{})
+		assert.Equal(ErrNonCanonicalEncoding, err, testCase.name)
+		var msg SignMessage
+		assert.Equal(ErrNonCanonicalEncoding, msg.UnmarshalCBOR(data), testCase.name)
+	}
+}
+
+func TestDecodeNestedUnprotectedHeaderValues(t *testing.T) {
+	assert := assert.New(t)
+
+	_, intermediate, leaf, key := testCertChain(t)
+	signer, err := NewSignerFromKey(ES256, key)
+	assert.Nil(err, "Error creating signer")
+	witness, err := NewSigner(ES384, nil)
+	assert.Nil(err, "Error creating signer")
+
+	nested := map[interface{}]interface{}{
+		"list": []interface{}{int64(1), map[interface{}]interface{}{int64(2): []byte("bytes")}},
+	}
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	msg.Headers.Unprotected[-70000] = nested
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	sig.Headers.Unprotected[CommonHeaderIDX5Chain] = []interface{}{leaf.Raw, intermediate.Raw}
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+	assert.Nil(msg.CounterSign(rand.Reader, 0, nil, newCounterSignature(ES384, "witness"), *witness))
+
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+	decoded, err := Unmarshal(msgBytes)
+	assert.Nil(err)
+	decodedMsg := decoded.(SignMessage)
+
+	value, found := decodedMsg.Headers.Lookup(-70000)
+	assert.True(found)
+	assert.Equal(nested, value)
+
+	headers := decodedMsg.Signatures[0].Headers
+	value, found = headers.Lookup("x5chain")
+	assert.True(found)
+	assert.Equal([]interface{}{leaf.Raw, intermediate.Raw}, value)
+	chain, err := headers.X5Chain()
+	assert.Nil(err)
+	assert.Len(chain, 2)
+	assert.True(leaf.Equal(chain[0]))
+	assert.True(intermediate.Equal(chain[1]))
+
+	value, found = headers.Lookup("counter signature v2")
+	assert.True(found)
+	assert.Len(value, 1)
+	results, err := decodedMsg.VerifyCounterSignatures(0, nil, func(kid []byte) (*Verifier, error) {
+		return witness.Verifier(), nil
+	})
+	assert.Nil(err)
+	assert.Len(results, 1)
+	assert.Nil(results[0].Err)
+	assert.Nil(decodedMsg.Verify(nil, []Verifier{*signer.Verifier()}))
+
+	// re-encodes to the same bytes
+	reencoded, err := Marshal(decodedMsg)
+	assert.Nil(err)
+	assert.Equal(msgBytes, reencoded)
+
+	// a single certificate x5chain
+	headers.Unprotected[CommonHeaderIDX5Chain] = leaf.Raw
+	chain, err = headers.X5Chain()
+	assert.Nil(err)
+	assert.Len(chain, 1)
+	headers.Delete("x5chain")
+	_, err = headers.X5Chain()
+	assert.Equal(ErrX5ChainNotFound, err)
+}
+
+func TestCBORArrayMessageType(t *testing.T) {
+	assert := assert.New(t)
+
+	var tests = []struct {
+		hex      string
+		expected coseMessageType
+	}{
+		{"83" + "40" + "A0" + "40", coseMessageSignature},
+		{"84" + "40" + "A0" + "F6" + "40", coseMessageSign1},
+		{"84" + "40" + "A0" + "F6" + "80", coseMessageSign},
+		{"84" + "43A10126" + "A2" + "04" + "426964" + "1863" + "82" + "A0" + "6161" + "45" + "7061796C64" + "81" + "83" + "40" + "A0" + "40", coseMessageSign},
+		{"84" + "40" + "A0" + "F6" + "01", coseMessageUnknown},
+		{"84" + "40" + "A0" + "F6", coseMessageUnknown},               // truncated
+		{"84" + "40" + "BF" + "FF" + "F6" + "80", coseMessageUnknown}, // indefinite length
+		{"84" + "5A" + "FFFFFFFF" + "A0" + "F6" + "80", coseMessageUnknown},
+		{"82" + "40" + "A0", coseMessageUnknown},
+		{"A0", coseMessageUnknown},
+		{"", coseMessageUnknown},
+	}
+	for _, test := range tests {
+		assert.Equal(test.expected, cborArrayMessageType(HexToBytesOrDie(test.hex)), test.hex)
+	}
+	assert.Equal("COSE_Mac0", coseMessageMac0.String())
+	assert.Equal("unknown COSE message", coseMessageUnknown.String())
+}
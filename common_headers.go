@@ -1,8 +1,11 @@
 package cose
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/pkg/errors"
+	"sort"
+	"unicode/utf8"
 )
 
 // Headers represents "two buckets of information that are not
@@ -26,23 +29,48 @@ import (
 //
 // empty_or_serialized_map = bstr .cbor header_map / bstr .size 0
 //
+// Protected and Unprotected may use common header and alg names
+// (e.g. "alg": "ES256") or their int labels and values. Compress
+// converts names to ints and signing calls it, so build messages with
+// whichever is clearer.
+//
+// RawProtected is optional. When set, it is the pre-serialized
+// protected header bstr and EncodeProtected returns it verbatim
+// instead of encoding Protected, so the signed bytes do not change
+// when a message is re-signed or re-marshaled. Protected must hold
+// the same headers since the alg is read from it and signing returns
+// an error wrapping ErrRawProtectedMismatch when it does not.
+//
+// SignMessage.UnmarshalCBOR sets RawProtected only when the decoded
+// bytes are not the canonical encoding of Protected e.g. for unsorted
+// labels. Bytes with duplicate labels are not kept. Set it to nil
+// after modifying Protected.
 type Headers struct {
-	Protected   map[interface{}]interface{}
-	Unprotected map[interface{}]interface{}
+	Protected    map[interface{}]interface{}
+	Unprotected  map[interface{}]interface{}
+	RawProtected []byte
 }
 
 // EncodeUnprotected returns compressed unprotected headers
+//
+// Like the protected headers, the returned map is encoded with
+// canonically sorted keys so messages marshal to the same bytes
 func (h *Headers) EncodeUnprotected() (encoded map[interface{}]interface{}) {
 	return CompressHeaders(h.Unprotected)
 }
 
 // EncodeProtected compresses and Marshals protected headers to bytes
+// or returns RawProtected when it is set
 // to encode as a CBOR bstr
 func (h *Headers) EncodeProtected() (bstr []byte) {
 	if h == nil {
 		panic("Cannot encode nil Headers")
 	}
 
+	if h.RawProtected != nil {
+		return h.RawProtected
+	}
+
 	if h.Protected == nil || len(h.Protected) < 1 {
 		return []byte("")
 	}
@@ -67,9 +95,15 @@ func (h *Headers) DecodeProtected(o interface{}) (err error) {
 	if len(b) <= 0 {
 		return nil
 	}
+	err = checkHeaderMapLimit(b)
+	if err != nil {
+		return err
+	}
 
 	protected, err := Unmarshal(b)
-	if err != nil {
+	if err == ErrNonCanonicalEncoding {
+		return err
+	} else if err != nil {
 		return errors.Errorf("error CBOR decoding protected header bytes; got %T", protected)
 	}
 	protectedMap, ok := protected.(map[interface{}]interface{})
@@ -81,11 +115,22 @@ func (h *Headers) DecodeProtected(o interface{}) (err error) {
 }
 
 // DecodeUnprotected Unmarshals and sets Headers.unprotected from an interface{}
+//
+// A nil (CBOR null) value decodes to empty unprotected headers.
 func (h *Headers) DecodeUnprotected(o interface{}) (err error) {
+	if o == nil {
+		o = map[interface{}]interface{}{}
+	}
 	msgHeadersUnprotected, ok := o.(map[interface{}]interface{})
 	if !ok {
 		return errors.Errorf("error decoding unprotected header as map[interface {}]interface {}; got %T", o)
 	}
+	if len(msgHeadersUnprotected) > MaxHeaderMapPairs {
+		return ErrTooManyHeaders
+	}
+	if msgHeadersUnprotected == nil {
+		msgHeadersUnprotected = map[interface{}]interface{}{}
+	}
 	h.Unprotected = msgHeadersUnprotected
 	return nil
 }
@@ -111,26 +156,271 @@ func (h *Headers) Decode(o []interface{}) (err error) {
 	return nil
 }
 
+// SignEquivalent returns true when h and other have the same encoded
+// protected headers, so they produce the same Sig_structure, e.g. to
+// debug signatures that do not verify across implementations
+//
+// Unlike comparing the Protected maps it accounts for label and alg
+// compression, canonical ordering, and RawProtected. Nil Headers and
+// headers that cannot be encoded are not equivalent to anything.
+func (h *Headers) SignEquivalent(other *Headers) bool {
+	a, err := h.safeEncodeProtected()
+	if err != nil {
+		return false
+	}
+	b, err := other.safeEncodeProtected()
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(a, b)
+}
+
+// safeEncodeProtected returns EncodeProtected or an error instead of
+// panicking
+func (h *Headers) safeEncodeProtected() (bstr []byte, err error) {
+	if h == nil {
+		return nil, errors.New("Cannot encode nil Headers")
+	}
+	if h.RawProtected != nil {
+		return h.RawProtected, nil
+	}
+	if len(h.Protected) < 1 {
+		return []byte(""), nil
+	}
+	return Marshal(CompressHeaders(h.Protected))
+}
+
+// Range calls f for each header in the Protected then Unprotected
+// headers with bucket "protected" or "unprotected", the header label,
+// the common header name for the label (or "" for an unknown label),
+// and the header value. Range stops when f returns false.
+//
+// Within a bucket int labels are visited in increasing order before
+// all other labels.
+func (h *Headers) Range(f func(bucket string, label interface{}, name string, value interface{}) bool) {
+	if h == nil {
+		return
+	}
+	buckets := []struct {
+		name    string
+		headers map[interface{}]interface{}
+	}{
+		{"protected", h.Protected},
+		{"unprotected", h.Unprotected},
+	}
+	for _, bucket := range buckets {
+		for _, label := range sortedLabels(bucket.headers) {
+			if !f(bucket.name, label, commonHeaderName(label), bucket.headers[label]) {
+				return
+			}
+		}
+	}
+}
+
+// Lookup returns the value for the header label and whether it was
+// found checking the Protected then Unprotected headers
+//
+// label is an int or tstr label and common headers are found by
+// either their name or int tag e.g. Lookup("kid") and Lookup(4) both
+// return the kid header.
+func (h *Headers) Lookup(label interface{}) (value interface{}, found bool) {
+	if h == nil {
+		return nil, false
+	}
+	for _, bucket := range []map[interface{}]interface{}{h.Protected, h.Unprotected} {
+		if value, found = findHeader(bucket, label); found {
+			return value, found
+		}
+	}
+	return nil, false
+}
+
+// Delete removes the header label from the Protected and Unprotected
+// headers under its common name and int tag like Lookup
+//
+// RawProtected is cleared when a protected header is removed, so the
+// protected headers are encoded without it.
+func (h *Headers) Delete(label interface{}) {
+	if h == nil {
+		return
+	}
+	normalized := normalizeLabel(label)
+	switch normalized.(type) {
+	case int, string:
+	default:
+		return
+	}
+	for i, bucket := range []map[interface{}]interface{}{h.Protected, h.Unprotected} {
+		for k := range bucket {
+			if normalizeLabel(k) == normalized {
+				delete(bucket, k)
+				if i == 0 {
+					h.RawProtected = nil
+				}
+			}
+		}
+	}
+}
+
+// commonHeaderName returns the common header name for a label or ""
+func commonHeaderName(label interface{}) (name string) {
+	switch l := label.(type) {
+	case string:
+		if _, err := GetCommonHeaderTag(l); err == nil {
+			return l
+		}
+	default:
+		if i, ok := intFromInteger(label); ok {
+			name, _ = GetCommonHeaderLabel(i)
+		}
+	}
+	return name
+}
+
+// sortedLabels returns the labels of headers with int labels in
+// increasing order followed by the other labels sorted by their
+// string representation
+func sortedLabels(headers map[interface{}]interface{}) (labels []interface{}) {
+	for label := range headers {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		a, aIsInt := labelToInt64(labels[i])
+		b, bIsInt := labelToInt64(labels[j])
+		if aIsInt && bIsInt {
+			return a < b
+		} else if aIsInt != bIsInt {
+			return aIsInt
+		}
+		return fmt.Sprint(labels[i]) < fmt.Sprint(labels[j])
+	})
+	return labels
+}
+
+func labelToInt64(label interface{}) (i int64, ok bool) {
+	if l, ok := intFromInteger(label); ok {
+		return int64(l), true
+	}
+	return 0, false
+}
+
+// SetKeyIDString sets the kid header to the UTF-8 bytes of kid
+//
+// The kid is updated in place when it is already in the Protected or
+// Unprotected headers and otherwise added to the Unprotected headers.
+func (h *Headers) SetKeyIDString(kid string) {
+	tag := CommonHeaderIDKeyID
+	if h.Unprotected == nil {
+		h.Unprotected = map[interface{}]interface{}{}
+	}
+
+	bucket := h.Unprotected
+	for _, label := range []interface{}{tag, "kid"} {
+		if _, ok := h.Protected[label]; ok {
+			bucket = h.Protected
+		}
+	}
+	delete(bucket, "kid")
+	bucket[tag] = []byte(kid)
+}
+
+// KeyIDString returns the kid header bytes as a string returning an
+// error when the kid is missing, not a bstr, or not valid UTF-8
+func (h *Headers) KeyIDString() (kid string, err error) {
+	o, ok := getCommonHeader(h, "kid")
+	if !ok {
+		return "", ErrKeyIDNotFound
+	}
+	b, ok := o.([]byte)
+	if !ok {
+		return "", errors.Errorf("error casting kid to bstr; got %T", o)
+	}
+	if !utf8.Valid(b) {
+		return "", errors.New("kid is not valid UTF-8")
+	}
+	return string(b), nil
+}
+
+// maxContentFormat is the largest CoAP Content-Format
+//
+// https://tools.ietf.org/html/rfc7252#section-12.3
+const maxContentFormat = 65535
+
+// ContentFormat returns the content type header as a CoAP
+// Content-Format e.g. 60 for application/cbor returning
+// ErrContentTypeNotFound when the content type is missing or an error
+// when it is a tstr media type or outside the CoAP Content-Format
+// range 0 to 65535
+func (h *Headers) ContentFormat() (format int, err error) {
+	o, ok := getCommonHeader(h, "content type")
+	if !ok {
+		return 0, ErrContentTypeNotFound
+	}
+	format, ok = intFromInteger(o)
+	if !ok {
+		if _, isString := o.(string); isString {
+			return 0, errors.New("content type is a media type not a CoAP Content-Format")
+		}
+		return 0, errors.Errorf("error casting content type to int; got %T", o)
+	}
+	if format < 0 || format > maxContentFormat {
+		return 0, errors.Errorf("content type %d is not a CoAP Content-Format", format)
+	}
+	return format, nil
+}
+
+// Common COSE header labels to use as Headers.Protected and
+// Headers.Unprotected map keys instead of ints or names e.g.
+//
+//	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+//
+// https://tools.ietf.org/html/rfc8152#section-3.1
+const (
+	CommonHeaderIDAlg                = 1
+	CommonHeaderIDCrit               = 2
+	CommonHeaderIDContentType        = 3
+	CommonHeaderIDKeyID              = 4
+	CommonHeaderIDIV                 = 5
+	CommonHeaderIDPartialIV          = 6
+	CommonHeaderIDCounterSignature   = 7
+	CommonHeaderIDCounterSignatureV2 = 11
+	CommonHeaderIDX5Chain            = 33
+	CommonHeaderIDX5T                = 34
+	CommonHeaderIDX5U                = 35
+)
+
 // GetCommonHeaderTag returns the CBOR tag for the map label
 //
 // using Common COSE Headers Parameters Table 2
 // https://tools.ietf.org/html/rfc8152#section-3.1
+// the X.509 certificate headers from
+// https://tools.ietf.org/html/rfc9360#section-2
+// and the countersignature header from
+// https://tools.ietf.org/html/rfc9338#section-3.1
 func GetCommonHeaderTag(label string) (tag int, err error) {
 	switch label {
 	case "alg":
-		return 1, nil
+		return CommonHeaderIDAlg, nil
 	case "crit":
-		return 2, nil
+		return CommonHeaderIDCrit, nil
 	case "content type":
-		return 3, nil
+		return CommonHeaderIDContentType, nil
 	case "kid":
-		return 4, nil
+		return CommonHeaderIDKeyID, nil
 	case "IV":
-		return 5, nil
+		return CommonHeaderIDIV, nil
 	case "Partial IV":
-		return 6, nil
+		return CommonHeaderIDPartialIV, nil
 	case "counter signature":
-		return 7, nil
+		return CommonHeaderIDCounterSignature, nil
+	case "counter signature v2":
+		return CommonHeaderIDCounterSignatureV2, nil
+	case "x5chain":
+		return CommonHeaderIDX5Chain, nil
+	case "x5t":
+		return CommonHeaderIDX5T, nil
+	case "x5u":
+		return CommonHeaderIDX5U, nil
 	default:
 		return 0, ErrMissingCOSETagForLabel
 	}
@@ -150,20 +440,28 @@ func GetCommonHeaderTagOrPanic(label string) (tag int) {
 // the inverse of GetCommonHeaderTag.
 func GetCommonHeaderLabel(tag int) (label string, err error) {
 	switch tag {
-	case 1:
+	case CommonHeaderIDAlg:
 		return "alg", nil
-	case 2:
+	case CommonHeaderIDCrit:
 		return "crit", nil
-	case 3:
+	case CommonHeaderIDContentType:
 		return "content type", nil
-	case 4:
+	case CommonHeaderIDKeyID:
 		return "kid", nil
-	case 5:
+	case CommonHeaderIDIV:
 		return "IV", nil
-	case 6:
+	case CommonHeaderIDPartialIV:
 		return "Partial IV", nil
-	case 7:
+	case CommonHeaderIDCounterSignature:
 		return "counter signature", nil
+	case CommonHeaderIDCounterSignatureV2:
+		return "counter signature v2", nil
+	case CommonHeaderIDX5Chain:
+		return "x5chain", nil
+	case CommonHeaderIDX5T:
+		return "x5t", nil
+	case CommonHeaderIDX5U:
+		return "x5u", nil
 	default:
 		return "", ErrMissingCOSETagForTag
 	}
@@ -171,10 +469,11 @@ func GetCommonHeaderLabel(tag int) (label string, err error) {
 
 // getAlgByName returns a Algorithm for an IANA name
 func getAlgByName(name string) (alg *Algorithm, err error) {
-	for _, alg := range algorithms {
-		if alg.Name == name {
-			return &alg, nil
-		}
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+	if i, ok := algorithmIndexByName[name]; ok {
+		alg := algorithms[i]
+		return &alg, nil
 	}
 	return nil, errors.Errorf("Algorithm named %s not found", name)
 }
@@ -190,10 +489,11 @@ func getAlgByNameOrPanic(name string) (alg *Algorithm) {
 
 // getAlgByValue returns a Algorithm for an IANA value
 func getAlgByValue(value int) (alg *Algorithm, err error) {
-	for _, alg := range algorithms {
-		if alg.Value == value {
-			return &alg, nil
-		}
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+	if i, ok := algorithmIndexByValue[value]; ok {
+		alg := algorithms[i]
+		return &alg, nil
 	}
 	return nil, errors.Errorf("Algorithm with value %v not found", value)
 }
@@ -213,8 +513,11 @@ func compressHeader(k, v interface{}) (compressedK, compressedV interface{}) {
 		if err == nil {
 			compressedK = tag
 		}
-	case int64:
-		compressedK = int(key)
+	default:
+		if i, ok := intFromInteger(k); ok {
+			keyIsAlg = i == 1
+			compressedK = i
+		}
 	}
 
 	switch val := v.(type) {
@@ -225,12 +528,61 @@ func compressHeader(k, v interface{}) (compressedK, compressedV interface{}) {
 				compressedV = alg.Value
 			}
 		}
-	case int64:
-		compressedV = int(val)
+	case *Algorithm:
+		if keyIsAlg && val != nil {
+			compressedV = val.Value
+		}
+	case Algorithm:
+		if keyIsAlg {
+			compressedV = val.Value
+		}
+	default:
+		if i, ok := intFromInteger(v); ok {
+			compressedV = i
+		}
 	}
 	return
 }
 
+// maxInt is the largest int since math.MaxInt needs Go 1.17
+const maxInt = int(^uint(0) >> 1)
+
+// intFromInteger returns a value of any Go integer type as an int
+// when it fits in an int
+func intFromInteger(o interface{}) (i int, ok bool) {
+	switch v := o.(type) {
+	case int:
+		return v, true
+	case int8:
+		return int(v), true
+	case int16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case int64:
+		if int64(int(v)) == v {
+			return int(v), true
+		}
+	case uint:
+		if v <= uint(maxInt) {
+			return int(v), true
+		}
+	case uint8:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case uint32:
+		if uint64(v) <= uint64(maxInt) {
+			return int(v), true
+		}
+	case uint64:
+		if v <= uint64(maxInt) {
+			return int(v), true
+		}
+	}
+	return 0, false
+}
+
 func decompressHeader(k, v interface{}) (decompressedK, decompressedV interface{}) {
 	var keyIsAlg = false
 
@@ -264,7 +616,10 @@ func decompressHeader(k, v interface{}) (decompressedK, decompressedV interface{
 // tags with their IANA int values.
 //
 // panics when a compressed header tag already exists (e.g. alg and 1)
-// casts int64 keys to int to make looking up common header IDs easier
+// casts integer keys and values of any type (e.g. the int64 from
+// decoding or a uint64) to int to make looking up common header IDs
+// easier. Decoded Headers are compressed, so their integer labels and
+// values are always int.
 func CompressHeaders(headers map[interface{}]interface{}) (compressed map[interface{}]interface{}) {
 	compressed = map[interface{}]interface{}{}
 	for k, v := range headers {
@@ -291,6 +646,56 @@ func DecompressHeaders(headers map[interface{}]interface{}) (decompressed map[in
 	return decompressed
 }
 
+// Compress replaces common header names and alg names in the
+// Protected and Unprotected headers with their int labels and values
+// e.g. "alg": "ES256" with 1: -7
+//
+// Headers may be built with names, ints, or both. Compress is where
+// they are converted: SignMessage.Sign calls it on the message and
+// signature headers before computing their Sig_structure, so the
+// signed and marshaled headers are the same. It returns an error
+// instead of panicking when a name and its int label are both present
+// in a bucket or a label is in both buckets.
+func (h *Headers) Compress() (err error) {
+	if h == nil {
+		return errors.New("Cannot compress nil Headers")
+	}
+	protected, err := compressHeadersChecked(h.Protected)
+	if err != nil {
+		return errors.Wrap(err, "protected headers")
+	}
+	unprotected, err := compressHeadersChecked(h.Unprotected)
+	if err != nil {
+		return errors.Wrap(err, "unprotected headers")
+	}
+	for k := range protected {
+		if _, ok := unprotected[k]; ok {
+			return errors.Errorf("Duplicate header %+v found", k)
+		}
+	}
+	if h.Protected != nil {
+		h.Protected = protected
+	}
+	if h.Unprotected != nil {
+		h.Unprotected = unprotected
+	}
+	return nil
+}
+
+// compressHeadersChecked returns CompressHeaders(headers) or an error
+// for a label that is present by name and int
+func compressHeadersChecked(headers map[interface{}]interface{}) (compressed map[interface{}]interface{}, err error) {
+	compressed = map[interface{}]interface{}{}
+	for k, v := range headers {
+		compressedK, compressedV := compressHeader(k, v)
+		if _, ok := compressed[compressedK]; ok {
+			return nil, errors.Errorf("Duplicate compressed and uncompressed common header %v found", compressedK)
+		}
+		compressed[compressedK] = compressedV
+	}
+	return compressed, nil
+}
+
 // FindDuplicateHeader compresses the headers and returns the first
 // duplicate header or nil for none found
 func FindDuplicateHeader(headers *Headers) interface{} {
@@ -308,30 +713,84 @@ func FindDuplicateHeader(headers *Headers) interface{} {
 	return nil
 }
 
+// ProtectedAlgorithm returns the Algorithm for the alg header in the
+// protected headers, which signatures cover, ignoring any alg in the
+// unprotected headers
+//
+// Verify and Sign use the same lookup. It returns ErrAlgNotFound when
+// there is no protected alg.
+func (h *Headers) ProtectedAlgorithm() (alg *Algorithm, err error) {
+	return getAlg(h)
+}
+
 // getAlg returns the alg by label or int
 // alg should only be in Protected headers so it does not check Unprotected headers
+//
+// the alg value can be an IANA name, an IANA int value, or an
+// Algorithm (e.g. ES256) set when building headers programmatically
 func getAlg(h *Headers) (alg *Algorithm, err error) {
+	alg, err = decodeAlg(h)
+	if logger != nil {
+		if err != nil {
+			logger.Debugf("cannot resolve alg header: %s", err)
+		} else {
+			logger.Debugf("resolved alg header to %s", alg.Name)
+		}
+	}
+	return alg, err
+}
+
+// decodeAlg returns the alg for getAlg without logging
+func decodeAlg(h *Headers) (alg *Algorithm, err error) {
 	if h == nil {
 		err = errors.New("Cannot getAlg on nil Headers")
 		return
 	}
 
-	if tmp, ok := h.Protected["alg"]; ok {
-		if algName, ok := tmp.(string); ok {
-			alg, err = getAlgByName(algName)
-			if err != nil {
-				return nil, err
-			}
-			return alg, nil
+	tmp, ok := findHeader(h.Protected, "alg")
+	if !ok {
+		return nil, ErrAlgNotFound
+	}
+
+	if i, ok := intFromInteger(tmp); ok {
+		return getAlgByValue(i)
+	}
+	switch algValue := tmp.(type) {
+	case string:
+		return getAlgByName(algValue)
+	case *Algorithm:
+		if algValue == nil {
+			return nil, ErrAlgNotFound
 		}
-	} else if tmp, ok := h.Protected[int(1)]; ok {
-		if algValue, ok := tmp.(int); ok {
-			alg, err = getAlgByValue(algValue)
-			if err != nil {
-				return nil, err
+		return getAlgByValue(algValue.Value)
+	case Algorithm:
+		return getAlgByValue(algValue.Value)
+	case float32, float64:
+		// malformed alg from an encoder that emits floats
+		return nil, ErrInvalidAlgEncoding
+	}
+	return nil, ErrAlgNotFound
+}
+
+// getCommonHeader returns the value for a common header label or its
+// compressed tag checking the Protected then Unprotected headers
+func getCommonHeader(h *Headers, label string) (value interface{}, ok bool) {
+	if h == nil {
+		return nil, false
+	}
+	tag := GetCommonHeaderTagOrPanic(label)
+	for i, bucket := range []map[interface{}]interface{}{h.Protected, h.Unprotected} {
+		for _, key := range []interface{}{tag, label} {
+			if value, ok = bucket[key]; ok {
+				if logger != nil {
+					logger.Debugf("found %s header in the %s headers", label, []string{"protected", "unprotected"}[i])
+				}
+				return value, ok
 			}
-			return alg, nil
 		}
 	}
-	return nil, ErrAlgNotFound
+	if logger != nil {
+		logger.Debugf("%s header not found", label)
+	}
+	return nil, false
 }
@@ -1,8 +1,10 @@
 package cose
 
 import (
+	"crypto/rand"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"testing"
 )
 
@@ -146,6 +148,33 @@ func TestGetAlgPanics(t *testing.T) {
 	assert.Panics(func () { getAlgByNameOrPanic(algName) })
 }
 
+func TestGetAlgByNameAndValueFindsAllAlgorithms(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, expected := range algorithms {
+		alg, err := getAlgByName(expected.Name)
+		assert.Nil(err, expected.Name)
+		assert.Equal(expected, *alg)
+
+		alg, err = getAlgByValue(expected.Value)
+		assert.Nil(err, expected.Name)
+		assert.Equal(expected, *alg)
+	}
+
+	// returns a copy of the table entry
+	alg, err := getAlgByValue(ES256.Value)
+	assert.Nil(err)
+	alg.Name = "modified"
+	alg, err = getAlgByValue(ES256.Value)
+	assert.Nil(err)
+	assert.Equal("ES256", alg.Name)
+
+	_, err = getAlgByValue(-1000000)
+	assert.Equal("Algorithm with value -1000000 not found", err.Error())
+	_, err = getAlgByName("FOOOO")
+	assert.Equal("Algorithm named FOOOO not found", err.Error())
+}
+
 func TestGetCommonHeaderTagOrPanicPanics(t *testing.T) {
 	assert := assert.New(t)
 
@@ -230,3 +259,435 @@ func TestHeaderDecodeErrors(t *testing.T) {
 	assert.NotNil(err)
 	assert.Equal(err.Error(), "error decoding unprotected header as map[interface {}]interface {}; got int")
 }
+
+func TestGetAlgWithTypedValues(t *testing.T) {
+	assert := assert.New(t)
+
+	h := &Headers{
+		Protected: map[interface{}]interface{}{
+			CommonHeaderIDAlg: ES256,
+		},
+	}
+	alg, err := getAlg(h)
+	assert.Nil(err)
+	assert.Equal(ES256.Value, alg.Value)
+
+	h.Protected[CommonHeaderIDAlg] = *PS256
+	alg, err = getAlg(h)
+	assert.Nil(err)
+	assert.Equal(PS256.Value, alg.Value)
+
+	h.Protected[CommonHeaderIDAlg] = int64(-35)
+	alg, err = getAlg(h)
+	assert.Nil(err)
+	assert.Equal(ES384.Value, alg.Value)
+
+	h.Protected[CommonHeaderIDAlg] = "ES512"
+	alg, err = getAlg(h)
+	assert.Nil(err)
+	assert.Equal(ES512.Value, alg.Value)
+
+	h.Protected = map[interface{}]interface{}{"alg": -7}
+	alg, err = getAlg(h)
+	assert.Nil(err)
+	assert.Equal(ES256.Value, alg.Value)
+
+	h.Protected = map[interface{}]interface{}{"alg": (*Algorithm)(nil)}
+	_, err = getAlg(h)
+	assert.Equal(ErrAlgNotFound, err)
+
+	h.Protected = map[interface{}]interface{}{"alg": true}
+	_, err = getAlg(h)
+	assert.Equal(ErrAlgNotFound, err)
+}
+
+func TestGetAlgWithFloatValue(t *testing.T) {
+	assert := assert.New(t)
+
+	// {1: -7.0} with a half precision float alg
+	h := &Headers{}
+	assert.Nil(h.DecodeProtected([]byte("\xA1\x01\xF9\xC7\x00")))
+	_, err := getAlg(h)
+	assert.Equal(ErrInvalidAlgEncoding, err)
+
+	h.Protected = map[interface{}]interface{}{"alg": float32(-7)}
+	_, err = h.ProtectedAlgorithm()
+	assert.Equal(ErrInvalidAlgEncoding, err)
+}
+
+func TestHeadersProtectedAlgorithm(t *testing.T) {
+	assert := assert.New(t)
+
+	h := &Headers{
+		Protected:   map[interface{}]interface{}{CommonHeaderIDAlg: ES256.Value},
+		Unprotected: map[interface{}]interface{}{CommonHeaderIDAlg: PS256.Value},
+	}
+	alg, err := h.ProtectedAlgorithm()
+	assert.Nil(err)
+	assert.Equal(ES256.Name, alg.Name)
+
+	// an unprotected alg is never used
+	delete(h.Protected, CommonHeaderIDAlg)
+	_, err = h.ProtectedAlgorithm()
+	assert.Equal(ErrAlgNotFound, err)
+
+	var nilHeaders *Headers
+	_, err = nilHeaders.ProtectedAlgorithm()
+	assert.Equal("Cannot getAlg on nil Headers", err.Error())
+}
+
+func TestHeaderCompressionOfTypedAlgValues(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(
+		map[interface{}]interface{}{1: -7},
+		CompressHeaders(map[interface{}]interface{}{CommonHeaderIDAlg: ES256}))
+	assert.Equal(
+		map[interface{}]interface{}{1: -37},
+		CompressHeaders(map[interface{}]interface{}{"alg": *PS256}))
+	assert.Equal(
+		map[interface{}]interface{}{1: -7},
+		CompressHeaders(map[interface{}]interface{}{1: "ES256"}))
+
+	// only alg values are compressed
+	assert.Equal(
+		map[interface{}]interface{}{4: ES256},
+		CompressHeaders(map[interface{}]interface{}{CommonHeaderIDKeyID: ES256}))
+
+	h := &Headers{Protected: map[interface{}]interface{}{CommonHeaderIDAlg: ES256}}
+	assert.Equal([]byte("\xA1\x01\x26"), h.EncodeProtected())
+}
+
+func TestHeaderCompressionNormalizesIntegerTypes(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(
+		map[interface{}]interface{}{1: -7, 4: []byte("kid"), -70000: 5},
+		CompressHeaders(map[interface{}]interface{}{
+			uint64(1):     int32(-7),
+			int8(4):       []byte("kid"),
+			int32(-70000): uint8(5),
+		}))
+
+	h := &Headers{Protected: map[interface{}]interface{}{uint64(1): int16(-35)}}
+	alg, err := getAlg(h)
+	assert.Nil(err)
+	assert.Equal(ES384.Value, alg.Value)
+
+	h.Protected = map[interface{}]interface{}{CommonHeaderIDAlg: uint64(math.MaxUint64)}
+	_, err = getAlg(h)
+	assert.Equal(ErrAlgNotFound, err)
+
+	// decoded labels and values are always int
+	h = &Headers{}
+	assert.Nil(h.Decode([]interface{}{[]byte("\xA1\x01\x26"), map[interface{}]interface{}{int64(-70000): uint64(5)}}))
+	assert.Equal(map[interface{}]interface{}{1: -7}, h.Protected)
+	assert.Equal(map[interface{}]interface{}{-70000: 5}, h.Unprotected)
+}
+
+func TestHeadersRange(t *testing.T) {
+	assert := assert.New(t)
+
+	type visit struct {
+		bucket string
+		label  interface{}
+		name   string
+		value  interface{}
+	}
+
+	h := &Headers{
+		Protected: map[interface{}]interface{}{
+			"private": 2,
+			1:         -7,
+			"crit":    []interface{}{"private"},
+		},
+		Unprotected: map[interface{}]interface{}{
+			int64(4): []byte("kid"),
+			-1:       "negative",
+			33:       []interface{}{[]byte("cert")},
+		},
+	}
+
+	var visits []visit
+	h.Range(func(bucket string, label interface{}, name string, value interface{}) bool {
+		visits = append(visits, visit{bucket, label, name, value})
+		return true
+	})
+	assert.Equal([]visit{
+		{"protected", 1, "alg", -7},
+		{"protected", "crit", "crit", []interface{}{"private"}},
+		{"protected", "private", "", 2},
+		{"unprotected", -1, "", "negative"},
+		{"unprotected", int64(4), "kid", []byte("kid")},
+		{"unprotected", 33, "x5chain", []interface{}{[]byte("cert")}},
+	}, visits)
+
+	// stops early
+	visits = nil
+	h.Range(func(bucket string, label interface{}, name string, value interface{}) bool {
+		visits = append(visits, visit{bucket, label, name, value})
+		return len(visits) < 2
+	})
+	assert.Equal(2, len(visits))
+
+	// nil headers
+	var nilHeaders *Headers
+	nilHeaders.Range(func(bucket string, label interface{}, name string, value interface{}) bool {
+		assert.Fail("visited nil Headers")
+		return true
+	})
+}
+
+func TestHeadersDelete(t *testing.T) {
+	assert := assert.New(t)
+
+	h := &Headers{
+		Protected: map[interface{}]interface{}{
+			CommonHeaderIDAlg: ES256.Value,
+			"kid":             []byte("protected"),
+		},
+		Unprotected: map[interface{}]interface{}{
+			int64(CommonHeaderIDKeyID): []byte("unprotected"),
+			"custom":                   "value",
+		},
+	}
+	h.RawProtected = h.EncodeProtected()
+
+	h.Delete("custom")
+	assert.Equal(map[interface{}]interface{}{int64(CommonHeaderIDKeyID): []byte("unprotected")}, h.Unprotected)
+	assert.NotNil(h.RawProtected)
+
+	// removes a duplicated header from both buckets
+	h.Delete(CommonHeaderIDKeyID)
+	assert.Equal(map[interface{}]interface{}{CommonHeaderIDAlg: ES256.Value}, h.Protected)
+	assert.Equal(map[interface{}]interface{}{}, h.Unprotected)
+	assert.Nil(h.RawProtected)
+	assert.Equal([]byte("\xA1\x01\x26"), h.EncodeProtected())
+
+	h.Delete("alg")
+	assert.Equal(map[interface{}]interface{}{}, h.Protected)
+	_, found := h.Lookup("alg")
+	assert.False(found)
+
+	// missing and invalid labels are ignored
+	h.Delete("iv")
+	h.Delete([]byte("alg"))
+	var nilHeaders *Headers
+	nilHeaders.Delete("alg")
+}
+
+func TestHeadersContentFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	msg := NewSignMessage()
+	msg.Payload = []byte("\xA0")
+	msg.Headers.Protected["content type"] = 60 // application/cbor
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+
+	format, err := msg.Headers.ContentFormat()
+	assert.Nil(err)
+	assert.Equal(60, format)
+
+	// round trips as an int
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+	decoded, err := Unmarshal(msgBytes)
+	assert.Nil(err)
+	decodedMsg := decoded.(SignMessage)
+	assert.Equal(map[interface{}]interface{}{CommonHeaderIDContentType: 60}, decodedMsg.Headers.Protected)
+	format, err = decodedMsg.Headers.ContentFormat()
+	assert.Nil(err)
+	assert.Equal(60, format)
+	assert.Nil(decodedMsg.Verify(nil, []Verifier{*signer.Verifier()}))
+
+	h := &Headers{Unprotected: map[interface{}]interface{}{CommonHeaderIDContentType: uint64(maxContentFormat)}}
+	format, err = h.ContentFormat()
+	assert.Nil(err)
+	assert.Equal(65535, format)
+
+	h.Unprotected[CommonHeaderIDContentType] = maxContentFormat + 1
+	_, err = h.ContentFormat()
+	assert.Equal("content type 65536 is not a CoAP Content-Format", err.Error())
+	h.Unprotected[CommonHeaderIDContentType] = int64(-1)
+	_, err = h.ContentFormat()
+	assert.Equal("content type -1 is not a CoAP Content-Format", err.Error())
+	h.Unprotected[CommonHeaderIDContentType] = "application/cbor"
+	_, err = h.ContentFormat()
+	assert.Equal("content type is a media type not a CoAP Content-Format", err.Error())
+	h.Unprotected[CommonHeaderIDContentType] = []byte("60")
+	_, err = h.ContentFormat()
+	assert.Equal("error casting content type to int; got []uint8", err.Error())
+
+	_, err = (&Headers{}).ContentFormat()
+	assert.Equal(ErrContentTypeNotFound, err)
+}
+
+func TestHeadersSignEquivalent(t *testing.T) {
+	assert := assert.New(t)
+
+	h := &Headers{Protected: map[interface{}]interface{}{"alg": "ES256", "kid": []byte("1")}}
+	other := &Headers{
+		Protected:   map[interface{}]interface{}{CommonHeaderIDKeyID: []byte("1"), int64(CommonHeaderIDAlg): ES256.Value},
+		Unprotected: map[interface{}]interface{}{"iv": []byte("not signed")},
+	}
+	assert.True(h.SignEquivalent(other))
+	assert.True(other.SignEquivalent(h))
+
+	other.Protected[CommonHeaderIDKeyID] = []byte("2")
+	assert.False(h.SignEquivalent(other))
+
+	// RawProtected is compared as is
+	other.RawProtected = h.EncodeProtected()
+	assert.True(h.SignEquivalent(other))
+	other.RawProtected = []byte("\xA2\x04\x41\x31\x01\x26") // non-canonical order
+	assert.False(h.SignEquivalent(other))
+
+	// empty protected headers
+	assert.True((&Headers{}).SignEquivalent(&Headers{Protected: map[interface{}]interface{}{}}))
+	assert.False((&Headers{}).SignEquivalent(h))
+
+	var nilHeaders *Headers
+	assert.False(nilHeaders.SignEquivalent(nilHeaders))
+	assert.False(h.SignEquivalent(nil))
+	assert.False(h.SignEquivalent(&Headers{Protected: map[interface{}]interface{}{"kid": make(chan int)}}))
+}
+
+func TestHeadersKeyIDString(t *testing.T) {
+	assert := assert.New(t)
+
+	h := &Headers{}
+	_, err := h.KeyIDString()
+	assert.Equal(ErrKeyIDNotFound, err)
+
+	h.SetKeyIDString("key-1")
+	assert.Equal(map[interface{}]interface{}{CommonHeaderIDKeyID: []byte("key-1")}, h.Unprotected)
+	kid, err := h.KeyIDString()
+	assert.Nil(err)
+	assert.Equal("key-1", kid)
+
+	// updates an uncompressed kid
+	h.Unprotected = map[interface{}]interface{}{"kid": []byte("old")}
+	h.SetKeyIDString("key-2")
+	assert.Equal(map[interface{}]interface{}{CommonHeaderIDKeyID: []byte("key-2")}, h.Unprotected)
+
+	// updates a protected kid in place
+	h.Protected = map[interface{}]interface{}{"kid": []byte("old")}
+	h.Unprotected = map[interface{}]interface{}{}
+	h.SetKeyIDString("ключ")
+	assert.Equal(map[interface{}]interface{}{CommonHeaderIDKeyID: []byte("ключ")}, h.Protected)
+	assert.Equal(map[interface{}]interface{}{}, h.Unprotected)
+	kid, err = h.KeyIDString()
+	assert.Nil(err)
+	assert.Equal("ключ", kid)
+
+	h.Protected[CommonHeaderIDKeyID] = []byte("\xff\xfe")
+	_, err = h.KeyIDString()
+	assert.Equal("kid is not valid UTF-8", err.Error())
+
+	h.Protected[CommonHeaderIDKeyID] = 1
+	_, err = h.KeyIDString()
+	assert.Equal("error casting kid to bstr; got int", err.Error())
+}
+
+func TestHeadersLookup(t *testing.T) {
+	assert := assert.New(t)
+
+	h := &Headers{
+		Protected: map[interface{}]interface{}{
+			CommonHeaderIDAlg: ES256.Value,
+			"kid":             []byte("protected"),
+		},
+		Unprotected: map[interface{}]interface{}{
+			CommonHeaderIDKeyID: []byte("unprotected"),
+			"custom":            "value",
+			int64(-70000):       true,
+		},
+	}
+
+	value, found := h.Lookup("alg")
+	assert.True(found)
+	assert.Equal(ES256.Value, value)
+
+	value, found = h.Lookup(CommonHeaderIDKeyID)
+	assert.True(found)
+	assert.Equal([]byte("protected"), value)
+
+	value, found = h.Lookup("custom")
+	assert.True(found)
+	assert.Equal("value", value)
+
+	value, found = h.Lookup(-70000)
+	assert.True(found)
+	assert.Equal(true, value)
+
+	for _, label := range []interface{}{"iv", 33, []byte("custom"), nil} {
+		value, found = h.Lookup(label)
+		assert.False(found, "found %v", label)
+		assert.Nil(value)
+	}
+
+	var nilHeaders *Headers
+	_, found = nilHeaders.Lookup("alg")
+	assert.False(found)
+}
+
+func TestSignNamedHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	msg.Headers.Protected["content type"] = "text/plain"
+	msg.Headers.Protected["crit"] = []interface{}{"content type"}
+	sig := NewSignature()
+	sig.Headers.Protected["alg"] = "ES256"
+	sig.Headers.Unprotected["kid"] = []byte("named")
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+
+	// signing compresses the headers once
+	assert.Equal(map[interface{}]interface{}{
+		CommonHeaderIDContentType: "text/plain",
+		CommonHeaderIDCrit:        []interface{}{"content type"},
+	}, msg.Headers.Protected)
+	assert.Equal(map[interface{}]interface{}{CommonHeaderIDAlg: ES256.Value}, msg.Signatures[0].Headers.Protected)
+	assert.Equal(map[interface{}]interface{}{CommonHeaderIDKeyID: []byte("named")}, msg.Signatures[0].Headers.Unprotected)
+	assert.Nil(msg.Verify(nil, verifiers))
+
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+	decoded, err := Unmarshal(msgBytes)
+	assert.Nil(err)
+	decodedMsg := decoded.(SignMessage)
+	assert.Equal(msg.Headers.Protected, decodedMsg.Headers.Protected)
+	assert.Equal(msg.Signatures[0].Headers.Protected, decodedMsg.Signatures[0].Headers.Protected)
+	assert.Nil(decodedMsg.Verify(nil, verifiers))
+	assert.Nil(decodedMsg.StrictVerify(nil, verifiers))
+
+	// names colliding with int labels are errors instead of panics
+	collide := NewSignMessage()
+	collide.Payload = []byte("payload")
+	sig = NewSignature()
+	sig.Headers.Protected["alg"] = "ES256"
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	collide.AddSignature(sig)
+	err = collide.Sign(rand.Reader, nil, []Signer{*signer})
+	assert.Equal("SignMessage signature 0: protected headers: Duplicate compressed and uncompressed common header 1 found", err.Error())
+
+	delete(sig.Headers.Protected, "alg")
+	collide.Headers.Protected["kid"] = []byte("kid")
+	collide.Headers.Unprotected[CommonHeaderIDKeyID] = []byte("kid")
+	err = collide.Sign(rand.Reader, nil, []Signer{*signer})
+	assert.Equal("SignMessage: Duplicate header 4 found", err.Error())
+
+	var h *Headers
+	assert.Equal("Cannot compress nil Headers", h.Compress().Error())
+}
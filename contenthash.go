@@ -0,0 +1,112 @@
+package cose
+
+import (
+	"bytes"
+	"crypto"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// HeaderLabelPayloadHashAlg is the protected header label for the
+// hash algorithm of a payload that is the hash of the content
+//
+// https://datatracker.ietf.org/doc/draft-ietf-cose-hash-envelope/
+const HeaderLabelPayloadHashAlg = 258
+
+// hashAlgorithm returns the COSE hash Algorithm for hash
+func hashAlgorithm(hash crypto.Hash) (alg *Algorithm, err error) {
+	for _, a := range algorithmTable() {
+		if a.HashFunc == hash && isHashAlgorithm(&a) {
+			return &a, nil
+		}
+	}
+	return nil, errors.Errorf("no COSE hash algorithm for %v", hash)
+}
+
+// hashContent returns the digest of content read to EOF
+func hashContent(alg *Algorithm, content io.Reader) (digest []byte, err error) {
+	if !alg.HashFunc.Available() {
+		return nil, ErrUnavailableHashFunc
+	}
+	hasher := alg.HashFunc.New()
+	_, err = io.Copy(hasher, content)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading content")
+	}
+	return hasher.Sum(nil), nil
+}
+
+// SignContentHash returns a SignMessage with the hash of content as
+// its payload signed by signer e.g. for an artifact too large to
+// sign or send with the signature
+//
+// The content is read once to compute its hash. protected are the
+// message protected headers, to which the hash algorithm is added with
+// label HeaderLabelPayloadHashAlg.
+func SignContentHash(rand io.Reader, hash crypto.Hash, content io.Reader, external []byte, protected map[interface{}]interface{}, signer Signer) (m *SignMessage, err error) {
+	if signer.alg == nil {
+		return nil, errors.New("Signer has no algorithm")
+	}
+	hashAlg, err := hashAlgorithm(hash)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := hashContent(hashAlg, content)
+	if err != nil {
+		return nil, err
+	}
+
+	m = NewSignMessage()
+	m.Payload = digest
+	for k, v := range protected {
+		m.Headers.Protected[k] = v
+	}
+	m.Headers.Protected[HeaderLabelPayloadHashAlg] = hashAlg.Value
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = signer.alg.Value
+	m.AddSignature(sig)
+
+	err = m.Sign(rand, external, []Signer{signer})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// VerifyContentHash verifies the signatures on a SignMessage from
+// SignContentHash and that its payload is the hash of content
+// computed with the protected HeaderLabelPayloadHashAlg algorithm
+func (m *SignMessage) VerifyContentHash(content io.Reader, external []byte, verifiers []Verifier) (err error) {
+	if m == nil || m.Headers == nil {
+		return errors.New("SignMessage has nil Headers")
+	}
+	o, ok := findHeader(m.Headers.Protected, HeaderLabelPayloadHashAlg)
+	if !ok {
+		return errors.New("SignMessage has no protected payload hash alg header")
+	}
+	algValue, ok := intFromInteger(o)
+	if !ok {
+		return errors.Errorf("error casting payload hash alg to int; got %T", o)
+	}
+	hashAlg, err := getAlgByValue(algValue)
+	if err != nil {
+		return err
+	}
+	if !isHashAlgorithm(hashAlg) {
+		return errors.Errorf("payload hash alg %s is not a hash algorithm", hashAlg.Name)
+	}
+
+	err = m.Verify(external, verifiers)
+	if err != nil {
+		return err
+	}
+	digest, err := hashContent(hashAlg, content)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(digest, m.Payload) {
+		return errors.New("content does not match the payload hash")
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package cose
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha512"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestSignContentHash(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+	content := bytes.Repeat([]byte("artifact"), 100000)
+
+	protected := map[interface{}]interface{}{"content type": "application/octet-stream"}
+	msg, err := SignContentHash(rand.Reader, crypto.SHA384, bytes.NewReader(content), nil, protected, *signer)
+	assert.Nil(err)
+	digest := sha512.Sum384(content)
+	assert.Equal(digest[:], msg.Payload)
+	assert.Equal(-43, msg.Headers.Protected[HeaderLabelPayloadHashAlg])
+	assert.Equal("application/octet-stream", msg.Headers.Protected[CommonHeaderIDContentType])
+
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+	decoded, err := Unmarshal(msgBytes)
+	assert.Nil(err)
+	decodedMsg := decoded.(SignMessage)
+	assert.Nil(decodedMsg.VerifyContentHash(bytes.NewReader(content), nil, verifiers))
+
+	tampered := append([]byte{}, content...)
+	tampered[0] ^= 0xff
+	assert.Equal("content does not match the payload hash", decodedMsg.VerifyContentHash(bytes.NewReader(tampered), nil, verifiers).Error())
+	assert.Equal(ErrECDSAVerification, decodedMsg.VerifyContentHash(bytes.NewReader(content), []byte("external"), verifiers))
+	assert.Equal("error reading content: read failed", decodedMsg.VerifyContentHash(errReader{}, nil, verifiers).Error())
+
+	// the hash algorithm comes from the protected headers
+	decodedMsg.Headers.Protected[HeaderLabelPayloadHashAlg] = ES256.Value
+	assert.Equal("payload hash alg ES256 is not a hash algorithm", decodedMsg.VerifyContentHash(bytes.NewReader(content), nil, verifiers).Error())
+	delete(decodedMsg.Headers.Protected, HeaderLabelPayloadHashAlg)
+	decodedMsg.Headers.Unprotected[HeaderLabelPayloadHashAlg] = -43
+	assert.Equal("SignMessage has no protected payload hash alg header", decodedMsg.VerifyContentHash(bytes.NewReader(content), nil, verifiers).Error())
+
+	_, err = SignContentHash(rand.Reader, crypto.MD5, bytes.NewReader(content), nil, nil, *signer)
+	assert.Equal("no COSE hash algorithm for MD5", err.Error())
+	_, err = SignContentHash(rand.Reader, crypto.SHA256, errReader{}, nil, nil, *signer)
+	assert.Equal("error reading content: read failed", err.Error())
+	_, err = SignContentHash(rand.Reader, crypto.SHA256, bytes.NewReader(content), nil, nil, Signer{})
+	assert.Equal("Signer has no algorithm", err.Error())
+}
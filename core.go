@@ -7,6 +7,8 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
 	"encoding/base64"
 	"github.com/pkg/errors"
 	"io"
@@ -18,11 +20,30 @@ import (
 // https://tools.ietf.org/html/rfc8152#section-4.4
 const ContextSignature = "Signature"
 
+// ContextSignature1 identifies the context of the signature as a
+// COSE_Sign1 structure per
+// https://tools.ietf.org/html/rfc8152#section-4.4
+const ContextSignature1 = "Signature1"
+
+// ContextCounterSignature identifies the context of the signature as
+// a COSE_Countersignature structure per
+// https://tools.ietf.org/html/rfc9338#section-3.3
+const ContextCounterSignature = "CounterSignature"
+
 // Supported Algorithms
 var (
 	// PS256 is RSASSA-PSS w/ SHA-256 from [RFC8230]
 	PS256 = getAlgByNameOrPanic("PS256")
 
+	// RS256 is RSASSA-PKCS1-v1_5 using SHA-256 from [RFC8812]
+	RS256 = getAlgByNameOrPanic("RS256")
+
+	// RS384 is RSASSA-PKCS1-v1_5 using SHA-384 from [RFC8812]
+	RS384 = getAlgByNameOrPanic("RS384")
+
+	// RS512 is RSASSA-PKCS1-v1_5 using SHA-512 from [RFC8812]
+	RS512 = getAlgByNameOrPanic("RS512")
+
 	// ES256 is ECDSA w/ SHA-256 from [RFC8152]
 	ES256 = getAlgByNameOrPanic("ES256")
 
@@ -46,9 +67,14 @@ type ByteVerifier interface {
 }
 
 // Signer holds a COSE Algorithm and private key for signing messages
+//
+// When RestrictDigestLength is true, Sign only signs digests with the
+// output size of the Algorithm's hash function, so a Signer wired to
+// an API cannot be used to sign arbitrary data.
 type Signer struct {
-	PrivateKey crypto.PrivateKey
-	alg        *Algorithm
+	PrivateKey           crypto.PrivateKey
+	RestrictDigestLength bool
+	alg                  *Algorithm
 }
 
 // RSAOptions are options for NewSigner currently just the RSA Key
@@ -59,31 +85,60 @@ type RSAOptions struct {
 
 // NewSigner returns a Signer with a generated key
 func NewSigner(alg *Algorithm, options interface{}) (signer *Signer, err error) {
-	var privateKey crypto.PrivateKey
+	var keyBitLen int = alg.minRSAKeyBitLen
+
+	if alg.privateKeyType == KeyTypeRSA {
+		if opts, ok := options.(RSAOptions); ok {
+			if opts.Size > alg.minRSAKeyBitLen {
+				keyBitLen = opts.Size
+			} else {
+				err = errors.Errorf("error generating rsa signer private key RSA key size must be at least %d", alg.minRSAKeyBitLen)
+				return nil, err
+			}
+		}
+	}
+
+	privateKey, err := generateKey(alg, keyBitLen, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
 
+	return &Signer{
+		PrivateKey: privateKey,
+		alg:        alg,
+	}, nil
+}
+
+// GenerateKey returns a new *ecdsa.PrivateKey or *rsa.PrivateKey
+// for the algorithm named algName using its curve or minimum RSA key
+// size
+//
+// It returns ErrUnknownPrivateKeyType for algorithms without a
+// supported private key type.
+func GenerateKey(algName string, rand io.Reader) (privateKey crypto.PrivateKey, err error) {
+	alg, err := getAlgByName(algName)
+	if err != nil {
+		return nil, err
+	}
+	return generateKey(alg, alg.minRSAKeyBitLen, rand)
+}
+
+// generateKey returns a new private key for alg with RSA keys of
+// rsaKeyBitLen bits
+func generateKey(alg *Algorithm, rsaKeyBitLen int, rand io.Reader) (privateKey crypto.PrivateKey, err error) {
 	if alg.privateKeyType == KeyTypeECDSA {
 		if alg.privateKeyECDSACurve == nil {
 			err = errors.Errorf("No ECDSA curve found for algorithm")
 			return nil, err
 		}
 
-		privateKey, err = ecdsa.GenerateKey(alg.privateKeyECDSACurve, rand.Reader)
+		privateKey, err = ecdsa.GenerateKey(alg.privateKeyECDSACurve, rand)
 		if err != nil {
 			err = errors.Wrapf(err, "error generating ecdsa signer private key")
 			return nil, err
 		}
 	} else if alg.privateKeyType == KeyTypeRSA {
-		var keyBitLen int = alg.minRSAKeyBitLen
-
-		if opts, ok := options.(RSAOptions); ok {
-			if opts.Size > alg.minRSAKeyBitLen {
-				keyBitLen = opts.Size
-			} else {
-				err = errors.Errorf("error generating rsa signer private key RSA key size must be at least %d", alg.minRSAKeyBitLen)
-				return nil, err
-			}
-		}
-		privateKey, err = rsa.GenerateKey(rand.Reader, keyBitLen)
+		privateKey, err = rsa.GenerateKey(rand, rsaKeyBitLen)
 		if err != nil {
 			err = errors.Wrapf(err, "error generating rsa signer private key")
 			return nil, err
@@ -91,11 +146,7 @@ func NewSigner(alg *Algorithm, options interface{}) (signer *Signer, err error)
 	} else {
 		return nil, ErrUnknownPrivateKeyType
 	}
-
-	return &Signer{
-		PrivateKey: privateKey,
-		alg:        alg,
-	}, nil
+	return privateKey, nil
 }
 
 // NewSignerFromKey checks whether the privateKey is supported and
@@ -127,6 +178,15 @@ func (s *Signer) Public() (publicKey crypto.PublicKey) {
 
 // Sign returns the COSE signature as a byte slice
 func (s *Signer) Sign(rand io.Reader, digest []byte) (signature []byte, err error) {
+	if s.RestrictDigestLength {
+		if !s.alg.HashFunc.Available() {
+			return nil, ErrUnavailableHashFunc
+		}
+		if len(digest) != s.alg.HashFunc.Size() {
+			return nil, errors.Errorf("Expected %d byte digest, got %d bytes instead", s.alg.HashFunc.Size(), len(digest))
+		}
+	}
+
 	switch key := s.PrivateKey.(type) {
 	case *rsa.PrivateKey:
 		if s.alg.privateKeyType != KeyTypeRSA {
@@ -136,6 +196,14 @@ func (s *Signer) Sign(rand io.Reader, digest []byte) (signature []byte, err erro
 			return nil, errors.Errorf("RSA key must be at least %d bits long", s.alg.minRSAKeyBitLen)
 		}
 
+		if s.alg.rsaPKCS1v15 {
+			sig, err := rsa.SignPKCS1v15(rand, key, s.alg.HashFunc, digest)
+			if err != nil {
+				return nil, errors.Errorf("rsa.SignPKCS1v15 error %s", err)
+			}
+			return sig, nil
+		}
+
 		sig, err := rsa.SignPSS(rand, key, s.alg.HashFunc, digest, &rsa.PSSOptions{
 			SaltLength: rsa.PSSSaltLengthEqualsHash,
 			Hash:       s.alg.HashFunc,
@@ -149,6 +217,15 @@ func (s *Signer) Sign(rand io.Reader, digest []byte) (signature []byte, err erro
 			return nil, errors.Errorf("Key type must be ECDSA")
 		}
 
+		sigByteLen, err := SignatureByteLenForAlgID(s.alg.Value)
+		if err != nil {
+			return nil, err
+		}
+		n := sigByteLen / 2
+		if keyBytesSize := ecdsaCurveKeyBytesSize(key.Curve); keyBytesSize != n {
+			return nil, errors.Errorf("Expected %d byte key, got %d bytes instead", n, keyBytesSize)
+		}
+
 		// https://tools.ietf.org/html/rfc8152#section-8.1
 		r, s, err := ecdsa.Sign(rand, key, digest)
 		if err != nil {
@@ -164,24 +241,36 @@ func (s *Signer) Sign(rand io.Reader, digest []byte) (signature []byte, err erro
 			return nil, errors.Errorf("Byte lengths of integers r and s (%d and %d) do not match the key length %d±%d\n", sByteLen, rByteLen, dByteLen, tolerance)
 		}
 
-		// The signature is encoded by converting the integers
-		// into byte strings of the same length as the key
-		// size.  The length is rounded up to the nearest byte
-		// and is left padded with zero bits to get to the
-		// correct length.  The two integers are then
-		// concatenated together to form a byte string that is
-		// the resulting signature.
-		n := ecdsaCurveKeyBytesSize(key.Curve)
-		sig := make([]byte, 0)
-		sig = append(sig, I2OSP(r, n)...)
-		sig = append(sig, I2OSP(s, n)...)
-
-		return sig, nil
+		return ecdsaSignatureBytes(r, s, n)
 	default:
 		return nil, ErrUnknownPrivateKeyType
 	}
 }
 
+// ecdsaSignatureBytes returns the COSE encoding of the ECDSA integers
+// r and s for a key of n bytes or an error when r or s are too large
+//
+// https://tools.ietf.org/html/rfc8152#section-8.1
+func ecdsaSignatureBytes(r, s *big.Int, n int) (sig []byte, err error) {
+	for _, i := range []*big.Int{r, s} {
+		if size := len(i.Bytes()); size > n {
+			return nil, errors.Errorf("ECDSA integer is %d bytes; expected at most %d", size, n)
+		}
+	}
+
+	// The signature is encoded by converting the integers
+	// into byte strings of the same length as the key
+	// size.  The length is rounded up to the nearest byte
+	// and is left padded with zero bits to get to the
+	// correct length.  The two integers are then
+	// concatenated together to form a byte string that is
+	// the resulting signature.
+	sig = make([]byte, 0)
+	sig = append(sig, I2OSP(r, n)...)
+	sig = append(sig, I2OSP(s, n)...)
+	return sig, nil
+}
+
 // Verifier returns a Verifier using the Signer's public key and
 // Algorithm
 func (s *Signer) Verifier() (verifier *Verifier) {
@@ -192,12 +281,63 @@ func (s *Signer) Verifier() (verifier *Verifier) {
 }
 
 // Verifier holds a PublicKey and Algorithm to verify signatures
+//
+// Certificate is optional. When set, SignMessage.Verify checks it
+// against the signature's x5t header to bind the certificate to the
+// signature.
+//
+// DEREncoded verifies ECDSA signatures in the ASN.1 DER form other
+// tools produce instead of the fixed width r || s form COSE uses.
 type Verifier struct {
-	PublicKey crypto.PublicKey
-	Alg       *Algorithm
+	PublicKey   crypto.PublicKey
+	Alg         *Algorithm
+	Certificate *x509.Certificate
+	DEREncoded  bool
+}
+
+// NewVerifierFromPublicKey checks whether the *ecdsa.PublicKey or
+// *rsa.PublicKey pub is supported by the algorithm named algName and
+// returns a Verifier using it
+func NewVerifierFromPublicKey(algName string, pub crypto.PublicKey) (verifier *Verifier, err error) {
+	alg, err := getAlgByName(algName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if alg.privateKeyType != KeyTypeRSA {
+			return nil, errors.Errorf("Key type must be RSA")
+		}
+		if key.N.BitLen() < alg.minRSAKeyBitLen {
+			return nil, errors.Errorf("RSA key must be at least %d bits long", alg.minRSAKeyBitLen)
+		}
+	case *ecdsa.PublicKey:
+		if alg.privateKeyType != KeyTypeECDSA {
+			return nil, errors.Errorf("Key type must be ECDSA")
+		}
+		algCurveBitSize := alg.privateKeyECDSACurve.Params().BitSize
+		keyCurveBitSize := key.Curve.Params().BitSize
+		if algCurveBitSize != keyCurveBitSize {
+			return nil, errors.Errorf("Expected %d bit key, got %d bits instead", algCurveBitSize, keyCurveBitSize)
+		}
+	default:
+		return nil, ErrUnknownPublicKeyType
+	}
+
+	return &Verifier{
+		PublicKey: pub,
+		Alg:       alg,
+	}, nil
 }
 
 // Verify verifies a signature returning nil for success or an error
+//
+// Verification only uses public data (the key, digest, and signature
+// bytes), so the checks on signature length return early and with
+// different errors without leaking secrets. This package has no MAC
+// or other symmetric key algorithms that would need constant time
+// comparisons.
 func (v *Verifier) Verify(digest []byte, signature []byte) (err error) {
 	if v.Alg.Value > -1 { // Negative numbers are used for second layer objects (COSE_Signature and COSE_recipient)
 		return ErrInvalidAlg
@@ -207,6 +347,14 @@ func (v *Verifier) Verify(digest []byte, signature []byte) (err error) {
 	case *rsa.PublicKey:
 		hashFunc := v.Alg.HashFunc
 
+		if v.Alg.rsaPKCS1v15 {
+			err = rsa.VerifyPKCS1v15(key, hashFunc, digest, signature)
+			if err != nil {
+				return errors.Errorf("verification failed rsa.VerifyPKCS1v15 err %s", err)
+			}
+			return nil
+		}
+
 		err = rsa.VerifyPSS(key, hashFunc, digest, signature, &rsa.PSSOptions{
 			SaltLength: rsa.PSSSaltLengthEqualsHash,
 			Hash:       hashFunc,
@@ -227,15 +375,27 @@ func (v *Verifier) Verify(digest []byte, signature []byte) (err error) {
 			return errors.Errorf("Expected %d bit key, got %d bits instead", algCurveBitSize, keyCurveBitSize)
 		}
 
-		algKeyBytesSize := ecdsaCurveKeyBytesSize(v.Alg.privateKeyECDSACurve)
+		var r, s *big.Int
+		if v.DEREncoded {
+			r, s, err = decodeECDSASignatureDER(signature)
+			if err != nil {
+				return err
+			}
+		} else {
+			sigByteLen, err := SignatureByteLenForAlgID(v.Alg.Value)
+			if err != nil {
+				return err
+			}
+			algKeyBytesSize := sigByteLen / 2
 
-		// signature bytes is the keys with padding r and s
-		if len(signature) != 2*algKeyBytesSize {
-			return errors.Errorf("invalid signature length: %d", len(signature))
-		}
+			// signature bytes is the keys with padding r and s
+			if len(signature) != sigByteLen {
+				return errors.Errorf("invalid signature length: %d", len(signature))
+			}
 
-		r := big.NewInt(0).SetBytes(signature[:algKeyBytesSize])
-		s := big.NewInt(0).SetBytes(signature[algKeyBytesSize:])
+			r = big.NewInt(0).SetBytes(signature[:algKeyBytesSize])
+			s = big.NewInt(0).SetBytes(signature[algKeyBytesSize:])
+		}
 
 		ok := ecdsa.Verify(key, digest, r, s)
 		if ok {
@@ -247,6 +407,27 @@ func (v *Verifier) Verify(digest []byte, signature []byte) (err error) {
 	}
 }
 
+// decodeECDSASignatureDER returns r and s from an ASN.1 DER encoded
+// ECDSA-Sig-Value
+//
+// https://tools.ietf.org/html/rfc3279#section-2.2.3
+func decodeECDSASignatureDER(signature []byte) (r, s *big.Int, err error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	rest, err := asn1.Unmarshal(signature, &sig)
+	if err != nil {
+		return nil, nil, errors.Errorf("error decoding DER ECDSA signature: %s", err)
+	}
+	if len(rest) > 0 {
+		return nil, nil, errors.Errorf("DER ECDSA signature has %d trailing bytes", len(rest))
+	}
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 {
+		return nil, nil, errors.New("DER ECDSA signature r and s must be positive")
+	}
+	return sig.R, sig.S, nil
+}
+
 // buildAndMarshalSigStructure creates a Sig_structure, populates it
 // with the appropriate fields, and marshals it to CBOR bytes
 func buildAndMarshalSigStructure(bodyProtected, signProtected, external, payload []byte) (ToBeSigned []byte, err error) {
@@ -259,6 +440,12 @@ func buildAndMarshalSigStructure(bodyProtected, signProtected, external, payload
 	//     external_aad : bstr,
 	//     payload : bstr
 	// ]
+	//
+	// external_aad is always a bstr and is a zero-length bstr, not
+	// nil, when there is no external data
+	if external == nil {
+		external = []byte{}
+	}
 	sigStructure := []interface{}{
 		ContextSignature,
 		bodyProtected, // message.headers.EncodeProtected(),
@@ -276,6 +463,67 @@ func buildAndMarshalSigStructure(bodyProtected, signProtected, external, payload
 	return ToBeSigned, nil
 }
 
+// buildAndMarshalCounterSigStructure creates a Countersign_structure
+// over the signature bytes of the target, encodes it, and returns the
+// encoded bytes to be signed
+func buildAndMarshalCounterSigStructure(bodyProtected, signProtected, external, payload, targetSignature []byte) (ToBeSigned []byte, err error) {
+	// Countersign_structure = [
+	//     context : "CounterSignature" / "CounterSignature0",
+	//     body_protected : empty_or_serialized_map,
+	//     ? sign_protected : empty_or_serialized_map,
+	//     external_aad : bstr,
+	//     payload : bstr,
+	//     ? other_fields : [+ bstr ]
+	// ]
+	//
+	// other_fields holds the signature of the COSE_Signature being
+	// countersigned
+	if external == nil {
+		external = []byte{}
+	}
+	sigStructure := []interface{}{
+		ContextCounterSignature,
+		bodyProtected,
+		signProtected,
+		external,
+		payload,
+		[]interface{}{targetSignature},
+	}
+
+	ToBeSigned, err = Marshal(sigStructure)
+	if err != nil {
+		return nil, errors.Errorf("Error marshaling Countersign_structure: %s", err)
+	}
+	return ToBeSigned, nil
+}
+
+// Sign1ToBeSigned creates a COSE_Sign1 Sig_structure from the
+// serialized protected header, external data, and payload and returns
+// its CBOR encoding i.e. the ToBeSigned bytes
+func Sign1ToBeSigned(protected, external, payload []byte) (ToBeSigned []byte, err error) {
+	// Sig_structure = [
+	//     context : "Signature1",
+	//     body_protected : empty_or_serialized_map,
+	//     external_aad : bstr,
+	//     payload : bstr
+	// ]
+	if external == nil {
+		external = []byte{}
+	}
+	sigStructure := []interface{}{
+		ContextSignature1,
+		protected,
+		external,
+		payload,
+	}
+
+	ToBeSigned, err = Marshal(sigStructure)
+	if err != nil {
+		return nil, errors.Errorf("Error marshaling Sig_structure: %s", err)
+	}
+	return ToBeSigned, nil
+}
+
 // hashSigStructure computes the crypto.Hash digest of a byte slice
 func hashSigStructure(ToBeSigned []byte, hash crypto.Hash) (digest []byte, err error) {
 	if !hash.Available() {
@@ -287,6 +535,22 @@ func hashSigStructure(ToBeSigned []byte, hash crypto.Hash) (digest []byte, err e
 	return digest, nil
 }
 
+// SignatureByteLenForAlgID returns the length in bytes of the
+// concatenated r and s integers of an ECDSA signature for the
+// algorithm with IANA value algID
+//
+// https://tools.ietf.org/html/rfc8152#section-8.1
+func SignatureByteLenForAlgID(algID int) (sigByteLen int, err error) {
+	alg, err := getAlgByValue(algID)
+	if err != nil {
+		return 0, err
+	}
+	if alg.privateKeyECDSACurve == nil {
+		return 0, errors.Errorf("Could not find an elliptic curve for algorithm %s", alg.Name)
+	}
+	return 2 * ecdsaCurveKeyBytesSize(alg.privateKeyECDSACurve), nil
+}
+
 // ecdsaCurveKeyBytesSize returns the ECDSA key size in bytes with padding
 func ecdsaCurveKeyBytesSize(curve elliptic.Curve) (keyBytesSize int) {
 	curveBits := curve.Params().BitSize
@@ -348,6 +612,20 @@ func Sign(rand io.Reader, digest []byte, signers []ByteSigner) (signatures [][]b
 	return
 }
 
+// SignDigest returns the SignatureBytes from signer for a digest
+// computed elsewhere e.g. when the signing key is on another system
+//
+// The caller is responsible for digest being the hash of the
+// Sig_structure for the signature (see SignMessage.SigStructure)
+// using the signature algorithm's hash function. Set
+// Signer.RestrictDigestLength to reject digests of the wrong length.
+func SignDigest(rand io.Reader, digest []byte, signer ByteSigner) (signature []byte, err error) {
+	if signer == nil {
+		return nil, ErrNoSignerFound
+	}
+	return signer.Sign(rand, digest)
+}
+
 // Verify returns nil if all Verifier verify the SignatureBytes or the
 // error from the first failing Verifier
 func Verify(digest []byte, signatures [][]byte, verifiers []ByteVerifier) (err error) {
@@ -1,11 +1,14 @@
 package cose
 
 import (
+	"crypto"
 	"crypto/dsa"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"math/big"
@@ -98,6 +101,38 @@ func TestNewSigner(t *testing.T) {
 	assert.Equal(ErrUnknownPrivateKeyType, err, "Did not error creating signer with unsupported dsaPrivateKey")
 }
 
+func TestGenerateKey(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, alg := range []*Algorithm{ES256, ES384, ES512} {
+		key, err := GenerateKey(alg.Name, rand.Reader)
+		assert.Nil(err, alg.Name)
+		ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+		assert.True(ok, alg.Name)
+		assert.Equal(alg.privateKeyECDSACurve, ecdsaKey.Curve, alg.Name)
+	}
+
+	key, err := GenerateKey("PS256", rand.Reader)
+	assert.Nil(err)
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	assert.True(ok)
+	assert.Equal(2048, rsaKey.N.BitLen())
+
+	// the generated key signs for the algorithm
+	signer, err := NewSignerFromKey(PS256, key)
+	assert.Nil(err)
+	digest := sha256.Sum256([]byte("ahoy"))
+	signatureBytes, err := signer.Sign(rand.Reader, digest[:])
+	assert.Nil(err)
+	assert.Nil(signer.Verifier().Verify(digest[:], signatureBytes))
+
+	_, err = GenerateKey("EdDSA", rand.Reader)
+	assert.Equal(ErrUnknownPrivateKeyType, err)
+
+	_, err = GenerateKey("FOOOO", rand.Reader)
+	assert.Equal("Algorithm named FOOOO not found", err.Error())
+}
+
 func TestSignerPublic(t *testing.T) {
 	assert := assert.New(t)
 
@@ -165,6 +200,131 @@ func TestVerifyRSASuccess(t *testing.T) {
 	assert.Nil(err)
 }
 
+func TestSignVerifyRSAPKCS1v15(t *testing.T) {
+	assert := assert.New(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err, "Error creating RSA key")
+
+	for _, alg := range []*Algorithm{RS256, RS384, RS512} {
+		signer, err := NewSignerFromKey(alg, key)
+		assert.Nil(err, "Error creating signer")
+
+		hasher := alg.HashFunc.New()
+		_, _ = hasher.Write([]byte("ahoy")) // Write() on hash never fails
+		digest := hasher.Sum(nil)
+
+		signatureBytes, err := signer.Sign(rand.Reader, digest)
+		assert.Nil(err, alg.Name)
+
+		// PKCS1 v1.5 signatures are deterministic
+		expected, err := rsa.SignPKCS1v15(rand.Reader, key, alg.HashFunc, digest)
+		assert.Nil(err)
+		assert.Equal(expected, signatureBytes, alg.Name)
+
+		verifier := signer.Verifier()
+		assert.Nil(verifier.Verify(digest, signatureBytes), alg.Name)
+
+		digest[0] ^= 0xff
+		err = verifier.Verify(digest, signatureBytes)
+		assert.Equal("verification failed rsa.VerifyPKCS1v15 err crypto/rsa: verification error", err.Error(), alg.Name)
+	}
+
+	// PSS signatures do not verify as PKCS1 v1.5 signatures
+	signer, err := NewSignerFromKey(PS256, key)
+	assert.Nil(err, "Error creating signer")
+	digest := sha256.Sum256([]byte("ahoy"))
+	signatureBytes, err := signer.Sign(rand.Reader, digest[:])
+	assert.Nil(err)
+	verifier := Verifier{PublicKey: key.Public(), Alg: RS256}
+	assert.NotNil(verifier.Verify(digest[:], signatureBytes))
+
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(err, "Error creating weak RSA key")
+	signer, err = NewSignerFromKey(RS256, weakKey)
+	assert.Nil(err, "Error creating signer")
+	_, err = signer.Sign(rand.Reader, digest[:])
+	assert.Equal("RSA key must be at least 2048 bits long", err.Error())
+}
+
+func TestNewVerifierFromPublicKey(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	digest := sha256.Sum256([]byte("ahoy"))
+	signatureBytes, err := signer.Sign(rand.Reader, digest[:])
+	assert.Nil(err)
+
+	verifier, err := NewVerifierFromPublicKey("ES256", signer.Public())
+	assert.Nil(err)
+	assert.Equal(ES256, verifier.Alg)
+	assert.Nil(verifier.Verify(digest[:], signatureBytes))
+
+	rsaKey, err := GenerateKey("PS256", rand.Reader)
+	assert.Nil(err, "Error creating RSA key")
+	verifier, err = NewVerifierFromPublicKey("PS256", rsaKey.(*rsa.PrivateKey).Public())
+	assert.Nil(err)
+	assert.Equal(PS256, verifier.Alg)
+
+	var tests = []struct {
+		algName string
+		pub     interface{}
+		err     string
+	}{
+		{"ES384", signer.Public(), "Expected 384 bit key, got 256 bits instead"},
+		{"PS256", signer.Public(), "Key type must be ECDSA"},
+		{"ES256", rsaPrivateKey.Public(), "Key type must be RSA"},
+		{"ES256", dsaPrivateKey.PublicKey, ErrUnknownPublicKeyType.Error()},
+		{"FOOOO", signer.Public(), "Algorithm named FOOOO not found"},
+	}
+	for _, test := range tests {
+		verifier, err = NewVerifierFromPublicKey(test.algName, test.pub)
+		assert.Nil(verifier)
+		assert.Equal(test.err, err.Error())
+	}
+
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(err, "Error creating weak RSA key")
+	_, err = NewVerifierFromPublicKey("RS256", weakKey.Public())
+	assert.Equal("RSA key must be at least 2048 bits long", err.Error())
+}
+
+func TestSignerRestrictDigestLength(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, alg := range []*Algorithm{ES256, ES384, PS256} {
+		signer, err := NewSigner(alg, nil)
+		assert.Nil(err, "Error creating signer")
+
+		hasher := alg.HashFunc.New()
+		_, _ = hasher.Write([]byte("ahoy")) // Write() on hash never fails
+		digest := hasher.Sum(nil)
+
+		// unrestricted ECDSA signers sign any length digest
+		if alg.privateKeyType == KeyTypeECDSA {
+			_, err = signer.Sign(rand.Reader, digest[:20])
+			assert.Nil(err, alg.Name)
+		}
+
+		signer.RestrictDigestLength = true
+		signatureBytes, err := signer.Sign(rand.Reader, digest)
+		assert.Nil(err, alg.Name)
+		assert.Nil(signer.Verifier().Verify(digest, signatureBytes), alg.Name)
+
+		_, err = signer.Sign(rand.Reader, digest[:20])
+		assert.Equal(fmt.Sprintf("Expected %d byte digest, got 20 bytes instead", alg.HashFunc.Size()), err.Error(), alg.Name)
+
+		_, err = signer.Sign(rand.Reader, append(digest, 0))
+		assert.NotNil(err, alg.Name)
+	}
+
+	edDSA := getAlgByNameOrPanic("EdDSA")
+	signer := Signer{PrivateKey: &ecdsaPrivateKey, RestrictDigestLength: true, alg: edDSA}
+	_, err := signer.Sign(rand.Reader, []byte("digest"))
+	assert.Equal(ErrUnavailableHashFunc, err)
+}
+
 func TestVerifyInvalidAlgErrors(t *testing.T) {
 	assert := assert.New(t)
 
@@ -229,6 +389,68 @@ func TestSignVerifyWithoutMessage(t *testing.T) {
 	assert.Equal(err.Error(), "Wrong number of signatures 1 and verifiers 0")
 }
 
+func TestVerifyDEREncodedECDSA(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating ES256 signer")
+	digest := sha256.Sum256([]byte("signed by another tool"))
+
+	r, s, err := ecdsa.Sign(rand.Reader, signer.PrivateKey.(*ecdsa.PrivateKey), digest[:])
+	assert.Nil(err)
+	der, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	assert.Nil(err)
+
+	verifier := signer.Verifier()
+	assert.Equal(fmt.Sprintf("invalid signature length: %d", len(der)), verifier.Verify(digest[:], der).Error())
+
+	verifier.DEREncoded = true
+	assert.Nil(verifier.Verify(digest[:], der))
+
+	otherDigest := sha256.Sum256([]byte("other"))
+	assert.Equal(ErrECDSAVerification, verifier.Verify(otherDigest[:], der))
+
+	assert.Equal("DER ECDSA signature has 1 trailing bytes", verifier.Verify(digest[:], append(der, 0)).Error())
+	assert.Contains(verifier.Verify(digest[:], []byte("not der")).Error(), "error decoding DER ECDSA signature: ")
+
+	negative, err := asn1.Marshal(struct{ R, S *big.Int }{big.NewInt(-1), s})
+	assert.Nil(err)
+	assert.Equal("DER ECDSA signature r and s must be positive", verifier.Verify(digest[:], negative).Error())
+
+	// COSE signatures are not DER encoded
+	cose, err := signer.Sign(rand.Reader, digest[:])
+	assert.Nil(err)
+	assert.NotNil(verifier.Verify(digest[:], cose))
+}
+
+func TestSignDigest(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating ES256 signer")
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+
+	// split signing hashes the Sig_structure before signing the digest
+	ToBeSigned, err := msg.SigStructure(nil, sig)
+	assert.Nil(err)
+	digest := sha256.Sum256(ToBeSigned)
+	msg.Signatures[0].SignatureBytes, err = SignDigest(rand.Reader, digest[:], signer)
+	assert.Nil(err)
+	assert.Nil(msg.Verify(nil, []Verifier{*signer.Verifier()}))
+
+	signer.RestrictDigestLength = true
+	_, err = SignDigest(rand.Reader, ToBeSigned, signer)
+	assert.Equal(fmt.Sprintf("Expected 32 byte digest, got %d bytes instead", len(ToBeSigned)), err.Error())
+
+	_, err = SignDigest(rand.Reader, digest[:], nil)
+	assert.Equal(ErrNoSignerFound, err)
+}
+
 func TestI2OSPCorrectness(t *testing.T) {
 	assert := assert.New(t)
 
@@ -248,6 +470,22 @@ func TestI2OSPCorrectness(t *testing.T) {
 
 }
 
+func TestECDSASignatureBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	// ES512 integers are left padded to 66 bytes
+	sig, err := ecdsaSignatureBytes(big.NewInt(1), big.NewInt(256), 66)
+	assert.Nil(err)
+	assert.Equal(132, len(sig))
+	assert.Equal(append(append(make([]byte, 65), 1), append(make([]byte, 64), 1, 0)...), sig)
+
+	wide := new(big.Int).Lsh(big.NewInt(1), 66*8)
+	_, err = ecdsaSignatureBytes(wide, big.NewInt(1), 66)
+	assert.Equal("ECDSA integer is 67 bytes; expected at most 66", err.Error())
+	_, err = ecdsaSignatureBytes(big.NewInt(1), wide, 66)
+	assert.Equal("ECDSA integer is 67 bytes; expected at most 66", err.Error())
+}
+
 func TestI2OSPTiming(t *testing.T) {
 	assert := assert.New(t)
 
@@ -297,3 +535,137 @@ func TestApproxEqual(t *testing.T) {
 	assert.False(approxEqual(10, 5, 1))
 	assert.False(approxEqual(6, 5, 0))
 }
+
+func TestSign1ToBeSigned(t *testing.T) {
+	assert := assert.New(t)
+
+	// from https://tools.ietf.org/html/rfc8152#appendix-C.2.1
+	ToBeSigned, err := Sign1ToBeSigned(
+		HexToBytesOrDie("A10126"),
+		[]byte(""),
+		[]byte("This is the content."))
+	assert.Nil(err)
+	assert.Equal(
+		HexToBytesOrDie("846A5369676E61747572653143A101264054546869732069732074686520636F6E74656E742E"),
+		ToBeSigned)
+
+	// nil external data is a zero-length bstr
+	ToBeSigned, err = Sign1ToBeSigned(
+		HexToBytesOrDie("A10126"),
+		nil,
+		[]byte("This is the content."))
+	assert.Nil(err)
+	assert.Equal(
+		HexToBytesOrDie("846A5369676E61747572653143A101264054546869732069732074686520636F6E74656E742E"),
+		ToBeSigned)
+}
+
+func TestBuildAndMarshalSigStructureNilExternal(t *testing.T) {
+	assert := assert.New(t)
+
+	ToBeSigned, err := buildAndMarshalSigStructure(
+		HexToBytesOrDie("A0"),
+		HexToBytesOrDie("A10126"),
+		nil,
+		[]byte("payload"))
+	assert.Nil(err)
+	assert.Equal(
+		HexToBytesOrDie("85"+"695369676E6174757265"+"41A0"+"43A10126"+"40"+"477061796C6F6164"),
+		ToBeSigned)
+
+	withEmptyExternal, err := buildAndMarshalSigStructure(
+		HexToBytesOrDie("A0"),
+		HexToBytesOrDie("A10126"),
+		[]byte{},
+		[]byte("payload"))
+	assert.Nil(err)
+	assert.Equal(withEmptyExternal, ToBeSigned)
+}
+
+func TestSignatureByteLenForAlgID(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		alg        *Algorithm
+		sigByteLen int
+	}{
+		{ES256, 64},
+		{ES384, 96},
+		{ES512, 132}, // 521 bits rounds up to 66 bytes
+	} {
+		sigByteLen, err := SignatureByteLenForAlgID(tc.alg.Value)
+		assert.Nil(err)
+		assert.Equal(tc.sigByteLen, sigByteLen, tc.alg.Name)
+	}
+
+	_, err := SignatureByteLenForAlgID(PS256.Value)
+	assert.Equal("Could not find an elliptic curve for algorithm PS256", err.Error())
+
+	_, err = SignatureByteLenForAlgID(-9000)
+	assert.Equal("Algorithm with value -9000 not found", err.Error())
+}
+
+func TestSignatureByteLenForAlgIDCustomCurve(t *testing.T) {
+	assert := assert.New(t)
+	defer restoreAlgorithms()()
+
+	es224 := Algorithm{
+		Name:                 "ES224-TEST",
+		Value:                -65100,
+		HashFunc:             crypto.SHA256,
+		privateKeyType:       KeyTypeECDSA,
+		privateKeyECDSACurve: elliptic.P224(),
+	}
+
+	// RegisterAlgorithm drops the curve, so a registered algorithm
+	// cannot sign or verify with one
+	assert.Nil(RegisterAlgorithm(es224))
+	_, err := SignatureByteLenForAlgID(es224.Value)
+	assert.Equal("Could not find an elliptic curve for algorithm ES224-TEST", err.Error())
+
+	// an algorithm with a curve not in the table uses its field size
+	algorithmsMu.Lock()
+	algorithms[algorithmIndexByValue[es224.Value]] = es224
+	algorithmsMu.Unlock()
+	sigByteLen, err := SignatureByteLenForAlgID(es224.Value)
+	assert.Nil(err)
+	assert.Equal(56, sigByteLen)
+
+	alg := getAlgByNameOrPanic(es224.Name)
+	signer, err := NewSigner(alg, nil)
+	assert.Nil(err, "Error creating signer")
+	msg := signedToken(t, signer, "payload")
+	assert.Len(msg.Signatures[0].SignatureBytes, 56)
+	assert.Nil(msg.Verify(nil, []Verifier{*signer.Verifier()}))
+}
+
+func TestSignVerifyP521(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES512, nil)
+	assert.Nil(err, "Error creating ES512 signer")
+	verifier := signer.Verifier()
+
+	// r and s are frequently shorter than 66 bytes so sign enough
+	// times to exercise the left padding
+	for i := 0; i < 64; i++ {
+		hasher := ES512.HashFunc.New()
+		_, _ = hasher.Write([]byte(fmt.Sprintf("ahoy %d", i))) // Write() on hash never fails
+		digest := hasher.Sum(nil)
+
+		signatureBytes, err := signer.Sign(rand.Reader, digest)
+		assert.Nil(err)
+		assert.Equal(132, len(signatureBytes))
+		assert.Nil(verifier.Verify(digest, signatureBytes))
+	}
+}
+
+func TestSignerSignKeyCurveMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSignerFromKey(ES384, &ecdsaPrivateKey)
+	assert.Nil(err, "Error creating signer")
+
+	_, err = signer.Sign(rand.Reader, []byte("digest"))
+	assert.Equal("Expected 48 byte key, got 32 bytes instead", err.Error())
+}
@@ -0,0 +1,301 @@
+package cose
+
+import (
+	"crypto"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Countersignatures of a COSE_Signature are stored in its unprotected
+// "counter signature v2" header as a COSE_Countersignature or an array
+// of them with CDDL fragment:
+//
+// COSE_Countersignature = [
+//        Headers,
+//        signature : bstr
+// ]
+//
+// Each countersignature signs the signature bytes of the
+// COSE_Signature it is attached to and has its own alg and kid
+// headers, so witnesses using different algorithms can countersign
+// the same signature.
+//
+// https://tools.ietf.org/html/rfc9338#section-3.1
+
+// CounterSignatureResult is the result of verifying one
+// countersignature. Err is nil when it verified.
+type CounterSignatureResult struct {
+	CounterSignature Signature
+	Err              error
+}
+
+// VerifierLookup returns the Verifier for a kid header value or an
+// error when there is no Verifier for it. kid is nil when the
+// countersignature does not have a kid header. See MatchCertBySKI for
+// kids that are certificate Subject Key Identifiers.
+type VerifierLookup func(kid []byte) (verifier *Verifier, err error)
+
+// counterSignatureTarget returns the signature at index to
+// countersign or verify countersignatures on
+func (m *SignMessage) counterSignatureTarget(index int) (target *Signature, err error) {
+	if m == nil || index < 0 || index >= len(m.Signatures) {
+		return nil, errors.Errorf("SignMessage has no signature %d", index)
+	}
+	target = &m.Signatures[index]
+	if target.Headers == nil {
+		return nil, ErrNilSigHeader
+	} else if len(target.SignatureBytes) < 1 {
+		return nil, errors.Errorf("SignMessage signature %d missing signature bytes to countersign", index)
+	}
+	if m.Payload == nil {
+		return nil, ErrMissingPayload
+	}
+	return target, nil
+}
+
+// counterSignatureDigest returns the digest of the
+// Countersign_structure for counterSignature over target
+func (m *SignMessage) counterSignatureDigest(external []byte, target, counterSignature *Signature, hashFunc crypto.Hash) (digest []byte, err error) {
+	ToBeSigned, err := buildAndMarshalCounterSigStructure(
+		target.Headers.EncodeProtected(),
+		counterSignature.Headers.EncodeProtected(),
+		external,
+		m.Payload,
+		target.SignatureBytes)
+	if err != nil {
+		return nil, err
+	}
+	return hashSigStructure(ToBeSigned, hashFunc)
+}
+
+// CounterSignatures returns the countersignatures of the message
+// signature at index
+func (m *SignMessage) CounterSignatures(index int) (counterSignatures []Signature, err error) {
+	if m == nil || index < 0 || index >= len(m.Signatures) {
+		return nil, errors.Errorf("SignMessage has no signature %d", index)
+	}
+	value, ok := getCommonHeader(m.Signatures[index].Headers, "counter signature v2")
+	if !ok {
+		return nil, nil
+	}
+
+	array, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("error decoding countersignatures as array; got %T", value)
+	}
+	// a single COSE_Countersignature starts with its protected bstr
+	if len(array) > 0 {
+		if _, ok := array[0].([]byte); ok {
+			array = []interface{}{array}
+		}
+	}
+
+	for i, o := range array {
+		counterSignature, err := decodeCounterSignature(o)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decoding countersignature %d", i)
+		}
+		counterSignatures = append(counterSignatures, *counterSignature)
+	}
+	return counterSignatures, nil
+}
+
+// decodeCounterSignature returns the countersignature for a decoded
+// COSE_Countersignature array
+func decodeCounterSignature(o interface{}) (counterSignature *Signature, err error) {
+	array, ok := o.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("error decoding countersignature array; got %T", o)
+	}
+	if len(array) != 3 {
+		return nil, errors.Errorf("can only decode countersignature with 3 items; got %d", len(array))
+	}
+
+	counterSignature = NewSignature()
+	err = counterSignature.Headers.Decode(array[0:2])
+	if err != nil {
+		return nil, err
+	}
+	if protected, ok := array[0].([]byte); ok {
+		keepNonCanonicalProtected(counterSignature.Headers, protected)
+	}
+
+	signatureBytes, ok := array[2].([]byte)
+	if !ok {
+		return nil, errors.Errorf("error decoding countersignature bytes; got %T", array[2])
+	}
+	counterSignature.SignatureBytes = signatureBytes
+	return counterSignature, nil
+}
+
+// CounterSign signs the signature bytes of the message signature at
+// index with signer and adds counterSignature to its countersignature
+// header
+//
+// counterSignature must have a protected alg header matching the
+// signer. The message signature must already be signed.
+func (m *SignMessage) CounterSign(rand io.Reader, index int, external []byte, counterSignature *Signature, signer Signer) (err error) {
+	target, err := m.counterSignatureTarget(index)
+	if err != nil {
+		return err
+	}
+	if counterSignature.Headers == nil {
+		return ErrNilSigHeader
+	} else if counterSignature.Headers.Protected == nil {
+		return ErrNilSigProtectedHeaders
+	} else if len(counterSignature.SignatureBytes) > 0 {
+		return errors.Errorf("countersignature already has signature bytes")
+	}
+
+	alg, err := getAlg(counterSignature.Headers)
+	if err != nil {
+		return err
+	}
+	if alg.Value > -1 { // Negative numbers are used for second layer objects (COSE_Signature and COSE_recipient)
+		return ErrInvalidAlg
+	}
+	if alg.Value != signer.alg.Value {
+		return errors.Errorf("Signer of type %s cannot generate a signature of type %s", signer.alg.Name, alg.Name)
+	}
+
+	counterSignatures, err := m.CounterSignatures(index)
+	if err != nil {
+		return err
+	}
+
+	digest, err := m.counterSignatureDigest(external, target, counterSignature, alg.HashFunc)
+	if err != nil {
+		return err
+	}
+	signatureBytes, err := signer.Sign(rand, digest)
+	if err != nil {
+		return err
+	}
+	counterSignature.SignatureBytes = signatureBytes
+
+	counterSignatures = append(counterSignatures, *counterSignature)
+	encoded := []interface{}{}
+	for _, s := range counterSignatures {
+		encoded = append(encoded, []interface{}{
+			s.Headers.EncodeProtected(),
+			s.Headers.EncodeUnprotected(),
+			s.SignatureBytes,
+		})
+	}
+	if target.Headers.Unprotected == nil {
+		target.Headers.Unprotected = map[interface{}]interface{}{}
+	}
+	delete(target.Headers.Unprotected, "counter signature v2")
+	target.Headers.Unprotected[CommonHeaderIDCounterSignatureV2] = encoded
+	return nil
+}
+
+// AddCounterSignatures countersigns the message signature at index
+// once with each signer e.g. for a notary collecting attestations
+//
+// Each countersignature has protected alg and kid headers with the
+// signer alg and the KeyThumbprint of its public key. No
+// countersignatures are added when one of them fails.
+func (m *SignMessage) AddCounterSignatures(rand io.Reader, index int, external []byte, signers []Signer) (err error) {
+	target, err := m.counterSignatureTarget(index)
+	if err != nil {
+		return err
+	}
+	if len(signers) < 1 {
+		return ErrNoSignerFound
+	}
+
+	// restore the countersignatures on failure
+	unprotected := map[interface{}]interface{}{}
+	for k, v := range target.Headers.Unprotected {
+		unprotected[k] = v
+	}
+	defer func() {
+		if err != nil {
+			m.Signatures[index].Headers.Unprotected = unprotected
+		}
+	}()
+
+	for i, signer := range signers {
+		if signer.alg == nil {
+			return errors.Errorf("Signer %d has no algorithm", i)
+		}
+		kid, err := KeyThumbprint(signer.Public())
+		if err != nil {
+			return errors.Wrapf(err, "error computing kid for signer %d", i)
+		}
+		counterSignature := NewSignature()
+		counterSignature.Headers.Protected[CommonHeaderIDAlg] = signer.alg.Value
+		counterSignature.Headers.Protected[CommonHeaderIDKeyID] = kid
+
+		err = m.CounterSign(rand, index, external, counterSignature, signer)
+		if err != nil {
+			return errors.Wrapf(err, "error countersigning with signer %d", i)
+		}
+	}
+	return nil
+}
+
+// VerifyCounterSignatures verifies each countersignature of the
+// message signature at index with the Verifier lookup returns for its
+// kid and returns a result per countersignature
+//
+// Each countersignature is verified with the alg from its own
+// headers. err is only set when the countersignatures cannot be
+// read.
+func (m *SignMessage) VerifyCounterSignatures(index int, external []byte, lookup VerifierLookup) (results []CounterSignatureResult, err error) {
+	target, err := m.counterSignatureTarget(index)
+	if err != nil {
+		return nil, err
+	}
+	counterSignatures, err := m.CounterSignatures(index)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, counterSignature := range counterSignatures {
+		results = append(results, CounterSignatureResult{
+			CounterSignature: counterSignature,
+			Err:              m.verifyCounterSignature(external, target, &counterSignature, lookup),
+		})
+	}
+	return results, nil
+}
+
+func (m *SignMessage) verifyCounterSignature(external []byte, target, counterSignature *Signature, lookup VerifierLookup) (err error) {
+	alg, err := getAlg(counterSignature.Headers)
+	if err != nil {
+		return err
+	}
+	if alg.Value > -1 { // Negative numbers are used for second layer objects (COSE_Signature and COSE_recipient)
+		return ErrInvalidAlg
+	}
+
+	var kid []byte
+	if value, ok := getCommonHeader(counterSignature.Headers, "kid"); ok {
+		kid, ok = value.([]byte)
+		if !ok {
+			return errors.Errorf("error casting kid to bstr; got %T", value)
+		}
+	}
+	verifier, err := lookup(kid)
+	if err != nil {
+		return err
+	}
+	if verifier == nil {
+		return ErrNoVerifierFound
+	}
+	if verifier.Alg == nil {
+		return errors.Errorf("Verifier for kid %x has no Alg", kid)
+	}
+	if alg.Value != verifier.Alg.Value {
+		return errors.Errorf("Verifier of type %s cannot verify a signature of type %s", verifier.Alg.Name, alg.Name)
+	}
+
+	digest, err := m.counterSignatureDigest(external, target, counterSignature, alg.HashFunc)
+	if err != nil {
+		return err
+	}
+	return verifier.Verify(digest, counterSignature.SignatureBytes)
+}
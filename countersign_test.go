@@ -0,0 +1,258 @@
+package cose
+
+import (
+	"crypto/rand"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// signedTestMessage returns a SignMessage with one ES256 signature
+// and its signer
+func signedTestMessage(t *testing.T) (*SignMessage, *Signer) {
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(t, err, "Error creating signer")
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload to countersign")
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+	assert.Nil(t, msg.Sign(rand.Reader, nil, []Signer{*signer}))
+	return msg, signer
+}
+
+// newCounterSignature returns an unsigned countersignature with alg
+// and kid protected headers
+func newCounterSignature(alg *Algorithm, kid string) *Signature {
+	counterSignature := NewSignature()
+	counterSignature.Headers.Protected[CommonHeaderIDAlg] = alg.Value
+	counterSignature.Headers.Protected[CommonHeaderIDKeyID] = []byte(kid)
+	return counterSignature
+}
+
+func TestCounterSignMixedAlgorithms(t *testing.T) {
+	assert := assert.New(t)
+
+	msg, msgSigner := signedTestMessage(t)
+
+	witnesses := map[string]*Signer{}
+	for kid, alg := range map[string]*Algorithm{
+		"witness-es384": ES384,
+		"witness-es512": ES512,
+		"witness-ps256": PS256,
+	} {
+		signer, err := NewSigner(alg, nil)
+		assert.Nil(err, "Error creating signer")
+		witnesses[kid] = signer
+	}
+	lookup := func(kid []byte) (*Verifier, error) {
+		signer, ok := witnesses[string(kid)]
+		if !ok {
+			return nil, errors.Errorf("no verifier for kid %s", kid)
+		}
+		return signer.Verifier(), nil
+	}
+
+	// countersignatures accumulate over time from witnesses with
+	// different algorithms
+	for _, kid := range []string{"witness-es384", "witness-ps256", "witness-es512"} {
+		signer := witnesses[kid]
+		err := msg.CounterSign(rand.Reader, 0, nil, newCounterSignature(signer.alg, kid), *signer)
+		assert.Nil(err, kid)
+	}
+
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+	decoded, err := Unmarshal(msgBytes)
+	assert.Nil(err)
+	decodedMsg := decoded.(SignMessage)
+
+	// countersignatures do not change the message signature
+	assert.Nil(decodedMsg.Verify(nil, []Verifier{*msgSigner.Verifier()}))
+
+	results, err := decodedMsg.VerifyCounterSignatures(0, nil, lookup)
+	assert.Nil(err)
+	assert.Len(results, 3)
+	for i, kid := range []string{"witness-es384", "witness-ps256", "witness-es512"} {
+		assert.Nil(results[i].Err, kid)
+		assert.Equal([]byte(kid), results[i].CounterSignature.Headers.Protected[CommonHeaderIDKeyID])
+	}
+
+	// results are per countersignature
+	delete(witnesses, "witness-ps256")
+	results, err = decodedMsg.VerifyCounterSignatures(0, nil, lookup)
+	assert.Nil(err)
+	assert.Nil(results[0].Err)
+	assert.Equal("no verifier for kid witness-ps256", results[1].Err.Error())
+	assert.Nil(results[2].Err)
+
+	// a verifier for another algorithm is rejected
+	results, err = decodedMsg.VerifyCounterSignatures(0, nil, func(kid []byte) (*Verifier, error) {
+		return witnesses["witness-es384"].Verifier(), nil
+	})
+	assert.Nil(err)
+	assert.Nil(results[0].Err)
+	assert.Equal("Verifier of type ES384 cannot verify a signature of type ES512", results[2].Err.Error())
+	results, err = decodedMsg.VerifyCounterSignatures(0, nil, func(kid []byte) (*Verifier, error) {
+		return &Verifier{PublicKey: witnesses["witness-es384"].Public()}, nil
+	})
+	assert.Nil(err)
+	assert.Equal("Verifier for kid 7769746e6573732d6573333834 has no Alg", results[0].Err.Error())
+
+	// external data is included in the countersignature
+	results, err = decodedMsg.VerifyCounterSignatures(0, []byte("external"), lookup)
+	assert.Nil(err)
+	assert.Equal(ErrECDSAVerification, results[0].Err)
+}
+
+func TestAddCounterSignatures(t *testing.T) {
+	assert := assert.New(t)
+
+	msg, _ := signedTestMessage(t)
+
+	var signers []Signer
+	witnesses := map[string]*Signer{}
+	for _, alg := range []*Algorithm{ES256, ES384, PS256} {
+		signer, err := NewSigner(alg, nil)
+		assert.Nil(err, "Error creating signer")
+		signers = append(signers, *signer)
+		kid, err := KeyThumbprint(signer.Public())
+		assert.Nil(err)
+		witnesses[string(kid)] = signer
+	}
+	lookup := func(kid []byte) (*Verifier, error) {
+		signer, ok := witnesses[string(kid)]
+		if !ok {
+			return nil, errors.Errorf("no verifier for kid %x", kid)
+		}
+		return signer.Verifier(), nil
+	}
+
+	assert.Nil(msg.AddCounterSignatures(rand.Reader, 0, nil, signers))
+	counterSignatures, err := msg.CounterSignatures(0)
+	assert.Nil(err)
+	assert.Len(counterSignatures, 3)
+	for i, counterSignature := range counterSignatures {
+		alg, err := getAlg(counterSignature.Headers)
+		assert.Nil(err)
+		assert.Equal(signers[i].alg.Value, alg.Value)
+	}
+
+	results, err := msg.VerifyCounterSignatures(0, nil, lookup)
+	assert.Nil(err)
+	for _, result := range results {
+		assert.Nil(result.Err)
+	}
+
+	// no countersignatures are added when a signer fails
+	err = msg.AddCounterSignatures(rand.Reader, 0, nil, []Signer{signers[0], {}})
+	assert.Equal("Signer 1 has no algorithm", err.Error())
+	counterSignatures, err = msg.CounterSignatures(0)
+	assert.Nil(err)
+	assert.Len(counterSignatures, 3)
+
+	assert.Equal(ErrNoSignerFound, msg.AddCounterSignatures(rand.Reader, 0, nil, nil))
+	assert.Equal("SignMessage has no signature 1", msg.AddCounterSignatures(rand.Reader, 1, nil, signers).Error())
+}
+
+func TestCounterSignaturesSingleForm(t *testing.T) {
+	assert := assert.New(t)
+
+	msg, _ := signedTestMessage(t)
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	assert.Nil(msg.CounterSign(rand.Reader, 0, nil, newCounterSignature(ES256, "witness"), *signer))
+
+	// a single COSE_Countersignature instead of an array of them
+	headers := msg.Signatures[0].Headers.Unprotected
+	headers[CommonHeaderIDCounterSignatureV2] = headers[CommonHeaderIDCounterSignatureV2].([]interface{})[0]
+
+	counterSignatures, err := msg.CounterSignatures(0)
+	assert.Nil(err)
+	assert.Len(counterSignatures, 1)
+
+	results, err := msg.VerifyCounterSignatures(0, nil, func(kid []byte) (*Verifier, error) {
+		assert.Equal([]byte("witness"), kid)
+		return signer.Verifier(), nil
+	})
+	assert.Nil(err)
+	assert.Len(results, 1)
+	assert.Nil(results[0].Err)
+}
+
+func TestCounterSignErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+
+	msg, _ := signedTestMessage(t)
+	assert.Equal("SignMessage has no signature 1", msg.CounterSign(rand.Reader, 1, nil, newCounterSignature(ES256, "witness"), *signer).Error())
+	assert.Equal("Signer of type ES256 cannot generate a signature of type ES384", msg.CounterSign(rand.Reader, 0, nil, newCounterSignature(ES384, "witness"), *signer).Error())
+	assert.Equal(ErrAlgNotFound, msg.CounterSign(rand.Reader, 0, nil, NewSignature(), *signer))
+
+	unsigned := NewSignMessage()
+	unsigned.Payload = []byte("payload")
+	unsigned.AddSignature(NewSignature())
+	assert.Equal("SignMessage signature 0 missing signature bytes to countersign", unsigned.CounterSign(rand.Reader, 0, nil, newCounterSignature(ES256, "witness"), *signer).Error())
+
+	counterSignatures, err := msg.CounterSignatures(0)
+	assert.Nil(err)
+	assert.Nil(counterSignatures)
+
+	msg.Signatures[0].Headers.Unprotected[CommonHeaderIDCounterSignatureV2] = "not an array"
+	_, err = msg.CounterSignatures(0)
+	assert.Equal("error decoding countersignatures as array; got string", err.Error())
+	_, err = msg.VerifyCounterSignatures(0, nil, nil)
+	assert.NotNil(err)
+
+	msg.Signatures[0].Headers.Unprotected[CommonHeaderIDCounterSignatureV2] = []interface{}{[]interface{}{[]byte{}, map[interface{}]interface{}{}}}
+	_, err = msg.CounterSignatures(0)
+	assert.Equal("error decoding countersignature 0: can only decode countersignature with 3 items; got 2", err.Error())
+
+	msg.Payload = nil
+	_, err = msg.VerifyCounterSignatures(0, nil, nil)
+	assert.Equal(ErrMissingPayload, err)
+}
+
+func TestCounterSignatureSignsTargetSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	ToBeSigned, err := buildAndMarshalCounterSigStructure(
+		HexToBytesOrDie("A10126"),
+		HexToBytesOrDie("A10126"),
+		nil,
+		[]byte("payload"),
+		[]byte("signature"))
+	assert.Nil(err)
+	assert.Equal(
+		HexToBytesOrDie("86"+"70436F756E7465725369676E6174757265"+"43A10126"+"43A10126"+"40"+"477061796C6F6164"+"81"+"497369676E6174757265"),
+		ToBeSigned)
+
+	msg, _ := signedTestMessage(t)
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	assert.Nil(msg.CounterSign(rand.Reader, 0, nil, newCounterSignature(ES256, "witness"), *signer))
+	lookup := func(kid []byte) (*Verifier, error) {
+		return signer.Verifier(), nil
+	}
+
+	results, err := msg.VerifyCounterSignatures(0, nil, lookup)
+	assert.Nil(err)
+	assert.Nil(results[0].Err)
+
+	// tampering with the countersigned signature invalidates the
+	// countersignature
+	signatureBytes := msg.Signatures[0].SignatureBytes
+	msg.Signatures[0].SignatureBytes = append([]byte{}, signatureBytes...)
+	msg.Signatures[0].SignatureBytes[0] ^= 0xff
+	results, err = msg.VerifyCounterSignatures(0, nil, lookup)
+	assert.Nil(err)
+	assert.Equal(ErrECDSAVerification, results[0].Err)
+
+	msg.Signatures[0].SignatureBytes = signatureBytes
+	results, err = msg.VerifyCounterSignatures(0, nil, lookup)
+	assert.Nil(err)
+	assert.Nil(results[0].Err)
+}
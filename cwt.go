@@ -0,0 +1,93 @@
+package cose
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CWT claim keys for the time claims of a CBOR Web Token claims set
+//
+// https://tools.ietf.org/html/rfc8392#section-3.1
+const (
+	cwtClaimExp = 4
+	cwtClaimNbf = 5
+)
+
+// VerifyCWTOpts are options for checking the time claims of a CBOR
+// Web Token
+type VerifyCWTOpts struct {
+	// Now returns the current time and defaults to time.Now. Set it
+	// to a fixed clock in tests.
+	Now func() time.Time
+
+	// Leeway is the clock skew allowed when comparing Now to the
+	// exp and nbf claims e.g. 60 * time.Second
+	Leeway time.Duration
+}
+
+// VerifyCWTTimeClaims decodes the SignMessage Payload as a CWT claims
+// set and checks its exp (expiration time) and nbf (not before)
+// claims against opts.Now
+//
+// It returns ErrTokenExpired on or after exp and ErrTokenNotYetValid
+// before nbf, adjusted by opts.Leeway. Missing claims are not checked.
+// The claims are not authenticated until Verify returns nil, so call
+// it after verifying the message.
+func (m *SignMessage) VerifyCWTTimeClaims(opts VerifyCWTOpts) (err error) {
+	var claims map[interface{}]interface{}
+	err = m.DecodePayload(&claims)
+	if err != nil {
+		return errors.Wrap(err, "error decoding CWT claims")
+	}
+
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+	t := now()
+
+	exp, ok, err := cwtNumericDate(claims, cwtClaimExp)
+	if err != nil {
+		return err
+	}
+	if ok && !t.Before(exp.Add(opts.Leeway)) {
+		return ErrTokenExpired
+	}
+
+	nbf, ok, err := cwtNumericDate(claims, cwtClaimNbf)
+	if err != nil {
+		return err
+	}
+	if ok && t.Before(nbf.Add(-opts.Leeway)) {
+		return ErrTokenNotYetValid
+	}
+	return nil
+}
+
+// cwtNumericDate returns the NumericDate claim for key as a time.Time
+// and whether the claim is present
+func cwtNumericDate(claims map[interface{}]interface{}, key int) (date time.Time, ok bool, err error) {
+	for k, v := range claims {
+		if i, isInt := intFromInteger(k); !isInt || i != key {
+			continue
+		}
+		if i, isInt := intFromInteger(v); isInt {
+			return time.Unix(int64(i), 0), true, nil
+		}
+		switch f := v.(type) {
+		case float32:
+			return cwtFloatDate(float64(f)), true, nil
+		case float64:
+			return cwtFloatDate(f), true, nil
+		}
+		return time.Time{}, false, errors.Errorf("error decoding CWT claim %d as NumericDate; got %T", key, v)
+	}
+	return time.Time{}, false, nil
+}
+
+func cwtFloatDate(f float64) time.Time {
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
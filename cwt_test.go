@@ -0,0 +1,64 @@
+package cose
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestVerifyCWTTimeClaims(t *testing.T) {
+	assert := assert.New(t)
+
+	issued := time.Unix(1444064944, 0)
+	payload, err := Marshal(map[interface{}]interface{}{
+		1: "coap://as.example.com",
+		4: issued.Add(time.Hour).Unix(), // exp
+		5: issued.Unix(),                // nbf
+	})
+	assert.Nil(err)
+	msg := NewSignMessage()
+	msg.Payload = payload
+
+	at := func(t time.Time) func() time.Time {
+		return func() time.Time { return t }
+	}
+
+	var tests = []struct {
+		now    time.Time
+		leeway time.Duration
+		err    error
+	}{
+		{issued, 0, nil},
+		{issued.Add(30 * time.Minute), 0, nil},
+		{issued.Add(time.Hour - time.Second), 0, nil},
+		{issued.Add(time.Hour), 0, ErrTokenExpired},
+		{issued.Add(time.Hour), time.Minute, nil},
+		{issued.Add(time.Hour + time.Minute), time.Minute, ErrTokenExpired},
+		{issued.Add(-time.Second), 0, ErrTokenNotYetValid},
+		{issued.Add(-time.Second), time.Minute, nil},
+		{issued.Add(-2 * time.Minute), time.Minute, ErrTokenNotYetValid},
+	}
+	for _, test := range tests {
+		err = msg.VerifyCWTTimeClaims(VerifyCWTOpts{Now: at(test.now), Leeway: test.leeway})
+		assert.Equal(test.err, err, test.now.String())
+	}
+
+	// without time claims
+	msg.Payload, err = Marshal(map[interface{}]interface{}{1: "coap://as.example.com"})
+	assert.Nil(err)
+	assert.Nil(msg.VerifyCWTTimeClaims(VerifyCWTOpts{}))
+
+	// float NumericDates and the default clock
+	msg.Payload, err = Marshal(map[interface{}]interface{}{4: 1444064944.5})
+	assert.Nil(err)
+	assert.Equal(ErrTokenExpired, msg.VerifyCWTTimeClaims(VerifyCWTOpts{}))
+	assert.Nil(msg.VerifyCWTTimeClaims(VerifyCWTOpts{Now: at(time.Unix(1444064944, 0))}))
+
+	msg.Payload, err = Marshal(map[interface{}]interface{}{4: "tomorrow"})
+	assert.Nil(err)
+	err = msg.VerifyCWTTimeClaims(VerifyCWTOpts{})
+	assert.Equal("error decoding CWT claim 4 as NumericDate; got string", err.Error())
+
+	msg.Payload = []byte("not CBOR")
+	assert.NotNil(msg.VerifyCWTTimeClaims(VerifyCWTOpts{}))
+}
@@ -0,0 +1,218 @@
+package cose
+
+import (
+	"encoding/hex"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxDiagnoseDepth is the maximum nesting of arrays, maps, and tags
+// Diagnose formats
+const maxDiagnoseDepth = 64
+
+// Diagnose returns the CBOR diagnostic notation of data e.g.
+//
+//	98([h'a10126', {}, h'7061796c6f6164', [...]])
+//
+// for comparing messages with other implementations. Items are
+// formatted in the order they are encoded without decoding them to Go
+// values, so non-canonical encodings show up as they are. Indefinite
+// length items are not supported.
+//
+// https://tools.ietf.org/html/rfc8949#section-8
+func Diagnose(data []byte) (notation string, err error) {
+	d := &diagnoser{data: data}
+	err = d.item(0)
+	if err != nil {
+		return "", err
+	}
+	if d.offset != len(data) {
+		return "", ErrTrailingData
+	}
+	return d.out.String(), nil
+}
+
+type diagnoser struct {
+	data   []byte
+	offset int
+	out    strings.Builder
+}
+
+// head reads the major type, additional information, and argument of
+// the next CBOR item
+func (d *diagnoser) head() (majorType, ai byte, arg uint64, err error) {
+	if d.offset >= len(d.data) {
+		return 0, 0, 0, errors.New("unexpected end of CBOR data")
+	}
+	b := d.data[d.offset]
+	d.offset++
+	majorType, ai = b>>5, b&0x1f
+
+	var size int
+	switch {
+	case ai < 24:
+		return majorType, ai, uint64(ai), nil
+	case ai == 24:
+		size = 1
+	case ai == 25:
+		size = 2
+	case ai == 26:
+		size = 4
+	case ai == 27:
+		size = 8
+	case ai == 31:
+		return 0, 0, 0, errors.New("indefinite length CBOR items are not supported")
+	default:
+		return 0, 0, 0, errors.Errorf("invalid CBOR additional information %d", ai)
+	}
+	b8, err := d.read(uint64(size))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, c := range b8 {
+		arg = arg<<8 | uint64(c)
+	}
+	return majorType, ai, arg, nil
+}
+
+func (d *diagnoser) read(n uint64) (b []byte, err error) {
+	if n > uint64(len(d.data)-d.offset) {
+		return nil, errors.New("unexpected end of CBOR data")
+	}
+	b = d.data[d.offset : d.offset+int(n)]
+	d.offset += int(n)
+	return b, nil
+}
+
+func (d *diagnoser) item(depth int) (err error) {
+	if depth > maxDiagnoseDepth {
+		return errors.Errorf("CBOR items nested more than %d deep", maxDiagnoseDepth)
+	}
+	majorType, ai, arg, err := d.head()
+	if err != nil {
+		return err
+	}
+
+	switch majorType {
+	case 0:
+		d.out.WriteString(strconv.FormatUint(arg, 10))
+	case 1:
+		n := new(big.Int).SetUint64(arg)
+		d.out.WriteString(n.Neg(n).Sub(n, big.NewInt(1)).String())
+	case 2:
+		b, err := d.read(arg)
+		if err != nil {
+			return err
+		}
+		d.out.WriteString("h'" + hex.EncodeToString(b) + "'")
+	case 3:
+		b, err := d.read(arg)
+		if err != nil {
+			return err
+		}
+		d.out.WriteString(strconv.Quote(string(b)))
+	case 4:
+		d.out.WriteString("[")
+		for i := uint64(0); i < arg; i++ {
+			if i > 0 {
+				d.out.WriteString(", ")
+			}
+			err = d.item(depth + 1)
+			if err != nil {
+				return err
+			}
+		}
+		d.out.WriteString("]")
+	case 5:
+		d.out.WriteString("{")
+		for i := uint64(0); i < arg; i++ {
+			if i > 0 {
+				d.out.WriteString(", ")
+			}
+			err = d.item(depth + 1)
+			if err != nil {
+				return err
+			}
+			d.out.WriteString(": ")
+			err = d.item(depth + 1)
+			if err != nil {
+				return err
+			}
+		}
+		d.out.WriteString("}")
+	case 6:
+		d.out.WriteString(strconv.FormatUint(arg, 10) + "(")
+		err = d.item(depth + 1)
+		if err != nil {
+			return err
+		}
+		d.out.WriteString(")")
+	case 7:
+		d.out.WriteString(diagnoseSimple(ai, arg))
+	}
+	return nil
+}
+
+// diagnoseSimple formats a CBOR simple value or float
+func diagnoseSimple(ai byte, arg uint64) string {
+	var f float64
+	switch ai {
+	case 20:
+		return "false"
+	case 21:
+		return "true"
+	case 22:
+		return "null"
+	case 23:
+		return "undefined"
+	case 25:
+		f = float16ToFloat64(uint16(arg))
+	case 26:
+		f = float64(math.Float32frombits(uint32(arg)))
+	case 27:
+		f = math.Float64frombits(arg)
+	default:
+		return "simple(" + strconv.FormatUint(arg, 10) + ")"
+	}
+
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eN") {
+		s += ".0"
+	}
+	return s
+}
+
+// float16ToFloat64 converts IEEE 754 half precision bits to a float64
+func float16ToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
@@ -0,0 +1,84 @@
+package cose
+
+import (
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, testCase := range []struct {
+		hex      string
+		expected string
+	}{
+		{"00", "0"},
+		{"1BFFFFFFFFFFFFFFFF", "18446744073709551615"},
+		{"26", "-7"},
+		{"3BFFFFFFFFFFFFFFFF", "-18446744073709551616"},
+		{"43A10126", "h'a10126'"},
+		{"40", "h''"},
+		{"6548656C6C6F", `"Hello"`},
+		{"62225C", `"\"\\"`},
+		{"8301820203820405", "[1, [2, 3], [4, 5]]"},
+		{"A2016161200C", `{1: "a", -1: 12}`},
+		// maps are formatted in encoded order
+		{"A2616101200C", `{"a": 1, -1: 12}`},
+		{"C11A514B67B0", "1(1363896240)"},
+		{"84F4F5F6F7", "[false, true, null, undefined]"},
+		{"F0", "simple(16)"},
+		{"F8FF", "simple(255)"},
+		{"F93C00", "1.0"},
+		{"F9C400", "-4.0"},
+		{"F90001", "5.960464477539063e-08"},
+		{"F97C00", "Infinity"},
+		{"F97E00", "NaN"},
+		{"FA47C35000", "100000.0"},
+		{"FB3FF199999999999A", "1.1"},
+		{"FBFFF0000000000000", "-Infinity"},
+	} {
+		notation, err := Diagnose(HexToBytesOrDie(testCase.hex))
+		assert.Nil(err, testCase.hex)
+		assert.Equal(testCase.expected, notation, testCase.hex)
+	}
+}
+
+func TestDiagnoseSignMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	msg, err := SignMulti(rand.Reader, []byte("payload"), nil, []Signer{*signer})
+	assert.Nil(err)
+	data, err := Marshal(msg)
+	assert.Nil(err)
+
+	notation, err := Diagnose(data)
+	assert.Nil(err)
+	assert.True(strings.HasPrefix(notation, "98([h'', {}, h'7061796c6f6164', [[h'a10126', {}, h'"), notation)
+	assert.True(strings.HasSuffix(notation, "']]])"), notation)
+}
+
+func TestDiagnoseErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, testCase := range []struct {
+		hex      string
+		expected string
+	}{
+		{"", "unexpected end of CBOR data"},
+		{"82", "unexpected end of CBOR data"},
+		{"1A0000", "unexpected end of CBOR data"},
+		{"43A101", "unexpected end of CBOR data"},
+		{"5BFFFFFFFFFFFFFFFF", "unexpected end of CBOR data"},
+		{"9F01FF", "indefinite length CBOR items are not supported"},
+		{"1C", "invalid CBOR additional information 28"},
+		{"0101", ErrTrailingData.Error()},
+		{strings.Repeat("81", 66) + "00", "CBOR items nested more than 64 deep"},
+	} {
+		_, err := Diagnose(HexToBytesOrDie(testCase.hex))
+		assert.Equal(testCase.expected, err.Error(), testCase.hex)
+	}
+}
@@ -7,17 +7,41 @@ import (
 var (
 	ErrInvalidAlg             = errors.New("Invalid algorithm")
 	ErrAlgNotFound            = errors.New("Error fetching alg")
+	ErrInvalidAlgEncoding     = errors.New("alg is not encoded as an int or tstr")
+	ErrCertThumbprintMismatch = errors.New("x5t thumbprint does not match the certificate")
+	ErrCriticalHeaderMissing  = errors.New("crit header lists a label that is not in the protected headers")
+	ErrContentTypeNotFound    = errors.New("Error fetching content type")
 	ErrECDSAVerification      = errors.New("verification failed ecdsa.Verify")
+	ErrPayloadMismatch        = errors.New("SignMessage.payload does not match the expected payload")
+	ErrPayloadNotDetached     = errors.New("SignMessage.payload is not detached")
 	ErrRSAPSSVerification     = errors.New("verification failed rsa.VerifyPSS err crypto/rsa: verification error")
+	ErrKeyIDNotFound          = errors.New("Error fetching kid")
+	ErrMaxDepthExceeded       = errors.New("Header nesting exceeds MaxHeaderDepth")
+	ErrMissingPayload         = errors.New("SignMessage.payload is nil. Set the detached payload before verifying")
 	ErrMissingCOSETagForLabel = errors.New("No common COSE tag for label")
 	ErrMissingCOSETagForTag   = errors.New("No common COSE label for tag")
 	ErrNilSigHeader           = errors.New("Signature.headers is nil")
 	ErrNilSigProtectedHeaders = errors.New("Signature.headers.protected is nil")
 	ErrNilSignatures          = errors.New("SignMessage.signatures is nil. Use AddSignature to add one")
+	ErrNoSignatureBytes       = errors.New("SignMessage signature has no signature bytes. Sign the message before marshaling")
+	ErrNoSignatureVerified    = errors.New("No signature verified")
 	ErrNoSignatures           = errors.New("No signatures to sign the message. Use AddSignature to add them")
 	ErrNoSignerFound          = errors.New("No signer found")
 	ErrNoVerifierFound        = errors.New("No verifier found")
+	ErrNonCanonicalEncoding   = errors.New("CBOR encoding is not canonical")
+	ErrRawProtectedMismatch   = errors.New("Headers.RawProtected does not decode to Headers.Protected")
+	ErrTokenExpired           = errors.New("CWT is expired")
+	ErrTokenNotYetValid       = errors.New("CWT is not valid yet")
+	ErrTooManyHeaders         = errors.New("Too many headers in header map")
+	ErrTooManySignatures      = errors.New("Too many signatures in SignMessage")
+	ErrTrailingData           = errors.New("Unexpected data after the COSE message")
 	ErrUnavailableHashFunc    = errors.New("hash function is not available")
 	ErrUnknownPrivateKeyType  = errors.New("Unrecognized private key type")
 	ErrUnknownPublicKeyType   = errors.New("Unrecognized public key type")
+	ErrUnsupportedAlg         = errors.New("Algorithm is not supported")
+	ErrWrongMessageType       = errors.New("COSE message type does not match the decoder")
+	ErrX5ChainNotFound        = errors.New("Error fetching x5chain")
+	ErrX5TNotFound            = errors.New("Error fetching x5t")
+	ErrX5UNotFound            = errors.New("Error fetching x5u")
+	ErrX5UNotProtected        = errors.New("x5u header is not protected")
 )
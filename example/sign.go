@@ -16,7 +16,7 @@ func main() {
 	// create a signature
 	sig := cose.NewSignature()
 	sig.Headers.Unprotected["kid"] = 1
-	sig.Headers.Protected["alg"] = "ES256"
+	sig.Headers.Protected[cose.CommonHeaderIDAlg] = cose.ES256.Value
 
 	// create a message
 	external := []byte("") // optional external data see https://tools.ietf.org/html/rfc8152#section-4.3
@@ -826,9 +826,6 @@ var RustTestCases = []RustTestCase{
 	},
 }
 
-var algTag = GetCommonHeaderTagOrPanic("alg")
-var kidTag = GetCommonHeaderTagOrPanic("kid")
-
 // WGExample
 // autogenerated from pass and fail examples on https://mholt.github.io/json-to-go/
 // then combined (added .Fail and .Input.Failures)
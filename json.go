@@ -0,0 +1,271 @@
+package cose
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// JSON is a debugging and inspection format for COSE messages. It is
+// not the COSE serialization and is never signed or verified; use
+// Marshal and Unmarshal for the CBOR wire format.
+//
+// In the JSON representation:
+//
+// * header labels and alg values use their common names
+//   (e.g. {"alg": "ES256"}) and other int labels are decimal strings
+// * byte strings are base64url encoded without padding
+// * a nil (detached) payload is null
+//
+// The representation is lossy for byte string header values since JSON
+// has no byte string type. Only the kid, IV, and Partial IV headers are
+// base64url decoded back to byte strings by UnmarshalJSON.
+
+type jsonSignature struct {
+	Protected   map[string]interface{} `json:"protected"`
+	Unprotected map[string]interface{} `json:"unprotected"`
+	Signature   string                 `json:"signature"`
+}
+
+type jsonSignMessage struct {
+	Protected   map[string]interface{} `json:"protected"`
+	Unprotected map[string]interface{} `json:"unprotected"`
+	Payload     *string                `json:"payload"`
+	Signatures  []jsonSignature        `json:"signatures"`
+}
+
+// jsonByteStringLabels are the common headers with bstr values
+var jsonByteStringLabels = map[string]bool{
+	"kid":        true,
+	"IV":         true,
+	"Partial IV": true,
+}
+
+// MarshalJSON encodes SignMessage to JSON for debugging. It is not
+// the COSE serialization of the message.
+func (message *SignMessage) MarshalJSON() (b []byte, err error) {
+	defer func() {
+		// FindDuplicateHeader panics on duplicate compressed and
+		// uncompressed headers
+		if r := recover(); r != nil {
+			b = nil
+			err = fmt.Errorf("json: %v", r)
+		}
+	}()
+
+	if message.Headers == nil {
+		return nil, errors.New("json: SignMessage has nil Headers")
+	}
+	dup := FindDuplicateHeader(message.Headers)
+	if dup != nil {
+		return nil, fmt.Errorf("json: Duplicate header %+v found", dup)
+	}
+
+	m := jsonSignMessage{
+		Protected:   headersToJSON(message.Headers.Protected),
+		Unprotected: headersToJSON(message.Headers.Unprotected),
+		Signatures:  []jsonSignature{},
+	}
+	if message.Payload != nil {
+		payload := base64.RawURLEncoding.EncodeToString(message.Payload)
+		m.Payload = &payload
+	}
+
+	for _, s := range message.Signatures {
+		if s.Headers == nil {
+			return nil, errors.New("json: Signature has nil Headers")
+		}
+		dup := FindDuplicateHeader(s.Headers)
+		if dup != nil {
+			return nil, fmt.Errorf("json: Duplicate signature header %+v found", dup)
+		}
+		m.Signatures = append(m.Signatures, jsonSignature{
+			Protected:   headersToJSON(s.Headers.Protected),
+			Unprotected: headersToJSON(s.Headers.Unprotected),
+			Signature:   base64.RawURLEncoding.EncodeToString(s.SignatureBytes),
+		})
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON decodes JSON from MarshalJSON into SignMessage
+func (message *SignMessage) UnmarshalJSON(data []byte) (err error) {
+	if message == nil {
+		return errors.New("json: UnmarshalJSON on nil SignMessage pointer")
+	}
+
+	var m jsonSignMessage
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&m)
+	if err != nil {
+		return err
+	}
+
+	msgHeaders, err := headersFromJSON(m.Protected, m.Unprotected)
+	if err != nil {
+		return err
+	}
+
+	var payload []byte
+	if m.Payload != nil {
+		payload, err = base64.RawURLEncoding.DecodeString(*m.Payload)
+		if err != nil {
+			return errors.Wrap(err, "json: error decoding payload")
+		}
+	}
+
+	var sigs []Signature
+	for i, s := range m.Signatures {
+		sh, err := headersFromJSON(s.Protected, s.Unprotected)
+		if err != nil {
+			return err
+		}
+		signatureBytes, err := base64.RawURLEncoding.DecodeString(s.Signature)
+		if err != nil {
+			return errors.Wrapf(err, "json: error decoding signature %d", i)
+		}
+		sigs = append(sigs, Signature{
+			Headers:        sh,
+			SignatureBytes: signatureBytes,
+		})
+	}
+
+	*message = SignMessage{
+		Headers:    msgHeaders,
+		Payload:    payload,
+		Signatures: sigs,
+	}
+	return nil
+}
+
+// headersToJSON decompresses headers and converts them to JSON
+// compatible values
+func headersToJSON(headers map[interface{}]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range DecompressHeaders(headers) {
+		result[labelToJSON(k)] = valueToJSON(v)
+	}
+	return result
+}
+
+func labelToJSON(label interface{}) string {
+	switch l := label.(type) {
+	case string:
+		return l
+	case int:
+		return strconv.Itoa(l)
+	case int64:
+		return strconv.FormatInt(l, 10)
+	default:
+		return fmt.Sprint(l)
+	}
+}
+
+func valueToJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return base64.RawURLEncoding.EncodeToString(v)
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = valueToJSON(item)
+		}
+		return result
+	case map[interface{}]interface{}:
+		result := map[string]interface{}{}
+		for k, item := range v {
+			result[labelToJSON(k)] = valueToJSON(item)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+// headersFromJSON converts JSON protected and unprotected headers to
+// compressed Headers
+func headersFromJSON(protected, unprotected map[string]interface{}) (h *Headers, err error) {
+	defer func() {
+		// CompressHeaders panics on duplicate headers
+		if r := recover(); r != nil {
+			h = nil
+			err = fmt.Errorf("json: %v", r)
+		}
+	}()
+
+	p, err := headerMapFromJSON(protected)
+	if err != nil {
+		return nil, err
+	}
+	u, err := headerMapFromJSON(unprotected)
+	if err != nil {
+		return nil, err
+	}
+	h = &Headers{
+		Protected:   CompressHeaders(p),
+		Unprotected: CompressHeaders(u),
+	}
+	dup := FindDuplicateHeader(h)
+	if dup != nil {
+		return nil, fmt.Errorf("json: Duplicate header %+v found", dup)
+	}
+	return h, nil
+}
+
+func headerMapFromJSON(headers map[string]interface{}) (result map[interface{}]interface{}, err error) {
+	result = map[interface{}]interface{}{}
+	for k, v := range headers {
+		label := labelFromJSON(k)
+		value := valueFromJSON(v)
+
+		name := k
+		if tag, ok := label.(int); ok {
+			name, _ = GetCommonHeaderLabel(tag)
+		}
+		if s, ok := value.(string); ok && jsonByteStringLabels[name] {
+			value, err = base64.RawURLEncoding.DecodeString(s)
+			if err != nil {
+				return nil, errors.Wrapf(err, "json: error decoding %s header", name)
+			}
+		}
+		result[label] = value
+	}
+	return result, nil
+}
+
+func labelFromJSON(label string) interface{} {
+	if i, err := strconv.Atoi(label); err == nil {
+		return i
+	}
+	return label
+}
+
+func valueFromJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := strconv.Atoi(v.String()); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = valueFromJSON(item)
+		}
+		return result
+	case map[string]interface{}:
+		result := map[interface{}]interface{}{}
+		for k, item := range v {
+			result[labelFromJSON(k)] = valueFromJSON(item)
+		}
+		return result
+	default:
+		return v
+	}
+}
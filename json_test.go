@@ -0,0 +1,109 @@
+package cose
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignMessageJSONRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload to sign")
+	msg.Headers.Protected["content type"] = "text/plain"
+	msg.Headers.Unprotected[-70000] = []interface{}{1, "two"}
+
+	sig := NewSignature()
+	sig.Headers.Protected["alg"] = "ES256"
+	sig.Headers.Unprotected["kid"] = []byte("key 1")
+	sig.SignatureBytes = []byte("\x01\x02\xfe\xff")
+	msg.AddSignature(sig)
+
+	b, err := json.Marshal(msg)
+	assert.Nil(err)
+	assert.Equal(`{"protected":{"content type":"text/plain"},"unprotected":{"-70000":[1,"two"]},`+
+		`"payload":"cGF5bG9hZCB0byBzaWdu",`+
+		`"signatures":[{"protected":{"alg":"ES256"},"unprotected":{"kid":"a2V5IDE"},"signature":"AQL-_w"}]}`,
+		string(b))
+
+	var decoded SignMessage
+	err = json.Unmarshal(b, &decoded)
+	assert.Nil(err)
+
+	expected, err := Marshal(msg)
+	assert.Nil(err)
+	actual, err := Marshal(&decoded)
+	assert.Nil(err)
+	assert.Equal(expected, actual)
+}
+
+func TestSignMessageJSONVerifies(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload to sign")
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+
+	b, err := json.Marshal(msg)
+	assert.Nil(err)
+
+	var decoded SignMessage
+	assert.Nil(json.Unmarshal(b, &decoded))
+	assert.Nil(decoded.Verify(nil, []Verifier{*signer.Verifier()}))
+}
+
+func TestSignMessageJSONDetachedPayload(t *testing.T) {
+	assert := assert.New(t)
+
+	b, err := json.Marshal(NewSignMessage())
+	assert.Nil(err)
+	assert.Equal(`{"protected":{},"unprotected":{},"payload":null,"signatures":[]}`, string(b))
+
+	var decoded SignMessage
+	assert.Nil(json.Unmarshal(b, &decoded))
+	assert.Nil(decoded.Payload)
+}
+
+func TestSignMessageJSONErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	msg := NewSignMessage()
+	msg.Headers = nil
+	_, err := json.Marshal(msg)
+	assert.Equal("json: error calling MarshalJSON for type *cose.SignMessage: json: SignMessage has nil Headers", err.Error())
+
+	msg = NewSignMessage()
+	msg.Headers.Protected["alg"] = "ES256"
+	msg.Headers.Unprotected[CommonHeaderIDAlg] = -7
+	_, err = msg.MarshalJSON()
+	assert.Equal("json: Duplicate header 1 found", err.Error())
+
+	msg = NewSignMessage()
+	msg.Headers.Protected["alg"] = "ES256"
+	msg.Headers.Protected[CommonHeaderIDAlg] = -7
+	_, err = msg.MarshalJSON()
+	assert.Equal("json: Duplicate compressed and uncompressed common header 1 found in headers", err.Error())
+
+	var decoded *SignMessage
+	assert.Equal("json: UnmarshalJSON on nil SignMessage pointer", decoded.UnmarshalJSON([]byte("{}")).Error())
+
+	decoded = &SignMessage{}
+	assert.NotNil(decoded.UnmarshalJSON([]byte(`{"payload":"!"}`)))
+	assert.NotNil(decoded.UnmarshalJSON([]byte(`{"signatures":[{"signature":"!"}]}`)))
+	assert.NotNil(decoded.UnmarshalJSON([]byte(`{"protected":{"kid":"!"}}`)))
+	assert.Equal(
+		"json: Duplicate header 1 found",
+		decoded.UnmarshalJSON([]byte(`{"protected":{"alg":"ES256"},"unprotected":{"1":-7}}`)).Error())
+	assert.Equal(
+		"json: Duplicate compressed and uncompressed common header 1 found in headers",
+		decoded.UnmarshalJSON([]byte(`{"protected":{"alg":"ES256","1":-7}}`)).Error())
+}
@@ -0,0 +1,471 @@
+package cose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"math/big"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// COSE_Key labels and values for EC2 and OKP keys
+//
+// https://tools.ietf.org/html/rfc8152#section-7.1
+// https://tools.ietf.org/html/rfc8152#section-13
+const (
+	keyLabelKty = 1
+	keyLabelKid = 2
+	keyLabelAlg = 3
+	keyLabelCrv = -1
+	keyLabelX   = -2
+	keyLabelY   = -3
+	keyLabelD   = -4
+
+	keyLabelN = -1
+	keyLabelE = -2
+
+	keyTypeOKP = 1
+	keyTypeEC2 = 2
+	keyTypeRSA = 3
+)
+
+// keyCurve is a COSE_Key curve and its JWK name and coordinate size
+type keyCurve struct {
+	value   int
+	name    string
+	kty     int
+	keySize int
+}
+
+var keyCurves = []keyCurve{
+	{1, "P-256", keyTypeEC2, 32},
+	{2, "P-384", keyTypeEC2, 48},
+	{3, "P-521", keyTypeEC2, 66},
+	{4, "X25519", keyTypeOKP, 32},
+	{5, "X448", keyTypeOKP, 56},
+	{6, "Ed25519", keyTypeOKP, 32},
+	{7, "Ed448", keyTypeOKP, 57},
+}
+
+// jwkKeyTypes maps COSE_Key kty values to JWK kty names
+var jwkKeyTypes = map[int]string{
+	keyTypeOKP: "OKP",
+	keyTypeEC2: "EC",
+}
+
+// jwk is the subset of JSON Web Key parameters for EC and OKP keys
+//
+// https://tools.ietf.org/html/rfc7518#section-6.2
+// https://tools.ietf.org/html/rfc8037#section-2
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+	D   string `json:"d,omitempty"`
+}
+
+// COSEKeyToJWK converts a CBOR encoded EC2 or OKP COSE_Key to a JSON
+// Web Key
+//
+// The kty, kid, alg, crv, x, y, and d parameters are converted and
+// other key parameters are dropped. The kid bytes must be UTF-8 and
+// EC2 keys with compressed points (a bool y) are not supported.
+func COSEKeyToJWK(data []byte) (jwkBytes []byte, err error) {
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding COSE_Key")
+	}
+	decodedKey, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.Errorf("error decoding COSE_Key as map; got %T", decoded)
+	}
+	key := map[int]interface{}{}
+	for k, v := range decodedKey {
+		if label, ok := intFromInteger(k); ok {
+			key[label] = v
+		}
+	}
+
+	kty, _ := intFromInteger(key[keyLabelKty])
+	name, ok := jwkKeyTypes[kty]
+	if !ok {
+		return nil, errors.Errorf("unsupported COSE_Key kty %v", key[keyLabelKty])
+	}
+	crvValue, _ := intFromInteger(key[keyLabelCrv])
+	crv, err := findKeyCurve(func(c keyCurve) bool { return c.value == crvValue && c.kty == kty })
+	if err != nil {
+		return nil, errors.Errorf("unsupported COSE_Key crv %v for kty %s", key[keyLabelCrv], name)
+	}
+	result := jwk{Kty: name, Crv: crv.name}
+
+	if kid, ok := key[keyLabelKid]; ok {
+		kidBytes, ok := kid.([]byte)
+		if !ok {
+			return nil, errors.Errorf("error casting COSE_Key kid to bstr; got %T", kid)
+		}
+		if !utf8.Valid(kidBytes) {
+			return nil, errors.New("COSE_Key kid is not valid UTF-8")
+		}
+		result.Kid = string(kidBytes)
+	}
+	if alg, ok := key[keyLabelAlg]; ok {
+		algValue, ok := intFromInteger(alg)
+		if !ok {
+			return nil, errors.Errorf("unsupported COSE_Key alg %v", alg)
+		}
+		found, err := getAlgByValue(algValue)
+		if err != nil {
+			return nil, err
+		}
+		result.Alg = found.Name
+	}
+
+	coordinates := []struct {
+		name     string
+		label    int
+		value    *string
+		required bool
+	}{
+		{"x", keyLabelX, &result.X, true},
+		{"y", keyLabelY, &result.Y, kty == keyTypeEC2},
+		{"d", keyLabelD, &result.D, false},
+	}
+	for _, c := range coordinates {
+		o, ok := key[c.label]
+		if !ok {
+			if c.required {
+				return nil, errors.Errorf("COSE_Key is missing %s", c.name)
+			}
+			continue
+		}
+		b, ok := o.([]byte)
+		if !ok {
+			return nil, errors.Errorf("error casting COSE_Key %s to bstr; got %T", c.name, o)
+		}
+		*c.value = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return json.Marshal(result)
+}
+
+// JWKToCOSEKey converts an EC or OKP JSON Web Key to a CBOR encoded
+// COSE_Key
+//
+// The kty, kid, alg, crv, x, y, and d parameters are converted and
+// other key parameters are dropped.
+func JWKToCOSEKey(jwkBytes []byte) (data []byte, err error) {
+	var k jwk
+	err = json.Unmarshal(jwkBytes, &k)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding JWK")
+	}
+
+	var kty int
+	for value, name := range jwkKeyTypes {
+		if name == k.Kty {
+			kty = value
+		}
+	}
+	if kty == 0 {
+		return nil, errors.Errorf("unsupported JWK kty %q", k.Kty)
+	}
+	crv, err := findKeyCurve(func(c keyCurve) bool { return c.name == k.Crv && c.kty == kty })
+	if err != nil {
+		return nil, errors.Errorf("unsupported JWK crv %q for kty %s", k.Crv, k.Kty)
+	}
+
+	key := map[interface{}]interface{}{
+		keyLabelKty: kty,
+		keyLabelCrv: crv.value,
+	}
+	if k.Kid != "" {
+		key[keyLabelKid] = []byte(k.Kid)
+	}
+	if k.Alg != "" {
+		alg, err := getAlgByName(k.Alg)
+		if err != nil {
+			return nil, err
+		}
+		key[keyLabelAlg] = alg.Value
+	}
+
+	coordinates := []struct {
+		name     string
+		label    int
+		value    string
+		required bool
+	}{
+		{"x", keyLabelX, k.X, true},
+		{"y", keyLabelY, k.Y, kty == keyTypeEC2},
+		{"d", keyLabelD, k.D, false},
+	}
+	for _, c := range coordinates {
+		if c.value == "" {
+			if c.required {
+				return nil, errors.Errorf("JWK is missing %s", c.name)
+			}
+			continue
+		}
+		b, err := base64.RawURLEncoding.DecodeString(c.value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decoding JWK %s", c.name)
+		}
+		if len(b) != crv.keySize {
+			return nil, errors.Errorf("JWK %s is %d bytes for %s; expected %d", c.name, len(b), crv.name, crv.keySize)
+		}
+		key[c.label] = b
+	}
+	return Marshal(key)
+}
+
+func findKeyCurve(match func(keyCurve) bool) (crv keyCurve, err error) {
+	for _, c := range keyCurves {
+		if match(c) {
+			return c, nil
+		}
+	}
+	return keyCurve{}, errors.New("curve not found")
+}
+
+// KeyThumbprint returns the SHA-256 COSE_Key thumbprint of an
+// *ecdsa.PublicKey, ed25519.PublicKey, or *rsa.PublicKey
+//
+// The thumbprint is the hash of the deterministically encoded
+// COSE_Key with only the required kty, crv, x, and y (EC2), kty, crv,
+// and x (OKP), or kty, n, and e (RSA) parameters, so it does not
+// depend on the kid or alg.
+//
+// https://tools.ietf.org/html/rfc9679#section-3
+func KeyThumbprint(pub crypto.PublicKey) (thumbprint []byte, err error) {
+	key, err := publicKeyToCOSEKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(encoded)
+	return digest[:], nil
+}
+
+// publicKeyToCOSEKey returns a COSE_Key map with the required
+// parameters of an *ecdsa.PublicKey, ed25519.PublicKey, or
+// *rsa.PublicKey
+func publicKeyToCOSEKey(pub crypto.PublicKey) (key map[interface{}]interface{}, err error) {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		crv, err := findKeyCurve(func(c keyCurve) bool { return c.kty == keyTypeEC2 && c.name == k.Curve.Params().Name })
+		if err != nil {
+			return nil, errors.Errorf("unsupported ECDSA curve %s", k.Curve.Params().Name)
+		}
+		return map[interface{}]interface{}{
+			keyLabelKty: keyTypeEC2,
+			keyLabelCrv: crv.value,
+			keyLabelX:   I2OSP(k.X, crv.keySize),
+			keyLabelY:   I2OSP(k.Y, crv.keySize),
+		}, nil
+	case ed25519.PublicKey:
+		return map[interface{}]interface{}{
+			keyLabelKty: keyTypeOKP,
+			keyLabelCrv: 6, // Ed25519
+			keyLabelX:   []byte(k),
+		}, nil
+	case *rsa.PublicKey:
+		e := big.NewInt(int64(k.E))
+		return map[interface{}]interface{}{
+			keyLabelKty: keyTypeRSA,
+			keyLabelN:   k.N.Bytes(),
+			keyLabelE:   e.Bytes(),
+		}, nil
+	}
+	return nil, ErrUnknownPublicKeyType
+}
+
+// COSEKey is a public key and its optional kid in a COSE_Key Set
+type COSEKey struct {
+	KeyID     []byte
+	PublicKey crypto.PublicKey
+}
+
+// MarshalCOSEKeySet returns a COSE_KeySet of the *ecdsa.PublicKey,
+// ed25519.PublicKey, or *rsa.PublicKey keys with their kid when it is
+// set e.g. to publish verification keys
+//
+// https://tools.ietf.org/html/rfc8152#section-7
+func MarshalCOSEKeySet(keys []COSEKey) (data []byte, err error) {
+	keySet := make([]interface{}, len(keys))
+	for i, k := range keys {
+		key, err := publicKeyToCOSEKey(k.PublicKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "COSE_Key %d", i)
+		}
+		if k.KeyID != nil {
+			key[keyLabelKid] = k.KeyID
+		}
+		keySet[i] = key
+	}
+	return Marshal(keySet)
+}
+
+// ParseCOSEKeySet returns the public keys and their kids of a
+// COSE_KeySet like ParseCOSEKey returning an error with the index of
+// the first COSE_Key that is not a valid public key
+func ParseCOSEKeySet(data []byte) (keys []COSEKey, err error) {
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding COSE_KeySet")
+	}
+	keySet, ok := decoded.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("error decoding COSE_KeySet as array; got %T", decoded)
+	}
+	for i, o := range keySet {
+		key, err := parseCOSEPublicKey(o)
+		if err != nil {
+			return nil, errors.Wrapf(err, "COSE_Key %d", i)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ParseCOSEKey returns the public key and kid of a CBOR encoded EC2,
+// OKP, or RSA COSE_Key
+//
+// EC2 keys are *ecdsa.PublicKey and must be on their P-256, P-384, or
+// P-521 curve. OKP keys are ed25519.PublicKey and must have a 32 byte
+// x and no y. RSA keys are *rsa.PublicKey.
+func ParseCOSEKey(data []byte) (key COSEKey, err error) {
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		return key, errors.Wrap(err, "error decoding COSE_Key")
+	}
+	return parseCOSEPublicKey(decoded)
+}
+
+// parseCOSEPublicKey returns the public key and kid of a decoded
+// COSE_Key
+func parseCOSEPublicKey(o interface{}) (key COSEKey, err error) {
+	decodedKey, ok := o.(map[interface{}]interface{})
+	if !ok {
+		return key, errors.Errorf("error decoding COSE_Key as map; got %T", o)
+	}
+	params := map[int]interface{}{}
+	for k, v := range decodedKey {
+		if label, ok := intFromInteger(k); ok {
+			params[label] = v
+		}
+	}
+	bstr := func(label int, name string) ([]byte, error) {
+		o, ok := params[label]
+		if !ok {
+			return nil, errors.Errorf("COSE_Key is missing %s", name)
+		}
+		b, ok := o.([]byte)
+		if !ok {
+			return nil, errors.Errorf("error casting COSE_Key %s to bstr; got %T", name, o)
+		}
+		return b, nil
+	}
+
+	if kid, ok := params[keyLabelKid]; ok {
+		key.KeyID, ok = kid.([]byte)
+		if !ok {
+			return key, errors.Errorf("error casting COSE_Key kid to bstr; got %T", kid)
+		}
+	}
+
+	kty, _ := intFromInteger(params[keyLabelKty])
+	var crv keyCurve
+	if kty == keyTypeEC2 || kty == keyTypeOKP {
+		ktyName := map[int]string{keyTypeEC2: "EC2", keyTypeOKP: "OKP"}[kty]
+		crvValue, _ := intFromInteger(params[keyLabelCrv])
+		crv, err = findKeyCurve(func(c keyCurve) bool { return c.value == crvValue })
+		if err != nil {
+			return key, errors.Errorf("unsupported COSE_Key crv %v for kty %s", params[keyLabelCrv], ktyName)
+		}
+		if crv.kty != kty {
+			return key, errors.Errorf("COSE_Key crv %s is not a kty %s curve", crv.name, ktyName)
+		}
+	}
+
+	switch kty {
+	case keyTypeOKP:
+		if crv.name != "Ed25519" {
+			return key, errors.Errorf("unsupported COSE_Key crv %s for kty OKP", crv.name)
+		}
+		if _, ok := params[keyLabelY]; ok {
+			return key, errors.New("OKP COSE_Key has EC2 parameter y")
+		}
+		x, err := bstr(keyLabelX, "x")
+		if err != nil {
+			return key, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return key, errors.Errorf("COSE_Key x is %d bytes for %s; expected %d", len(x), crv.name, ed25519.PublicKeySize)
+		}
+		key.PublicKey = ed25519.PublicKey(x)
+	case keyTypeEC2:
+		var curve elliptic.Curve
+		switch crv.name {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		}
+		x, err := bstr(keyLabelX, "x")
+		if err != nil {
+			return key, err
+		}
+		y, err := bstr(keyLabelY, "y")
+		if err != nil {
+			return key, err
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return key, errors.New("COSE_Key point is not on the curve")
+		}
+		key.PublicKey = pub
+	case keyTypeRSA:
+		n, err := bstr(keyLabelN, "n")
+		if err != nil {
+			return key, err
+		}
+		e, err := bstr(keyLabelE, "e")
+		if err != nil {
+			return key, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) < 1 || !exponent.IsInt64() || exponent.Int64() < 2 || exponent.Int64() > math.MaxInt32 {
+			return key, errors.New("COSE_Key is not a valid RSA public key")
+		}
+		modulus := new(big.Int).SetBytes(n)
+		if modulus.BitLen() < PS256.minRSAKeyBitLen {
+			return key, errors.Errorf("COSE_Key RSA modulus must be at least %d bits long", PS256.minRSAKeyBitLen)
+		}
+		key.PublicKey = &rsa.PublicKey{
+			N: modulus,
+			E: int(exponent.Int64()),
+		}
+	default:
+		return key, errors.Errorf("unsupported COSE_Key kty %v", params[keyLabelKty])
+	}
+	return key, nil
+}
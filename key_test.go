@@ -0,0 +1,237 @@
+package cose
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func TestCOSEKeyToJWK(t *testing.T) {
+	assert := assert.New(t)
+
+	// P-256 public key from
+	// https://tools.ietf.org/html/rfc8152#appendix-C.7.1
+	coseKey, err := Marshal(map[interface{}]interface{}{
+		-1: 1,
+		-2: HexToBytesOrDie("65eda5a12577c2bae829437fe338701a10aaa375e1bb5b5de108de439c08551d"),
+		-3: HexToBytesOrDie("1e52ed75701163f7f9e40ddf9f341b3dc9ba860af7e0ca7ca7e9eecd0084d19c"),
+		1:  2,
+		2:  []byte("meriadoc.brandybuck@buckland.example"),
+		3:  ES256.Value,
+	})
+	assert.Nil(err)
+	expectedJWK := `{"kty":"EC","kid":"meriadoc.brandybuck@buckland.example","alg":"ES256","crv":"P-256","x":"Ze2loSV3wrroKUN_4zhwGhCqo3Xhu1td4QjeQ5wIVR0","y":"HlLtdXARY_f55A3fnzQbPcm6hgr34Mp8p-nuzQCE0Zw"}`
+
+	jwkBytes, err := COSEKeyToJWK(coseKey)
+	assert.Nil(err)
+	assert.Equal(expectedJWK, string(jwkBytes))
+
+	roundTrip, err := JWKToCOSEKey(jwkBytes)
+	assert.Nil(err)
+	assert.Equal(coseKey, roundTrip)
+
+	// Ed25519 private key from
+	// https://tools.ietf.org/html/rfc8037#appendix-A.1
+	okpJWK := `{"kty":"OKP","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo","d":"nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A"}`
+	coseKey, err = JWKToCOSEKey([]byte(okpJWK))
+	assert.Nil(err)
+	expectedCOSEKey, err := Marshal(map[interface{}]interface{}{
+		1:  1,
+		-1: 6,
+		-2: HexToBytesOrDie("d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"),
+		-4: HexToBytesOrDie("9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60"),
+	})
+	assert.Nil(err)
+	assert.Equal(expectedCOSEKey, coseKey)
+
+	jwkBytes, err = COSEKeyToJWK(coseKey)
+	assert.Nil(err)
+	assert.Equal(okpJWK, string(jwkBytes))
+}
+
+func TestCOSEKeyJWKErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	x := HexToBytesOrDie("65eda5a12577c2bae829437fe338701a10aaa375e1bb5b5de108de439c08551d")
+
+	var coseKeyTests = []struct {
+		key map[interface{}]interface{}
+		err string
+	}{
+		{map[interface{}]interface{}{1: 4, -1: 1}, "unsupported COSE_Key kty 4"},
+		{map[interface{}]interface{}{1: 2, -1: 6, -2: x, -3: x}, "unsupported COSE_Key crv 6 for kty EC"},
+		{map[interface{}]interface{}{1: 2, -1: 1, -2: x}, "COSE_Key is missing y"},
+		{map[interface{}]interface{}{1: 2, -1: 1, -2: x, -3: true}, "error casting COSE_Key y to bstr; got bool"},
+		{map[interface{}]interface{}{1: 2, -1: 1, -2: x, -3: x, 2: "kid"}, "error casting COSE_Key kid to bstr; got string"},
+		{map[interface{}]interface{}{1: 2, -1: 1, -2: x, -3: x, 2: []byte("\xff")}, "COSE_Key kid is not valid UTF-8"},
+		{map[interface{}]interface{}{1: 2, -1: 1, -2: x, -3: x, 3: -1000000}, "Algorithm with value -1000000 not found"},
+	}
+	for _, test := range coseKeyTests {
+		coseKey, err := Marshal(test.key)
+		assert.Nil(err)
+		_, err = COSEKeyToJWK(coseKey)
+		assert.Equal(test.err, err.Error())
+	}
+	_, err := COSEKeyToJWK(HexToBytesOrDie("80"))
+	assert.Equal("error decoding COSE_Key as map; got []interface {}", err.Error())
+
+	var jwkTests = []struct {
+		jwk string
+		err string
+	}{
+		{`{"kty":"RSA"}`, `unsupported JWK kty "RSA"`},
+		{`{"kty":"OKP","crv":"P-256"}`, `unsupported JWK crv "P-256" for kty OKP`},
+		{`{"kty":"EC","crv":"P-256","x":"Ze2loSV3wrroKUN_4zhwGhCqo3Xhu1td4QjeQ5wIVR0"}`, "JWK is missing y"},
+		{`{"kty":"EC","crv":"P-384","x":"Ze2loSV3wrroKUN_4zhwGhCqo3Xhu1td4QjeQ5wIVR0","y":"Ze2loSV3wrroKUN_4zhwGhCqo3Xhu1td4QjeQ5wIVR0"}`, "JWK x is 32 bytes for P-384; expected 48"},
+		{`{"kty":"OKP","crv":"Ed25519","x":"not base64!"}`, "error decoding JWK x: illegal base64 data at input byte 3"},
+		{`{"kty":"OKP","crv":"Ed25519","alg":"FOOOO","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`, "Algorithm named FOOOO not found"},
+	}
+	for _, test := range jwkTests {
+		_, err = JWKToCOSEKey([]byte(test.jwk))
+		assert.Equal(test.err, err.Error())
+	}
+	_, err = JWKToCOSEKey([]byte("not json"))
+	assert.NotNil(err)
+}
+
+func TestKeyThumbprint(t *testing.T) {
+	assert := assert.New(t)
+
+	// https://tools.ietf.org/html/rfc9679#section-6
+	x, _ := hex.DecodeString("65eda5a12577c2bae829437fe338701a10aaa375e1bb5b5de108de439c08551d")
+	y, _ := hex.DecodeString("1e52ed75701163f7f9e40ddf9f341b3dc9ba860af7e0ca7ca7e9eecd0084d19c")
+	pub := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	thumbprint, err := KeyThumbprint(pub)
+	assert.Nil(err)
+	assert.Equal("496bd8afadf307e5b08c64b0421bf9dc01528a344a43bda88fadd1669da253ec", hex.EncodeToString(thumbprint))
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	thumbprint, err = KeyThumbprint(&rsaKey.PublicKey)
+	assert.Nil(err)
+	assert.Len(thumbprint, 32)
+
+	_, err = KeyThumbprint(&ecdsa.PublicKey{Curve: elliptic.P224(), X: big.NewInt(1), Y: big.NewInt(1)})
+	assert.Equal("unsupported ECDSA curve P-224", err.Error())
+	_, err = KeyThumbprint(rsaKey.PublicKey)
+	assert.Equal(ErrUnknownPublicKeyType, err)
+}
+
+func TestCOSEKeySet(t *testing.T) {
+	assert := assert.New(t)
+
+	var keys []COSEKey
+	for _, alg := range []*Algorithm{ES256, ES384, ES512, PS256} {
+		signer, err := NewSigner(alg, nil)
+		assert.Nil(err, "Error creating signer")
+		keys = append(keys, COSEKey{KeyID: []byte(alg.Name), PublicKey: signer.Public()})
+	}
+	keys[1].KeyID = nil
+
+	data, err := MarshalCOSEKeySet(keys)
+	assert.Nil(err)
+	parsed, err := ParseCOSEKeySet(data)
+	assert.Nil(err)
+	assert.Equal(keys, parsed)
+
+	// the kid is not part of the thumbprint
+	for i := range keys {
+		expected, err := KeyThumbprint(keys[i].PublicKey)
+		assert.Nil(err)
+		thumbprint, err := KeyThumbprint(parsed[i].PublicKey)
+		assert.Nil(err)
+		assert.Equal(expected, thumbprint)
+	}
+
+	empty, err := MarshalCOSEKeySet(nil)
+	assert.Nil(err)
+	assert.Equal([]byte("\x80"), empty)
+	parsed, err = ParseCOSEKeySet(empty)
+	assert.Nil(err)
+	assert.Len(parsed, 0)
+
+	_, err = MarshalCOSEKeySet([]COSEKey{keys[0], {PublicKey: "not a key"}})
+	assert.Equal("COSE_Key 1: "+ErrUnknownPublicKeyType.Error(), err.Error())
+
+	ec := keys[0].PublicKey.(*ecdsa.PublicKey)
+	rsaKey := keys[3].PublicKey.(*rsa.PublicKey)
+	validRSA := map[interface{}]interface{}{1: 3, -1: rsaKey.N.Bytes(), -2: big.NewInt(int64(rsaKey.E)).Bytes()}
+	offCurve := map[interface{}]interface{}{1: 2, -1: 1, -2: I2OSP(ec.X, 32), -3: I2OSP(new(big.Int).Add(ec.Y, big.NewInt(1)), 32)}
+	for _, testCase := range []struct {
+		key      interface{}
+		expected string
+	}{
+		{"key", "COSE_Key 1: error decoding COSE_Key as map; got string"},
+		{map[interface{}]interface{}{1: 4, -1: []byte{}}, "COSE_Key 1: unsupported COSE_Key kty 4"},
+		{map[interface{}]interface{}{1: 2, -1: 9}, "COSE_Key 1: unsupported COSE_Key crv 9 for kty EC2"},
+		{map[interface{}]interface{}{1: 2, -1: 4}, "COSE_Key 1: COSE_Key crv X25519 is not a kty EC2 curve"},
+		{map[interface{}]interface{}{1: 2, -1: 1, -2: []byte{1}}, "COSE_Key 1: COSE_Key is missing y"},
+		{map[interface{}]interface{}{1: 2, -1: 1, -2: []byte{1}, -3: true}, "COSE_Key 1: error casting COSE_Key y to bstr; got bool"},
+		{offCurve, "COSE_Key 1: COSE_Key point is not on the curve"},
+		{map[interface{}]interface{}{1: 3, -1: []byte{1}, -2: []byte{1}}, "COSE_Key 1: COSE_Key is not a valid RSA public key"},
+		{map[interface{}]interface{}{1: 3, -1: []byte{1}, -2: []byte{1, 0, 1}}, "COSE_Key 1: COSE_Key RSA modulus must be at least 2048 bits long"},
+		{map[interface{}]interface{}{1: 3, -1: rsaKey.N.Bytes()[1:], -2: []byte{1, 0, 1}}, "COSE_Key 1: COSE_Key RSA modulus must be at least 2048 bits long"},
+		{map[interface{}]interface{}{1: 3, -1: []byte{1}}, "COSE_Key 1: COSE_Key is missing e"},
+		{map[interface{}]interface{}{1: 3, 2: "kid", -1: rsaKey.N.Bytes(), -2: []byte{1, 0, 1}}, "COSE_Key 1: error casting COSE_Key kid to bstr; got string"},
+	} {
+		set, err := Marshal([]interface{}{validRSA, testCase.key})
+		assert.Nil(err)
+		_, err = ParseCOSEKeySet(set)
+		assert.Equal(testCase.expected, err.Error())
+	}
+
+	_, err = ParseCOSEKeySet([]byte("\xA0"))
+	assert.Equal("error decoding COSE_KeySet as array; got map[interface {}]interface {}", err.Error())
+}
+
+func TestParseCOSEKeyOKP(t *testing.T) {
+	assert := assert.New(t)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+	data, err := Marshal(map[interface{}]interface{}{1: 1, 2: []byte("ed"), -1: 6, -2: []byte(pub)})
+	assert.Nil(err)
+	key, err := ParseCOSEKey(data)
+	assert.Nil(err)
+	assert.Equal(COSEKey{KeyID: []byte("ed"), PublicKey: pub}, key)
+
+	// round trips in a COSE_Key Set and has a thumbprint
+	set, err := MarshalCOSEKeySet([]COSEKey{key})
+	assert.Nil(err)
+	keys, err := ParseCOSEKeySet(set)
+	assert.Nil(err)
+	assert.Equal([]COSEKey{key}, keys)
+	thumbprint, err := KeyThumbprint(pub)
+	assert.Nil(err)
+	assert.Len(thumbprint, 32)
+
+	for _, testCase := range []struct {
+		key      map[interface{}]interface{}
+		expected string
+	}{
+		{map[interface{}]interface{}{1: 1, -1: 6, -2: []byte(pub)[:31]}, "COSE_Key x is 31 bytes for Ed25519; expected 32"},
+		{map[interface{}]interface{}{1: 1, -1: 6}, "COSE_Key is missing x"},
+		{map[interface{}]interface{}{1: 1, -1: 6, -2: []byte(pub), -3: []byte(pub)}, "OKP COSE_Key has EC2 parameter y"},
+		{map[interface{}]interface{}{1: 1, -1: 4, -2: []byte(pub)}, "unsupported COSE_Key crv X25519 for kty OKP"},
+		{map[interface{}]interface{}{1: 1, -1: 1, -2: []byte(pub)}, "COSE_Key crv P-256 is not a kty OKP curve"},
+		{map[interface{}]interface{}{1: 1, -1: "Ed25519", -2: []byte(pub)}, "unsupported COSE_Key crv Ed25519 for kty OKP"},
+	} {
+		data, err := Marshal(testCase.key)
+		assert.Nil(err)
+		_, err = ParseCOSEKey(data)
+		assert.Equal(testCase.expected, err.Error())
+	}
+
+	_, err = ParseCOSEKey([]byte("\xA1"))
+	assert.NotNil(err)
+}
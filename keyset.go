@@ -0,0 +1,88 @@
+package cose
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// KeySet holds Verifiers indexed by kid and by the KeyThumbprint of
+// their public key e.g. a key ring of trusted signers
+//
+// It is safe for concurrent use. Use its Lookup method as the
+// VerifierLookup for VerifyAll and VerifyCounterSignatures or
+// Verifiers to get the Verifiers for Verify.
+type KeySet struct {
+	mu        sync.RWMutex
+	verifiers map[string]*Verifier
+}
+
+// NewKeySet returns an empty KeySet
+func NewKeySet() *KeySet {
+	return &KeySet{verifiers: map[string]*Verifier{}}
+}
+
+// Add adds verifier to the KeySet for kid and for the KeyThumbprint of
+// its public key replacing any Verifier for them
+//
+// kid may be nil to add verifier by thumbprint only, e.g. for
+// signatures with a kid from SignOpts.AutoKID.
+func (ks *KeySet) Add(kid []byte, verifier *Verifier) (err error) {
+	if verifier == nil {
+		return errors.New("cannot add a nil Verifier to a KeySet")
+	} else if verifier.Alg == nil {
+		return errors.New("cannot add a Verifier without an Alg to a KeySet")
+	}
+	thumbprint, err := KeyThumbprint(verifier.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "error computing Verifier key thumbprint")
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.verifiers == nil {
+		ks.verifiers = map[string]*Verifier{}
+	}
+	if kid != nil {
+		ks.verifiers[string(kid)] = verifier
+	}
+	ks.verifiers[string(thumbprint)] = verifier
+	return nil
+}
+
+// Lookup returns the Verifier for a kid or key thumbprint returning an
+// error wrapping ErrNoVerifierFound when there is none
+//
+// It is a VerifierLookup.
+func (ks *KeySet) Lookup(kid []byte) (verifier *Verifier, err error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	verifier, ok := ks.verifiers[string(kid)]
+	if !ok {
+		return nil, errors.Wrapf(ErrNoVerifierFound, "kid %x", kid)
+	}
+	return verifier, nil
+}
+
+// Verifiers returns the Verifier for the kid header of each signature
+// on m to pass to Verify or VerifyWithOpts
+func (ks *KeySet) Verifiers(m *SignMessage) (verifiers []Verifier, err error) {
+	if m == nil || len(m.Signatures) < 1 {
+		return nil, ErrNoSignatures
+	}
+	for i, signature := range m.Signatures {
+		var kid []byte
+		if value, ok := getCommonHeader(signature.Headers, "kid"); ok {
+			kid, ok = value.([]byte)
+			if !ok {
+				return nil, errors.Errorf("SignMessage signature %d kid is not a bstr; got %T", i, value)
+			}
+		}
+		verifier, err := ks.Lookup(kid)
+		if err != nil {
+			return nil, errors.Wrapf(err, "SignMessage signature %d", i)
+		}
+		verifiers = append(verifiers, *verifier)
+	}
+	return verifiers, nil
+}
@@ -0,0 +1,83 @@
+package cose
+
+import (
+	"crypto/rand"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestKeySet(t *testing.T) {
+	assert := assert.New(t)
+
+	ecSigner, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	psSigner, err := NewSigner(PS256, nil)
+	assert.Nil(err, "Error creating signer")
+	otherSigner, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+
+	keySet := NewKeySet()
+	assert.Nil(keySet.Add([]byte("ec"), ecSigner.Verifier()))
+	assert.Nil(keySet.Add(nil, psSigner.Verifier()))
+
+	// signatures with a kid and a thumbprint kid
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	ecSig := NewSignature()
+	ecSig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	ecSig.Headers.Unprotected[CommonHeaderIDKeyID] = []byte("ec")
+	msg.AddSignature(ecSig)
+	psSig := NewSignature()
+	psSig.Headers.Protected[CommonHeaderIDAlg] = PS256.Value
+	msg.AddSignature(psSig)
+	assert.Nil(msg.SignWithOpts(rand.Reader, nil, []Signer{*ecSigner, *psSigner}, SignOpts{AutoKID: true}))
+
+	verifiers, err := keySet.Verifiers(msg)
+	assert.Nil(err)
+	assert.Nil(msg.Verify(nil, verifiers))
+
+	results, err := msg.VerifyAll(nil, keySet.Lookup)
+	assert.Nil(err)
+	assert.Nil(results[0].Err)
+	assert.Nil(results[1].Err)
+
+	// the ES256 verifier is also found by thumbprint
+	thumbprint, err := KeyThumbprint(ecSigner.Public())
+	assert.Nil(err)
+	verifier, err := keySet.Lookup(thumbprint)
+	assert.Nil(err)
+	assert.Equal(ecSigner.Public(), verifier.PublicKey)
+
+	_, err = keySet.Lookup([]byte("unknown"))
+	assert.Equal(ErrNoVerifierFound, errors.Cause(err))
+	assert.Equal("kid 756e6b6e6f776e: No verifier found", err.Error())
+
+	// a signature without a kid or with an unknown kid
+	token := signedToken(t, otherSigner, "payload")
+	_, err = keySet.Verifiers(token)
+	assert.Equal("SignMessage signature 0: kid : No verifier found", err.Error())
+	token.Signatures[0].Headers.Unprotected[CommonHeaderIDKeyID] = "ec"
+	_, err = keySet.Verifiers(token)
+	assert.Equal("SignMessage signature 0 kid is not a bstr; got string", err.Error())
+
+	// a replaced verifier does not verify the old key's signatures
+	assert.Nil(keySet.Add([]byte("ec"), otherSigner.Verifier()))
+	verifiers, err = keySet.Verifiers(msg)
+	assert.Nil(err)
+	assert.Equal(ErrECDSAVerification, msg.Verify(nil, verifiers))
+
+	_, err = keySet.Verifiers(NewSignMessage())
+	assert.Equal(ErrNoSignatures, err)
+	assert.Equal("cannot add a nil Verifier to a KeySet", keySet.Add([]byte("kid"), nil).Error())
+	assert.Equal("cannot add a Verifier without an Alg to a KeySet", keySet.Add([]byte("kid"), &Verifier{PublicKey: ecSigner.Public()}).Error())
+	assert.NotNil(keySet.Add([]byte("kid"), &Verifier{PublicKey: "not a key", Alg: ES256}))
+	_, err = keySet.Lookup([]byte("kid"))
+	assert.Equal(ErrNoVerifierFound, errors.Cause(err))
+
+	// the zero KeySet is usable
+	var zero KeySet
+	assert.Nil(zero.Add([]byte("ec"), ecSigner.Verifier()))
+	_, err = zero.Lookup([]byte("ec"))
+	assert.Nil(err)
+}
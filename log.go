@@ -0,0 +1,22 @@
+package cose
+
+// Logger receives diagnostic messages from header lookups, alg
+// resolution, and signature verification e.g. to trace why a message
+// failed to verify
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// logger is nil by default and call sites check it before building
+// messages so logging costs nothing until SetLogger is called
+var logger Logger
+
+// SetLogger sets the Logger for the package or disables logging when
+// l is nil (the default)
+//
+// It is not safe to call concurrently with other functions in the
+// package, so set it once during initialization.
+func SetLogger(l Logger) {
+	logger = l
+}
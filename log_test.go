@@ -0,0 +1,63 @@
+package cose
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "debug: "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "warn: "+fmt.Sprintf(format, args...))
+}
+
+func TestSetLogger(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+
+	l := &recordingLogger{}
+	SetLogger(l)
+	defer SetLogger(nil)
+
+	assert.Equal(ErrECDSAVerification, msg.Verify([]byte("external"), []Verifier{*signer.Verifier()}))
+	assert.Equal([]string{
+		"debug: resolved alg header to ES256",
+		"warn: SignMessage signature 0 failed to verify: " + ErrECDSAVerification.Error(),
+	}, l.messages)
+
+	l.messages = nil
+	_, ok := getCommonHeader(sig.Headers, "alg")
+	assert.True(ok)
+	_, ok = getCommonHeader(sig.Headers, "kid")
+	assert.False(ok)
+	delete(sig.Headers.Protected, CommonHeaderIDAlg)
+	_, err = getAlg(sig.Headers)
+	assert.Equal(ErrAlgNotFound, err)
+	assert.Equal([]string{
+		"debug: found alg header in the protected headers",
+		"debug: kid header not found",
+		"debug: cannot resolve alg header: " + ErrAlgNotFound.Error(),
+	}, l.messages)
+
+	// the default nil logger discards messages
+	SetLogger(nil)
+	l.messages = nil
+	_, _ = getAlg(sig.Headers)
+	assert.Nil(l.messages)
+}
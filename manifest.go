@@ -0,0 +1,102 @@
+package cose
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// merklePayloadEntries returns the [name, sha256] entries for files
+// sorted by name
+func merklePayloadEntries(files map[string]io.Reader) (entries []interface{}, err error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if files[name] == nil {
+			return nil, errors.Errorf("file %q has a nil reader", name)
+		}
+		hasher := sha256.New()
+		_, err = io.Copy(hasher, files[name])
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading file %q", name)
+		}
+		entries = append(entries, []interface{}{name, hasher.Sum(nil)})
+	}
+	return entries, nil
+}
+
+// MerklePayload returns a payload to sign a set of files with one
+// signature
+//
+// The payload is a CBOR array of [name, sha256] entries with the
+// SHA-256 digest of each file sorted by file name:
+//
+// MerklePayload = [ * [ name: tstr, sha256: bstr ] ]
+//
+// so it is the same for the same files regardless of map order.
+func MerklePayload(files map[string]io.Reader) (payload []byte, err error) {
+	if len(files) < 1 {
+		return nil, errors.New("no files for MerklePayload")
+	}
+	entries, err := merklePayloadEntries(files)
+	if err != nil {
+		return nil, err
+	}
+	return Marshal(entries)
+}
+
+// VerifyMerklePayload checks that payload is the MerklePayload for
+// files returning an error naming the first file that is missing,
+// extra, or has different content
+//
+// It does not verify signatures, so Verify the message the payload is
+// from too.
+func VerifyMerklePayload(payload []byte, files map[string]io.Reader) (err error) {
+	var signed [][]interface{}
+	err = decMode.Unmarshal(payload, &signed)
+	if err != nil {
+		return errors.Wrap(err, "error decoding MerklePayload")
+	}
+	entries, err := merklePayloadEntries(files)
+	if err != nil {
+		return err
+	}
+
+	for i, o := range signed {
+		if len(o) != 2 {
+			return errors.Errorf("error decoding MerklePayload entry %d as 2-item array", i)
+		}
+		name, ok := o[0].(string)
+		if !ok {
+			return errors.Errorf("error decoding MerklePayload entry %d name as tstr; got %T", i, o[0])
+		}
+		digest, ok := o[1].([]byte)
+		if !ok {
+			return errors.Errorf("error decoding MerklePayload entry %d sha256 as bstr; got %T", i, o[1])
+		}
+		if i >= len(entries) {
+			return errors.Errorf("file %q is missing", name)
+		}
+		entry := entries[i].([]interface{})
+		if entry[0] != name {
+			if entry[0].(string) < name {
+				return errors.Errorf("file %q is not in the MerklePayload", entry[0])
+			}
+			return errors.Errorf("file %q is missing", name)
+		}
+		if !bytes.Equal(entry[1].([]byte), digest) {
+			return errors.Errorf("file %q does not match its MerklePayload digest", name)
+		}
+	}
+	if len(entries) > len(signed) {
+		return errors.Errorf("file %q is not in the MerklePayload", entries[len(signed)].([]interface{})[0])
+	}
+	return nil
+}
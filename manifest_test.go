@@ -0,0 +1,74 @@
+package cose
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func testFiles(contents map[string]string) map[string]io.Reader {
+	files := map[string]io.Reader{}
+	for name, content := range contents {
+		files[name] = bytes.NewReader([]byte(content))
+	}
+	return files
+}
+
+func TestMerklePayload(t *testing.T) {
+	assert := assert.New(t)
+
+	contents := map[string]string{"b.bin": "bbb", "a.txt": "aaa"}
+	payload, err := MerklePayload(testFiles(contents))
+	assert.Nil(err)
+
+	a, b := sha256.Sum256([]byte("aaa")), sha256.Sum256([]byte("bbb"))
+	expected, err := Marshal([]interface{}{
+		[]interface{}{"a.txt", a[:]},
+		[]interface{}{"b.bin", b[:]},
+	})
+	assert.Nil(err)
+	assert.Equal(expected, payload)
+
+	// sign and verify the files with one signature
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	msg := NewSignMessage()
+	msg.Payload = payload
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+	assert.Nil(msg.Verify(nil, []Verifier{*signer.Verifier()}))
+	assert.Nil(VerifyMerklePayload(msg.Payload, testFiles(contents)))
+
+	assert.Equal(
+		`file "b.bin" does not match its MerklePayload digest`,
+		VerifyMerklePayload(payload, testFiles(map[string]string{"a.txt": "aaa", "b.bin": "bbc"})).Error())
+	assert.Equal(
+		`file "a.txt" is missing`,
+		VerifyMerklePayload(payload, testFiles(map[string]string{"b.bin": "bbb"})).Error())
+	assert.Equal(
+		`file "b.bin" is missing`,
+		VerifyMerklePayload(payload, testFiles(map[string]string{"a.txt": "aaa"})).Error())
+	assert.Equal(
+		`file "0.txt" is not in the MerklePayload`,
+		VerifyMerklePayload(payload, testFiles(map[string]string{"0.txt": "", "a.txt": "aaa", "b.bin": "bbb"})).Error())
+	assert.Equal(
+		`file "c.txt" is not in the MerklePayload`,
+		VerifyMerklePayload(payload, testFiles(map[string]string{"a.txt": "aaa", "b.bin": "bbb", "c.txt": ""})).Error())
+
+	_, err = MerklePayload(nil)
+	assert.Equal("no files for MerklePayload", err.Error())
+	_, err = MerklePayload(map[string]io.Reader{"a.txt": nil})
+	assert.Equal(`file "a.txt" has a nil reader`, err.Error())
+	assert.Equal(
+		"error decoding MerklePayload entry 0 as 2-item array",
+		VerifyMerklePayload([]byte("\x81\x80"), testFiles(contents)).Error())
+	assert.Equal(
+		"error decoding MerklePayload entry 0 name as tstr; got int64",
+		VerifyMerklePayload([]byte("\x81\x82\x01\x40"), testFiles(contents)).Error())
+	assert.NotNil(VerifyMerklePayload([]byte("payload"), testFiles(contents)))
+}
@@ -0,0 +1,86 @@
+package cose
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ContentFormatCOSESign is the CoAP Content-Format for
+// application/cose; cose-type="cose-sign" to use as the content type
+// header of a message with a COSE_Sign payload
+//
+// https://tools.ietf.org/html/rfc8152#section-16.10
+const ContentFormatCOSESign = 98
+
+// mediaTypeCOSESign is the media type for ContentFormatCOSESign
+const mediaTypeCOSESign = `application/cose; cose-type="cose-sign"`
+
+// MaxNestingDepth is the maximum number of COSE_Sign messages
+// DecodeNested decodes
+var MaxNestingDepth = 8
+
+// SetNestedPayload sets the message Payload to the encoded nested
+// message and its protected content type header to
+// ContentFormatCOSESign, so the message signs the nested message
+//
+// The nested message should be signed first since the payload bytes
+// are not updated when it changes.
+func (m *SignMessage) SetNestedPayload(nested *SignMessage) (err error) {
+	if m.Headers == nil {
+		return errors.New("SignMessage has nil Headers")
+	}
+	payload, err := Marshal(nested)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling nested SignMessage")
+	}
+	if m.Headers.Protected == nil {
+		m.Headers.Protected = map[interface{}]interface{}{}
+	}
+	delete(m.Headers.Protected, "content type")
+	m.Headers.Protected[CommonHeaderIDContentType] = ContentFormatCOSESign
+	m.Payload = payload
+	return nil
+}
+
+// hasNestedPayload returns true when the content type header of m is
+// a COSE_Sign message
+func (m *SignMessage) hasNestedPayload() bool {
+	contentType, ok := getCommonHeader(m.Headers, "content type")
+	if !ok {
+		return false
+	}
+	if i, ok := intFromInteger(contentType); ok {
+		return i == ContentFormatCOSESign
+	}
+	return contentType == mediaTypeCOSESign
+}
+
+// DecodeNested decodes a COSE_Sign message from data and the
+// COSE_Sign messages nested in its payload returning them outermost
+// first
+//
+// A payload is decoded when the content type header is
+// ContentFormatCOSESign or its media type. Messages are decoded with
+// DecodeOptions Strict. Signatures are not verified, so Verify each
+// returned message before trusting the message it is nested in. It
+// returns an error for more than MaxNestingDepth messages.
+func DecodeNested(data []byte) (messages []*SignMessage, err error) {
+	for {
+		if len(messages) >= MaxNestingDepth {
+			return nil, errors.Errorf("more than %d nested COSE_Sign messages", MaxNestingDepth)
+		}
+		decoded, err := UnmarshalWithOptions(data, DecodeOptions{Strict: true})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decoding nested COSE_Sign message %d", len(messages))
+		}
+		m, ok := decoded.(SignMessage)
+		if !ok {
+			return nil, errors.Errorf("error decoding nested COSE_Sign message %d; got %T", len(messages), decoded)
+		}
+		messages = append(messages, &m)
+
+		if m.Payload == nil || !m.hasNestedPayload() {
+			return messages, nil
+		}
+		data = m.Payload
+	}
+}
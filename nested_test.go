@@ -0,0 +1,98 @@
+package cose
+
+import (
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSignVerifyNested(t *testing.T) {
+	assert := assert.New(t)
+
+	innerSigner, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	outerSigner, err := NewSigner(ES384, nil)
+	assert.Nil(err, "Error creating signer")
+
+	// binary payloads are not changed by nesting
+	payload := []byte("\x00\xff\xd8\x62binary\x00")
+	inner, err := SignMulti(rand.Reader, payload, nil, []Signer{*innerSigner})
+	assert.Nil(err)
+
+	outer := NewSignMessage()
+	outer.Headers.Protected["content type"] = "text/plain"
+	assert.Nil(outer.SetNestedPayload(inner))
+	assert.Equal(map[interface{}]interface{}{CommonHeaderIDContentType: ContentFormatCOSESign}, outer.Headers.Protected)
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES384.Value
+	outer.AddSignature(sig)
+	assert.Nil(outer.Sign(rand.Reader, nil, []Signer{*outerSigner}))
+
+	outerBytes, err := Marshal(outer)
+	assert.Nil(err)
+	messages, err := DecodeNested(outerBytes)
+	assert.Nil(err)
+	assert.Len(messages, 2)
+	assert.Nil(messages[0].Verify(nil, []Verifier{*outerSigner.Verifier()}))
+	assert.Nil(messages[1].Verify(nil, []Verifier{*innerSigner.Verifier()}))
+	assert.Equal(payload, messages[1].Payload)
+
+	// the media type is also a nested content type
+	outer.Headers.Protected[CommonHeaderIDContentType] = mediaTypeCOSESign
+	outerBytes, err = Marshal(outer)
+	assert.Nil(err)
+	messages, err = DecodeNested(outerBytes)
+	assert.Nil(err)
+	assert.Len(messages, 2)
+
+	// other content types are not decoded
+	outer.Headers.Protected[CommonHeaderIDContentType] = 60 // application/cbor
+	outerBytes, err = Marshal(outer)
+	assert.Nil(err)
+	messages, err = DecodeNested(outerBytes)
+	assert.Nil(err)
+	assert.Len(messages, 1)
+
+	outer.Headers.Protected[CommonHeaderIDContentType] = ContentFormatCOSESign
+	outer.Payload = []byte("not COSE")
+	outerBytes, err = Marshal(outer)
+	assert.Nil(err)
+	_, err = DecodeNested(outerBytes)
+	assert.Contains(err.Error(), "error decoding nested COSE_Sign message 1")
+}
+
+func TestDecodeNestedMaxDepth(t *testing.T) {
+	assert := assert.New(t)
+
+	// the signatures are not verified, so they only need bytes
+	unverified := func() *Signature {
+		sig := NewSignature()
+		sig.SignatureBytes = []byte("unverified")
+		return sig
+	}
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	msg.AddSignature(unverified())
+	for i := 1; i < MaxNestingDepth; i++ {
+		outer := NewSignMessage()
+		assert.Nil(outer.SetNestedPayload(msg))
+		outer.AddSignature(unverified())
+		msg = outer
+	}
+	data, err := Marshal(msg)
+	assert.Nil(err)
+	messages, err := DecodeNested(data)
+	assert.Nil(err)
+	assert.Len(messages, MaxNestingDepth)
+
+	outer := NewSignMessage()
+	assert.Nil(outer.SetNestedPayload(msg))
+	outer.AddSignature(unverified())
+	data, err = Marshal(outer)
+	assert.Nil(err)
+	_, err = DecodeNested(data)
+	assert.Equal("more than 8 nested COSE_Sign messages", err.Error())
+
+	assert.Equal("SignMessage has nil Headers", (&SignMessage{}).SetNestedPayload(msg).Error())
+}
@@ -0,0 +1,165 @@
+package cose
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// RegistryAlgorithm is an algorithm definition in a registry document
+// for LoadAlgorithmRegistry
+type RegistryAlgorithm struct {
+	// Name and Value are the IANA algorithm name and value
+	Name  string `json:"name"`
+	Value int    `json:"value"`
+
+	// Hash is the IANA name of the hash function the algorithm uses
+	// e.g. SHA-256 or "" for none
+	Hash string `json:"hash,omitempty"`
+
+	// Kind is RegistryKindHash for a hash algorithm e.g. for an x5t
+	// hashAlg or RegistryKindSignature or "" for other algorithms,
+	// which are never used as hash algorithms
+	Kind string `json:"kind,omitempty"`
+
+	// KeyType must be empty. Algorithms that sign or verify need an
+	// implementation in this package and cannot be loaded.
+	KeyType string `json:"key_type,omitempty"`
+}
+
+// RegistryAlgorithm kinds
+const (
+	RegistryKindHash      = "hash"
+	RegistryKindSignature = "signature"
+)
+
+// registryHashFuncs are the hash functions by IANA name that registry
+// algorithms may use
+var registryHashFuncs = map[string]crypto.Hash{
+	"SHA-256": crypto.SHA256,
+	"SHA-384": crypto.SHA384,
+	"SHA-512": crypto.SHA512,
+}
+
+// RegisterAlgorithm adds algs to the algorithm table e.g. to recognize
+// newly registered IANA algorithm values when decoding and validating
+// headers
+//
+// Registered algorithms cannot sign or verify since that requires an
+// implementation in this package and are not hash algorithms e.g. for
+// an x5t hashAlg unless loaded as one. Algorithms already in the table
+// are skipped and ones with the name or value of another algorithm
+// are errors. Nothing is added when there is an error.
+//
+// It is safe to call concurrently with signing and verifying.
+func RegisterAlgorithm(algs ...Algorithm) (err error) {
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+
+	var added []Algorithm
+	names := map[string]bool{}
+	values := map[int]bool{}
+	for _, alg := range algs {
+		if alg.Name == "" {
+			return errors.Errorf("algorithm with value %d has no name", alg.Value)
+		}
+		if i, ok := algorithmIndexByValue[alg.Value]; ok {
+			existing := algorithms[i]
+			if existing.Name == alg.Name && existing.HashFunc == alg.HashFunc && existing.isHash == alg.isHash {
+				continue
+			}
+			return errors.Errorf("algorithm %s value %d conflicts with algorithm %s", alg.Name, alg.Value, existing.Name)
+		}
+		if _, ok := algorithmIndexByName[alg.Name]; ok || names[alg.Name] {
+			return errors.Errorf("algorithm %s conflicts with the name of another algorithm", alg.Name)
+		}
+		if values[alg.Value] {
+			return errors.Errorf("algorithm %s value %d conflicts with another algorithm", alg.Name, alg.Value)
+		}
+		names[alg.Name] = true
+		values[alg.Value] = true
+		added = append(added, Algorithm{Name: alg.Name, Value: alg.Value, HashFunc: alg.HashFunc, isHash: alg.isHash})
+	}
+
+	if len(added) > 0 {
+		// copy instead of appending in place for algorithmTable
+		// callers iterating the old table
+		algorithms = append(algorithms[:len(algorithms):len(algorithms)], added...)
+		algorithmIndexByName = indexAlgorithms(func(alg Algorithm) interface{} { return alg.Name })
+		algorithmIndexByValue = indexAlgorithms(func(alg Algorithm) interface{} { return alg.Value })
+	}
+	return nil
+}
+
+// LoadAlgorithmRegistry reads a JSON or CBOR array of
+// RegistryAlgorithm definitions from r and adds them to the algorithm
+// table with RegisterAlgorithm
+//
+// Definitions with a KeyType are rejected since loaded algorithms
+// cannot sign or verify. Nothing is added when there is an error.
+func LoadAlgorithmRegistry(r io.Reader) (err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "error reading algorithm registry")
+	}
+
+	var definitions []RegistryAlgorithm
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &definitions)
+	} else {
+		err = decMode.Unmarshal(data, &definitions)
+	}
+	if err != nil {
+		return errors.Wrap(err, "error decoding algorithm registry")
+	}
+
+	var algs []Algorithm
+	for i, definition := range definitions {
+		alg, err := definition.algorithm()
+		if err != nil {
+			return errors.Wrapf(err, "algorithm registry entry %d", i)
+		}
+		algs = append(algs, alg)
+	}
+	err = RegisterAlgorithm(algs...)
+	if err != nil {
+		return errors.Wrap(err, "algorithm registry")
+	}
+	return nil
+}
+
+// algorithm returns the Algorithm for a registry definition
+func (definition RegistryAlgorithm) algorithm() (alg Algorithm, err error) {
+	if definition.Name == "" {
+		return Algorithm{}, errors.New("algorithm has no name")
+	}
+	if definition.KeyType != "" {
+		return Algorithm{}, errors.Errorf("algorithm %s key type %s requires an implementation and cannot be loaded from a registry", definition.Name, definition.KeyType)
+	}
+	alg = Algorithm{
+		Name:  definition.Name,
+		Value: definition.Value,
+	}
+	if definition.Hash != "" {
+		hashFunc, ok := registryHashFuncs[definition.Hash]
+		if !ok {
+			return Algorithm{}, errors.Errorf("algorithm %s has unknown hash %s", definition.Name, definition.Hash)
+		}
+		alg.HashFunc = hashFunc
+	}
+	switch definition.Kind {
+	case RegistryKindHash:
+		if alg.HashFunc == 0 {
+			return Algorithm{}, errors.Errorf("hash algorithm %s has no hash", definition.Name)
+		}
+		alg.isHash = true
+	case RegistryKindSignature, "":
+	default:
+		return Algorithm{}, errors.Errorf("algorithm %s has unknown kind %s", definition.Name, definition.Kind)
+	}
+	return alg, nil
+}
@@ -0,0 +1,156 @@
+package cose
+
+import (
+	"crypto"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// restoreAlgorithms returns a func that restores the algorithm table
+// and aliases after a test registers algorithms or aliases
+func restoreAlgorithms() func() {
+	saved := append([]Algorithm{}, algorithms...)
+	byName, byValue := algorithmIndexByName, algorithmIndexByValue
+	aliases := map[string]string{}
+	for alias, name := range algorithmAliases {
+		aliases[alias] = name
+	}
+	return func() {
+		algorithms = saved
+		algorithmIndexByName, algorithmIndexByValue = byName, byValue
+		algorithmAliases = aliases
+	}
+}
+
+func TestLoadAlgorithmRegistry(t *testing.T) {
+	assert := assert.New(t)
+	defer restoreAlgorithms()()
+
+	registry := `[
+		{"name": "ES256", "value": -7},
+		{"name": "SHA-256", "value": -16, "hash": "SHA-256", "kind": "hash"},
+		{"name": "NEW-SIG", "value": -65000},
+		{"name": "NEW-HASH", "value": -65001, "hash": "SHA-384", "kind": "hash"},
+		{"name": "NEW-SIG-SHA256", "value": -65003, "hash": "SHA-256", "kind": "signature"}
+	]`
+	assert.Equal("algorithm registry: algorithm ES256 value -7 conflicts with algorithm ES256", LoadAlgorithmRegistry(strings.NewReader(registry)).Error())
+
+	registry = strings.Replace(registry, `{"name": "ES256", "value": -7},`, "", 1)
+	assert.Nil(LoadAlgorithmRegistry(strings.NewReader(registry)))
+
+	alg, err := AlgorithmInfo(-65000)
+	assert.Nil(err)
+	assert.Equal("NEW-SIG", alg.Name)
+	assert.Equal(KeyTypeUnsupported, alg.KeyType())
+	alg, err = AlgorithmInfo(-65001)
+	assert.Nil(err)
+	assert.Equal(crypto.SHA384, alg.HashFunc)
+	assert.True(isHashAlgorithm(&alg))
+	alg, err = AlgorithmInfo(-16)
+	assert.Nil(err)
+	assert.Equal("SHA-256", alg.Name)
+
+	// a signature algorithm with a hash is not a hash algorithm
+	alg, err = AlgorithmInfo(-65003)
+	assert.Nil(err)
+	assert.Equal(crypto.SHA256, alg.HashFunc)
+	assert.False(isHashAlgorithm(&alg))
+	_, _, err = decodeCertHash([]interface{}{-65003, []byte("thumbprint")})
+	assert.Equal("COSE_CertHash hashAlg NEW-SIG-SHA256 is not a hash algorithm", err.Error())
+	_, _, leaf, _ := testCertChain(t)
+	_, err = certThumbprint(&alg, leaf)
+	assert.Equal("x5t hashAlg is not a hash algorithm", err.Error())
+
+	// loaded algorithms are recognized in headers but cannot sign
+	h := &Headers{Protected: map[interface{}]interface{}{"alg": "NEW-SIG"}}
+	assert.Nil(h.Compress())
+	assert.Equal(map[interface{}]interface{}{CommonHeaderIDAlg: -65000}, h.Protected)
+	found, err := getAlg(h)
+	assert.Nil(err)
+	assert.Equal("NEW-SIG", found.Name)
+	_, err = NewSigner(found, nil)
+	assert.NotNil(err)
+
+	// loading again is a no-op
+	n := len(algorithms)
+	assert.Nil(LoadAlgorithmRegistry(strings.NewReader(registry)))
+	assert.Len(algorithms, n)
+
+	// CBOR registry
+	cborRegistry, err := Marshal([]map[string]interface{}{{"name": "CBOR-ALG", "value": -65002}})
+	assert.Nil(err)
+	assert.Nil(LoadAlgorithmRegistry(strings.NewReader(string(cborRegistry))))
+	alg, err = AlgorithmInfo(-65002)
+	assert.Nil(err)
+	assert.Equal("CBOR-ALG", alg.Name)
+
+	var tests = []struct {
+		registry string
+		err      string
+	}{
+		{`[{"name": "SIGNER", "value": -65100, "key_type": "EC2"}]`, "algorithm registry entry 0: algorithm SIGNER key type EC2 requires an implementation and cannot be loaded from a registry"},
+		{`[{"name": "MD5", "value": -65100, "hash": "MD5"}]`, "algorithm registry entry 0: algorithm MD5 has unknown hash MD5"},
+		{`[{"value": -65100}]`, "algorithm registry entry 0: algorithm has no name"},
+		{`[{"name": "ES256", "value": -65100}]`, "algorithm registry: algorithm ES256 conflicts with the name of another algorithm"},
+		{`[{"name": "A", "value": -65100}, {"name": "A", "value": -65101}]`, "algorithm registry: algorithm A conflicts with the name of another algorithm"},
+		{`[{"name": "A", "value": -65100}, {"name": "B", "value": -65100}]`, "algorithm registry: algorithm B value -65100 conflicts with another algorithm"},
+		{`[{"name": "A", "value": -65100, "kind": "hash"}]`, "algorithm registry entry 0: hash algorithm A has no hash"},
+		{`[{"name": "A", "value": -65100, "kind": "mac"}]`, "algorithm registry entry 0: algorithm A has unknown kind mac"},
+		{`[{"name": 1}]`, "error decoding algorithm registry: json: cannot unmarshal number"},
+	}
+	for _, test := range tests {
+		n := len(algorithms)
+		err := LoadAlgorithmRegistry(strings.NewReader(test.registry))
+		assert.True(strings.HasPrefix(err.Error(), test.err), err.Error())
+		assert.Len(algorithms, n, "nothing is added on error")
+	}
+	_, err = AlgorithmInfo(-65100)
+	assert.NotNil(err)
+}
+
+func TestRegisterAlgorithm(t *testing.T) {
+	assert := assert.New(t)
+	defer restoreAlgorithms()()
+
+	n := len(algorithms)
+	es256, err := AlgorithmInfo(ES256.Value)
+	assert.Nil(err)
+	assert.Nil(RegisterAlgorithm(es256))
+	assert.Len(algorithms, n, "existing algorithms are skipped")
+
+	assert.Equal("algorithm with value -65200 has no name", RegisterAlgorithm(Algorithm{Value: -65200}).Error())
+	assert.Equal("algorithm NEW value -7 conflicts with algorithm ES256", RegisterAlgorithm(Algorithm{Name: "NEW", Value: -7}).Error())
+	assert.Len(algorithms, n)
+
+	// registering is safe while verifying
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	msg := signedToken(t, signer, "payload")
+	verifiers := []Verifier{*signer.Verifier()}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(RegisterAlgorithm(Algorithm{Name: "NEW-" + string(rune('A'+i)), Value: -65200 - i}))
+		}(i)
+		go func() {
+			defer wg.Done()
+			assert.Nil(msg.Verify(nil, verifiers))
+			_, err := AlgorithmInfoByName("es256", AlgorithmNameOpts{CaseInsensitive: true})
+			assert.Nil(err)
+		}()
+	}
+	wg.Wait()
+	assert.Len(algorithms, n+8)
+
+	alg, err := AlgorithmInfo(-65203)
+	assert.Nil(err)
+	assert.Equal("NEW-D", alg.Name)
+	assert.Equal(KeyTypeUnsupported, alg.KeyType())
+	_, err = NewSigner(&alg, nil)
+	assert.NotNil(err)
+}
@@ -3,8 +3,12 @@ package cose
 import (
 	"bytes"
 	"crypto"
+	"crypto/x509"
 	"fmt"
 	"io"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
 	"github.com/pkg/errors"
 )
 
@@ -66,6 +70,59 @@ func (s *Signature) Decode(o interface{}) {
 	s.SignatureBytes = signatureBytes
 }
 
+// DecodeSignature returns the Signature for a standalone CBOR encoded
+// COSE_Signature e.g. a signature extracted from a message to inspect
+// or add to another message
+//
+// Unlike Signature.Decode it returns an error instead of panicking for
+// malformed data and ErrTrailingData for bytes after the
+// COSE_Signature. It returns an error wrapping ErrWrongMessageType
+// for a tagged COSE message or an untagged COSE_Sign or COSE_Sign1.
+// The MaxHeaderMapPairs and MaxHeaderDepth limits apply to its headers.
+func DecodeSignature(data []byte) (s *Signature, err error) {
+	if len(data) > 0 && data[0]>>5 == cborMajorTypeTag {
+		number, _, _ := cborHeadArgument(data)
+		if t, ok := coseMessageTags[number]; ok {
+			return nil, errors.Wrapf(ErrWrongMessageType, "expected COSE_Signature; got %s (tag %d)", t, number)
+		}
+	}
+	var items []cbor.RawMessage
+	decoder := decMode.NewDecoder(bytes.NewReader(data))
+	err = decoder.Decode(&items)
+	if isMaxNestedLevelError(err) {
+		return nil, ErrMaxDepthExceeded
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error decoding COSE_Signature")
+	}
+	if decoder.NumBytesRead() != len(data) {
+		return nil, ErrTrailingData
+	}
+	if t := cborArrayMessageType(data); len(items) == 4 && t != coseMessageUnknown {
+		return nil, errors.Wrapf(ErrWrongMessageType, "expected COSE_Signature; got untagged %s", t)
+	}
+	if len(items) != 3 {
+		return nil, errors.Errorf("can only decode COSE_Signature with 3 items; got %d", len(items))
+	}
+	err = checkHeadersLimit(items[0], items[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var raw signature
+	err = decMode.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding COSE_Signature")
+	}
+	s = NewSignature()
+	err = s.Headers.Decode([]interface{}{raw.Protected, raw.Unprotected})
+	if err != nil {
+		return nil, err
+	}
+	keepNonCanonicalProtected(s.Headers, raw.Protected)
+	s.SignatureBytes = raw.SignatureBytes
+	return s, nil
+}
+
 // SignMessage represents a COSESignMessage with CDDL fragment:
 //
 // COSE_Sign = [
@@ -75,6 +132,10 @@ func (s *Signature) Decode(o interface{}) {
 // ]
 //
 // https://tools.ietf.org/html/rfc8152#section-4.1
+//
+// SignMessage also implements json.Marshaler and json.Unmarshaler for
+// debugging and inspection. The JSON is not the COSE serialization;
+// use Marshal and Unmarshal for the CBOR wire format.
 type SignMessage struct {
 	Headers    *Headers
 	Payload    []byte
@@ -103,6 +164,31 @@ func (m *SignMessage) AddSignature(s *Signature) {
 	m.Signatures = append(m.Signatures, *s)
 }
 
+// SetSignatureKeyID sets the unprotected kid header of the signature
+// at index e.g. when its key is republished under a new identifier
+//
+// The unprotected headers are not signed, so the signature stays
+// valid. It returns an error when the kid is a protected header.
+func (m *SignMessage) SetSignatureKeyID(index int, kid []byte) (err error) {
+	if m == nil || index < 0 || index >= len(m.Signatures) {
+		return errors.Errorf("SignMessage has no signature %d", index)
+	}
+	h := m.Signatures[index].Headers
+	if h == nil {
+		return ErrNilSigHeader
+	}
+	if _, ok := findHeader(h.Protected, "kid"); ok {
+		return errors.Errorf("SignMessage signature %d has a protected kid that cannot change without re-signing", index)
+	}
+
+	if h.Unprotected == nil {
+		h.Unprotected = map[interface{}]interface{}{}
+	}
+	delete(h.Unprotected, "kid")
+	h.Unprotected[CommonHeaderIDKeyID] = kid
+	return nil
+}
+
 // SigStructure returns the byte slice to be signed
 func (m *SignMessage) SigStructure(external []byte, signature *Signature) (ToBeSigned []byte, err error) {
 	// 1.  Create a Sig_structure and populate it with the appropriate fields.
@@ -156,9 +242,73 @@ func (m *SignMessage) signatureDigest(external []byte, signature *Signature, has
 // Signing and Verification Process
 // https://tools.ietf.org/html/rfc8152#section-4.4
 
+// SignOpts are options for SignWithOpts
+type SignOpts struct {
+	// OverwriteSignatures replaces existing signature bytes instead of
+	// returning an error
+	OverwriteSignatures bool
+
+	// AutoKID sets the unprotected kid header of signatures without a
+	// kid to the KeyThumbprint of their signer's public key, so
+	// verifiers can be looked up by thumbprint
+	AutoKID bool
+
+	// PayloadCanonicalizer signs the canonical form of the payload
+	// instead of the payload. Verifiers must use the same
+	// PayloadCanonicalizer in VerifyOpts. The message Payload is not
+	// modified.
+	PayloadCanonicalizer PayloadCanonicalizer
+}
+
+// PayloadCanonicalizer returns the canonical form of a payload to sign
+// or verify e.g. the JCS (RFC 8785) form of a JSON payload, so
+// semantically equal payloads have equal signatures. A nil
+// PayloadCanonicalizer uses the payload as is.
+type PayloadCanonicalizer func(payload []byte) (canonical []byte, err error)
+
+// withCanonicalPayload returns m or a copy of m with its Payload
+// replaced by the canonical form from canonicalize
+//
+// The copy shares the Signatures of m.
+func (m *SignMessage) withCanonicalPayload(canonicalize PayloadCanonicalizer) (*SignMessage, error) {
+	if canonicalize == nil || m.Payload == nil {
+		return m, nil
+	}
+	payload, err := canonicalize(m.Payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "error canonicalizing payload")
+	}
+	if payload == nil {
+		return nil, errors.New("PayloadCanonicalizer returned a nil payload")
+	}
+	canonical := *m
+	canonical.Payload = payload
+	return &canonical, nil
+}
+
+// ClearSignatures sets the signature bytes of each message signature
+// to nil keeping their headers, so the message can be signed again
+// e.g. after rotating keys
+func (m *SignMessage) ClearSignatures() {
+	if m == nil {
+		return
+	}
+	for i := range m.Signatures {
+		m.Signatures[i].SignatureBytes = nil
+	}
+}
+
 // Sign signs a SignMessage i.e. it populates
 // signatures[].SignatureBytes using the provided array of Signers
+//
+// It returns an error when a signature already has signature bytes.
+// Use ClearSignatures or SignWithOpts to sign the message again.
 func (m *SignMessage) Sign(rand io.Reader, external []byte, signers []Signer) (err error) {
+	return m.SignWithOpts(rand, external, signers, SignOpts{})
+}
+
+// SignWithOpts signs a SignMessage like Sign with options
+func (m *SignMessage) SignWithOpts(rand io.Reader, external []byte, signers []Signer, opts SignOpts) (err error) {
 	if m.Signatures == nil {
 		return ErrNilSignatures
 	} else if len(m.Signatures) < 1 {
@@ -166,15 +316,46 @@ func (m *SignMessage) Sign(rand io.Reader, external []byte, signers []Signer) (e
 	} else if len(m.Signatures) != len(signers) {
 		return errors.Errorf("%d signers for %d signatures", len(signers), len(m.Signatures))
 	}
+	if m.Headers != nil {
+		err = m.Headers.Compress()
+		if err != nil {
+			return errors.Wrap(err, "SignMessage")
+		}
+		err = checkCritPresent(m.Headers)
+		if err != nil {
+			return errors.Wrap(err, "SignMessage")
+		}
+		err = checkRawProtected(m.Headers)
+		if err != nil {
+			return errors.Wrap(err, "SignMessage")
+		}
+	}
+	canonical, err := m.withCanonicalPayload(opts.PayloadCanonicalizer)
+	if err != nil {
+		return err
+	}
 
 	for i, signature := range m.Signatures {
 		if signature.Headers == nil {
 			return ErrNilSigHeader
 		} else if signature.Headers.Protected == nil {
 			return ErrNilSigProtectedHeaders
-		} else if signature.SignatureBytes != nil || len(signature.SignatureBytes) > 0 {
+		} else if (signature.SignatureBytes != nil || len(signature.SignatureBytes) > 0) && !opts.OverwriteSignatures {
 			return errors.Errorf("SignMessage signature %d already has signature bytes", i)
 		}
+		err = m.Signatures[i].Headers.Compress()
+		if err != nil {
+			return errors.Wrapf(err, "SignMessage signature %d", i)
+		}
+		err = checkCritPresent(m.Signatures[i].Headers)
+		if err != nil {
+			return errors.Wrapf(err, "SignMessage signature %d", i)
+		}
+		err = checkRawProtected(m.Signatures[i].Headers)
+		if err != nil {
+			return errors.Wrapf(err, "SignMessage signature %d", i)
+		}
+		signature = m.Signatures[i]
 
 		alg, err := getAlg(signature.Headers)
 		if err != nil {
@@ -184,7 +365,7 @@ func (m *SignMessage) Sign(rand io.Reader, external []byte, signers []Signer) (e
 			return ErrInvalidAlg
 		}
 
-		digest, err := m.signatureDigest(external, &signature, alg.HashFunc)
+		digest, err := canonical.signatureDigest(external, &signature, alg.HashFunc)
 		if err != nil {
 			return err
 		}
@@ -202,53 +383,864 @@ func (m *SignMessage) Sign(rand io.Reader, external []byte, signers []Signer) (e
 			return err
 		}
 
+		if opts.AutoKID {
+			if _, ok := getCommonHeader(signature.Headers, "kid"); !ok {
+				kid, err := KeyThumbprint(signer.Public())
+				if err != nil {
+					return errors.Wrapf(err, "error computing kid for signature %d", i)
+				}
+				if signature.Headers.Unprotected == nil {
+					m.Signatures[i].Headers.Unprotected = map[interface{}]interface{}{}
+				}
+				m.Signatures[i].Headers.Unprotected[CommonHeaderIDKeyID] = kid
+			}
+		}
+
 		// 4.  Place the resulting signature value in the 'signature' field of the array.
 		m.Signatures[i].SignatureBytes = signatureBytes
 	}
 	return nil
 }
 
+// VerifyOpts are options for VerifyWithOpts
+type VerifyOpts struct {
+	// IgnoreUnsupportedAlgs skips signatures with an algorithm this
+	// package cannot verify instead of failing. At least one signature
+	// must still verify.
+	IgnoreUnsupportedAlgs bool
+
+	// UnderstoodLabels are the header labels in addition to the
+	// common headers (e.g. alg, kid, and x5chain) that the
+	// application understands. VerifyWithOpts returns an error when
+	// the message or a signature has a crit header listing any other
+	// label.
+	UnderstoodLabels []interface{}
+
+	// FetchX5U returns the certificate chain for the URL of a
+	// signature protected x5u header. The signature is then verified
+	// with the leaf (first) certificate key instead of its verifier.
+	// FetchX5U is responsible for fetching the URL safely and
+	// validating the chain. An unprotected x5u header returns
+	// ErrX5UNotProtected. The x5u header is ignored when FetchX5U is
+	// nil, so URLs are never fetched by default.
+	FetchX5U func(url string) ([]*x509.Certificate, error)
+
+	// MinimalProtectedHeaders returns an error for a signature with
+	// protected headers other than alg for profiles that require all
+	// other signature headers to be unprotected
+	MinimalProtectedHeaders bool
+
+	// Cache skips verifying signatures it has from an earlier
+	// successful verification and remembers the signatures that
+	// verify. See VerificationCache for what a cache hit requires.
+	Cache VerificationCache
+
+	// PayloadCanonicalizer verifies signatures over the canonical form
+	// of the payload. It must be the PayloadCanonicalizer the signer
+	// used in SignOpts.
+	PayloadCanonicalizer PayloadCanonicalizer
+}
+
+// SignMulti returns a SignMessage for payload signed by each of the
+// signers e.g. with an established and a new algorithm while
+// verifiers migrate between them
+//
+// Each signature has a protected alg header for its signer.
+func SignMulti(rand io.Reader, payload, external []byte, signers []Signer) (m *SignMessage, err error) {
+	if len(signers) < 1 {
+		return nil, ErrNoSignerFound
+	}
+	m = NewSignMessage()
+	m.Payload = payload
+	for i, signer := range signers {
+		if signer.alg == nil {
+			return nil, errors.Errorf("Signer %d has no algorithm", i)
+		}
+		sig := NewSignature()
+		sig.Headers.Protected[CommonHeaderIDAlg] = signer.alg.Value
+		m.AddSignature(sig)
+	}
+	err = m.Sign(rand, external, signers)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SignEmpty returns a SignMessage with an empty payload and the
+// protected message headers signed by signer e.g. for a heartbeat or
+// proof of possession where the signed content is the protected
+// headers and external
+//
+// The payload is an empty bstr rather than nil (detached), so the
+// message encodes and verifies with its empty payload.
+func SignEmpty(rand io.Reader, external []byte, protected map[interface{}]interface{}, signer Signer) (m *SignMessage, err error) {
+	if signer.alg == nil {
+		return nil, errors.New("Signer has no algorithm")
+	}
+	m = NewSignMessage()
+	m.Payload = []byte{}
+	for k, v := range protected {
+		m.Headers.Protected[k] = v
+	}
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = signer.alg.Value
+	m.AddSignature(sig)
+
+	err = m.Sign(rand, external, []Signer{signer})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// BatchSign returns an encoded COSE_Sign message for each payload
+// signed by signer with a signature with the protected headers
+//
+// The protected headers are encoded once and shared by the messages,
+// so it is faster than signing each message. The signer alg is added
+// when protected has no alg header.
+func BatchSign(rand io.Reader, payloads [][]byte, protected map[interface{}]interface{}, signer Signer) (messages [][]byte, err error) {
+	if signer.alg == nil {
+		return nil, errors.New("Signer has no algorithm")
+	}
+	sigHeaders := &Headers{
+		Protected:   map[interface{}]interface{}{},
+		Unprotected: map[interface{}]interface{}{},
+	}
+	for k, v := range protected {
+		sigHeaders.Protected[k] = v
+	}
+	if _, ok := findHeader(sigHeaders.Protected, "alg"); !ok {
+		sigHeaders.Protected[CommonHeaderIDAlg] = signer.alg.Value
+	}
+	alg, err := getAlg(sigHeaders)
+	if err != nil {
+		return nil, err
+	}
+	if alg.Value > -1 { // Negative numbers are used for second layer objects (COSE_Signature and COSE_recipient)
+		return nil, ErrInvalidAlg
+	}
+	if alg.Value != signer.alg.Value {
+		return nil, errors.Errorf("Signer of type %s cannot generate a signature of type %s", signer.alg.Name, alg.Name)
+	}
+	if dup := FindDuplicateHeader(sigHeaders); dup != nil {
+		return nil, errors.Errorf("Duplicate header %+v found", dup)
+	}
+	sigHeaders.RawProtected, err = Marshal(CompressHeaders(sigHeaders.Protected))
+	if err != nil {
+		return nil, err
+	}
+
+	msgHeaders := &Headers{
+		Protected:   map[interface{}]interface{}{},
+		Unprotected: map[interface{}]interface{}{},
+	}
+	for _, payload := range payloads {
+		ToBeSigned, err := buildAndMarshalSigStructure(msgHeaders.EncodeProtected(), sigHeaders.RawProtected, nil, payload)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := hashSigStructure(ToBeSigned, alg.HashFunc)
+		if err != nil {
+			return nil, err
+		}
+		signatureBytes, err := signer.Sign(rand, digest)
+		if err != nil {
+			return nil, err
+		}
+
+		message, err := Marshal(&SignMessage{
+			Headers: msgHeaders,
+			Payload: payload,
+			Signatures: []Signature{{
+				Headers:        sigHeaders,
+				SignatureBytes: signatureBytes,
+			}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
 // Verify verifies all signatures on the SignMessage returning nil for
-// success or an error from the first failed verification
+// success or an error from the first failed verification without
+// verifying the remaining signatures
+//
+// A nil Payload (e.g. a detached payload that was not set after
+// decoding) returns ErrMissingPayload. Use an empty Payload to verify
+// signatures over empty content.
+//
+// A message without signatures returns ErrNoSignatures, since
+// COSE_Sign requires at least one and stripping them must not make a
+// message verify.
 func (m *SignMessage) Verify(external []byte, verifiers []Verifier) (err error) {
-	if m == nil || m.Signatures == nil || len(m.Signatures) < 1 {
-		return nil
+	if m == nil || len(m.Signatures) < 1 {
+		return ErrNoSignatures
+	}
+	if m.Payload == nil {
+		return ErrMissingPayload
 	}
 	if len(m.Signatures) != len(verifiers) {
 		return errors.Errorf("Wrong number of signatures %d and verifiers %d", len(m.Signatures), len(verifiers))
 	}
 
 	for i, signature := range m.Signatures {
-		if signature.Headers == nil {
-			return ErrNilSigHeader
-		} else if signature.Headers.Protected == nil {
-			return ErrNilSigProtectedHeaders
-		} else if signature.SignatureBytes == nil || len(signature.SignatureBytes) < 1 {
-			return errors.Errorf("SignMessage signature %d missing signature bytes to verify", i)
+		alg, err := signatureToVerifyAlg(i, &signature)
+		if err != nil {
+			return err
 		}
 
-		alg, err := getAlg(signature.Headers)
+		digest, err := m.signatureDigest(external, &signature, alg.HashFunc)
 		if err != nil {
 			return err
 		}
-		if alg.Value > -1 { // Negative numbers are used for second layer objects (COSE_Signature and COSE_recipient)
-			return ErrInvalidAlg
-		}
 
-		digest, err := m.signatureDigest(external, &signature, alg.HashFunc)
+		err = verifySignatureDigest(&verifiers[i], &signature, digest)
 		if err != nil {
+			if logger != nil {
+				logger.Warnf("SignMessage signature %d failed to verify: %s", i, err)
+			}
 			return err
 		}
+	}
+	return
+}
 
-		verifier := verifiers[i]
+// VerifyWithPayload verifies all signatures on the SignMessage like
+// Verify over expectedPayload e.g. a payload the client sent
+// separately from the signature
+//
+// It returns ErrPayloadMismatch when the message has an embedded
+// Payload that differs from expectedPayload. The message Payload is
+// not modified.
+func (m *SignMessage) VerifyWithPayload(expectedPayload, external []byte, verifiers []Verifier) (err error) {
+	if m == nil || len(m.Signatures) < 1 {
+		return ErrNoSignatures
+	}
+	if expectedPayload == nil {
+		return ErrMissingPayload
+	}
+	if m.Payload != nil && !bytes.Equal(m.Payload, expectedPayload) {
+		return ErrPayloadMismatch
+	}
 
-		// 3.  Call the signature creation algorithm passing in K (the key to
-		//     sign with), alg (the algorithm to sign with), and ToBeSigned (the
-		//     value to sign).
-		err = verifier.Verify(digest, signature.SignatureBytes)
+	withPayload := *m
+	withPayload.Payload = expectedPayload
+	return withPayload.Verify(external, verifiers)
+}
+
+// VerifyWithOpts verifies the signatures on the SignMessage like
+// Verify with options
+//
+// It returns ErrUnsupportedAlg for a signature with an algorithm that
+// is unknown or that this package cannot verify, so it can be told
+// apart from a bad signature. With opts.IgnoreUnsupportedAlgs those
+// signatures are skipped and their verifiers are not used, but
+// ErrUnsupportedAlg is still returned when no signature is supported.
+func (m *SignMessage) VerifyWithOpts(external []byte, verifiers []Verifier, opts VerifyOpts) (err error) {
+	_, err = m.verifyWithOpts(external, verifiers, opts)
+	return err
+}
+
+// verifyWithOpts verifies the signatures on the SignMessage like
+// VerifyWithOpts and returns the signatures that verified
+func (m *SignMessage) verifyWithOpts(external []byte, verifiers []Verifier, opts VerifyOpts) (verified []VerifiedSignature, err error) {
+	if m == nil || len(m.Signatures) < 1 {
+		return nil, ErrNoSignatures
+	}
+	if m.Payload == nil {
+		return nil, ErrMissingPayload
+	}
+	if len(m.Signatures) != len(verifiers) {
+		return nil, errors.Errorf("Wrong number of signatures %d and verifiers %d", len(m.Signatures), len(verifiers))
+	}
+
+	err = checkCritUnderstood(m.Headers, opts.UnderstoodLabels)
+	if err != nil {
+		return nil, err
+	}
+	canonical, err := m.withCanonicalPayload(opts.PayloadCanonicalizer)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, signature := range m.Signatures {
+		alg, err := signatureToVerifyAlg(i, &signature)
+		if (err == nil && alg.privateKeyType == KeyTypeUnsupported) || (err != nil && hasUnknownAlg(signature.Headers)) {
+			if opts.IgnoreUnsupportedAlgs {
+				if logger != nil {
+					logger.Debugf("skipping SignMessage signature %d with an unsupported alg", i)
+				}
+				continue
+			}
+			return nil, ErrUnsupportedAlg
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		err = checkCritUnderstood(signature.Headers, opts.UnderstoodLabels)
+		if err != nil {
+			return nil, err
+		}
+		if opts.MinimalProtectedHeaders {
+			for label := range signature.Headers.Protected {
+				if normalizeLabel(label) != CommonHeaderIDAlg {
+					return nil, errors.Errorf("SignMessage signature %d has protected header %v other than alg", i, label)
+				}
+			}
+		}
+
+		digest, err := canonical.signatureDigest(external, &signature, alg.HashFunc)
+		if err != nil {
+			return nil, err
+		}
+
+		verifier := &verifiers[i]
+		if opts.FetchX5U != nil {
+			verifier, err = x5uVerifier(signature.Headers, alg, opts.FetchX5U, verifier)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if opts.Cache != nil {
+			err = verifySignatureDigestCached(verifier, &signature, digest, opts.Cache)
+		} else {
+			err = verifySignatureDigest(verifier, &signature, digest)
+		}
+		if err != nil {
+			if logger != nil {
+				logger.Warnf("SignMessage signature %d failed to verify: %s", i, err)
+			}
+			return nil, err
+		}
+		verified = append(verified, newVerifiedSignature(i, alg, &signature, verifier))
+	}
+	if len(verified) < 1 {
+		return nil, ErrUnsupportedAlg
+	}
+	return verified, nil
+}
+
+// SignatureResult is the result of verifying the message signature at
+// Index. AlgID and KeyID are the signature alg and kid headers when
+// present. Err is nil when the signature verified.
+type SignatureResult struct {
+	Index int
+	AlgID int
+	KeyID []byte
+	Err   error
+}
+
+// VerifyAllOpts are options for VerifyAllWithOpts
+type VerifyAllOpts struct {
+	// StopOnFailure returns the results up to and including the
+	// first signature that fails to verify and that signature's error
+	// without verifying the remaining signatures e.g. when any failure
+	// rejects the message
+	StopOnFailure bool
+}
+
+// VerifyAll verifies each signature on the SignMessage with the
+// Verifier lookup returns for its kid and returns a result per
+// signature e.g. for an audit report
+//
+// err is ErrNoSignatureVerified when no signature verified or an
+// error when the message cannot be verified e.g. it has no payload.
+func (m *SignMessage) VerifyAll(external []byte, lookup VerifierLookup) (results []SignatureResult, err error) {
+	return m.VerifyAllWithOpts(external, lookup, VerifyAllOpts{})
+}
+
+// VerifyAllWithOpts verifies the signatures on the SignMessage like
+// VerifyAll with options
+func (m *SignMessage) VerifyAllWithOpts(external []byte, lookup VerifierLookup, opts VerifyAllOpts) (results []SignatureResult, err error) {
+	if m == nil || len(m.Signatures) < 1 {
+		return nil, ErrNoSignatureVerified
+	}
+	if m.Payload == nil {
+		return nil, ErrMissingPayload
+	}
+
+	verified := 0
+	for i := range m.Signatures {
+		result := m.verifySignatureWithLookup(i, external, lookup)
+		results = append(results, result)
+		if result.Err == nil {
+			verified++
+		} else if opts.StopOnFailure {
+			return results, result.Err
+		}
+	}
+	if verified < 1 {
+		return results, ErrNoSignatureVerified
+	}
+	return results, nil
+}
+
+func (m *SignMessage) verifySignatureWithLookup(i int, external []byte, lookup VerifierLookup) (result SignatureResult) {
+	signature := &m.Signatures[i]
+	result.Index = i
+	if signature.Headers != nil {
+		if value, ok := getCommonHeader(signature.Headers, "kid"); ok {
+			result.KeyID, _ = value.([]byte)
+		}
+	}
+
+	alg, err := signatureToVerifyAlg(i, signature)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.AlgID = alg.Value
+
+	verifier, err := lookup(result.KeyID)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if verifier == nil {
+		result.Err = ErrNoVerifierFound
+		return result
+	}
+	if verifier.Alg == nil {
+		result.Err = errors.Errorf("Verifier for kid %x has no Alg", result.KeyID)
+		return result
+	}
+	if alg.Value != verifier.Alg.Value {
+		result.Err = errors.Errorf("Verifier of type %s cannot verify a signature of type %s", verifier.Alg.Name, alg.Name)
+		return result
+	}
+
+	digest, err := m.signatureDigest(external, signature, alg.HashFunc)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Err = verifySignatureDigest(verifier, signature, digest)
+	return result
+}
+
+// checkCritUnderstood returns an error when the protected crit header
+// of h lists a label that is not a common header or in understood
+//
+// https://tools.ietf.org/html/rfc8152#section-3.1
+func checkCritUnderstood(h *Headers, understood []interface{}) (err error) {
+	if h == nil {
+		return nil
+	}
+	crit, ok := findHeader(h.Protected, "crit")
+	if !ok {
+		return nil
+	}
+	labels, ok := crit.([]interface{})
+	if !ok {
+		return errors.Errorf("error decoding crit header as array; got %T", crit)
+	}
+	for _, label := range labels {
+		if !isUnderstoodLabel(label, understood) {
+			return errors.Errorf("crit header label %v is not understood", label)
+		}
+	}
+	return nil
+}
+
+// checkCritPresent returns ErrCriticalHeaderMissing when the protected
+// crit header of h lists a label that is not a protected header, which
+// verifiers reject
+//
+// https://tools.ietf.org/html/rfc8152#section-3.1
+func checkCritPresent(h *Headers) (err error) {
+	crit, ok := findHeader(h.Protected, "crit")
+	if !ok {
+		return nil
+	}
+	labels, ok := crit.([]interface{})
+	if !ok {
+		return errors.Errorf("error decoding crit header as array; got %T", crit)
+	}
+	for _, label := range labels {
+		if _, ok := findHeader(h.Protected, label); !ok {
+			return errors.Wrapf(ErrCriticalHeaderMissing, "crit label %v", label)
+		}
+	}
+	return nil
+}
+
+// isUnderstoodLabel returns true when label is an int or tstr common
+// header label or in understood
+func isUnderstoodLabel(label interface{}, understood []interface{}) bool {
+	normalized := normalizeLabel(label)
+	switch l := normalized.(type) {
+	case int:
+		if _, err := GetCommonHeaderLabel(l); err == nil {
+			return true
+		}
+	case string:
+	default:
+		return false
+	}
+	for _, u := range understood {
+		if normalizeLabel(u) == normalized {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUnknownAlg returns true when h has a well-formed int or tstr alg
+// header that is not in the algorithms table
+//
+// Malformed alg headers e.g. floats are not unknown algs.
+func hasUnknownAlg(h *Headers) bool {
+	if h == nil {
+		return false
+	}
+	o, ok := findHeader(h.Protected, "alg")
+	if !ok {
+		return false
+	}
+	if value, ok := intFromInteger(o); ok {
+		_, err := getAlgByValue(value)
+		return err != nil
+	}
+	if name, ok := o.(string); ok {
+		_, err := getAlgByName(name)
+		return err != nil
+	}
+	return false
+}
+
+// signatureToVerifyAlg checks the signature at index i has headers
+// and signature bytes to verify and returns its alg
+func signatureToVerifyAlg(i int, signature *Signature) (alg *Algorithm, err error) {
+	if signature.Headers == nil {
+		return nil, ErrNilSigHeader
+	} else if signature.Headers.Protected == nil {
+		return nil, ErrNilSigProtectedHeaders
+	} else if signature.SignatureBytes == nil || len(signature.SignatureBytes) < 1 {
+		return nil, errors.Errorf("SignMessage signature %d missing signature bytes to verify", i)
+	}
+
+	alg, err = getAlg(signature.Headers)
+	if err != nil {
+		return nil, err
+	}
+	if alg.Value > -1 { // Negative numbers are used for second layer objects (COSE_Signature and COSE_recipient)
+		return nil, ErrInvalidAlg
+	}
+	return alg, nil
+}
+
+// verifySignatureDigest verifies the signature bytes over digest
+// with verifier after checking the verifier certificate thumbprint
+func verifySignatureDigest(verifier *Verifier, signature *Signature, digest []byte) (err error) {
+	if verifier.Certificate != nil {
+		err = verifyCertThumbprint(signature.Headers, verifier.Certificate)
 		if err != nil {
 			return err
 		}
 	}
-	return
+
+	// 3.  Call the signature creation algorithm passing in K (the key to
+	//     sign with), alg (the algorithm to sign with), and ToBeSigned (the
+	//     value to sign).
+	return verifier.Verify(digest, signature.SignatureBytes)
+}
+
+// CanVerify returns true when the alg header of signature matches the
+// verifier Alg and the verifier has a supported public key for it
+// without verifying the signature e.g. to pick a verifier from a pool
+func CanVerify(signature Signature, verifier Verifier) bool {
+	if signature.Headers == nil || verifier.Alg == nil {
+		return false
+	}
+	alg, err := getAlg(signature.Headers)
+	if err != nil || alg.Value != verifier.Alg.Value {
+		return false
+	}
+	_, err = NewVerifierFromPublicKey(alg.Name, verifier.PublicKey)
+	return err == nil
+}
+
+// VerifyAndExtract decodes a COSE_Sign message from data, verifies
+// all of its signatures and returns the payload and the protected
+// message headers.
+//
+// Only authenticated data is returned i.e. the returned Headers have
+// empty Unprotected headers.
+func VerifyAndExtract(data, external []byte, verifiers []Verifier) (payload []byte, headers *Headers, err error) {
+	var m SignMessage
+	err = m.UnmarshalCBOR(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(m.Signatures) < 1 {
+		return nil, nil, ErrNoSignatures
+	}
+
+	err = m.Verify(external, verifiers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headers = &Headers{
+		Protected:   m.Headers.Protected,
+		Unprotected: map[interface{}]interface{}{},
+	}
+	return m.Payload, headers, nil
+}
+
+// DecodeSignMessageDetached decodes a COSE_Sign message with a
+// detached (nil) payload from data with DecodeOptions opts and sets its
+// Payload to payload for verifying with Verify
+//
+// payload may be nil to read the payload later with VerifyStream e.g.
+// from a file. It returns ErrPayloadNotDetached when data includes a
+// payload.
+func DecodeSignMessageDetached(data, payload []byte, opts DecodeOptions) (m *SignMessage, err error) {
+	decoded, err := UnmarshalWithOptions(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	msg, ok := decoded.(SignMessage)
+	if !ok {
+		return nil, errors.Errorf("error decoding COSE_Sign message; got %T", decoded)
+	}
+	if msg.Payload != nil {
+		return nil, ErrPayloadNotDetached
+	}
+	msg.Payload = payload
+	return &msg, nil
+}
+
+// DecodePayload CBOR decodes the SignMessage Payload into v e.g. for a
+// CWT claims set or other payload with a CBOR content type
+//
+// The payload is not authenticated until Verify returns nil, so call
+// DecodePayload after verifying the message.
+func (m *SignMessage) DecodePayload(v interface{}) (err error) {
+	if m == nil || m.Payload == nil {
+		return ErrMissingPayload
+	}
+	return decMode.Unmarshal(m.Payload, v)
+}
+
+// StrictVerifyError lists the violations of the RFC 8152 message
+// structure that StrictVerify found
+type StrictVerifyError struct {
+	Violations []string
+}
+
+func (e *StrictVerifyError) Error() string {
+	return fmt.Sprintf("strict verification failed: %s", strings.Join(e.Violations, "; "))
+}
+
+// StrictVerify checks the SignMessage and its signatures follow RFC
+// 8152 before verifying them like Verify. It is meant for
+// conformance testing.
+//
+// In addition to the Verify checks, it requires that:
+//
+// * the message has a payload and at least one signature
+// * protected headers encode to a CBOR map
+// * no header label is repeated in or across the protected and
+//   unprotected headers
+// * each signature has a protected, second layer alg header
+// * crit is protected and only lists protected header labels
+//
+// It returns a *StrictVerifyError with all violations found before
+// any signature is verified.
+//
+// https://tools.ietf.org/html/rfc8152#section-4.4
+func (m *SignMessage) StrictVerify(external []byte, verifiers []Verifier) (err error) {
+	if m == nil {
+		return &StrictVerifyError{Violations: []string{"message is nil"}}
+	}
+	violations := m.strictViolations(verifiers)
+	if len(violations) > 0 {
+		return &StrictVerifyError{Violations: violations}
+	}
+	return m.Verify(external, verifiers)
+}
+
+func (m *SignMessage) strictViolations(verifiers []Verifier) (violations []string) {
+	add := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
+
+	if m.Headers == nil {
+		add("message headers are nil")
+	} else {
+		for _, v := range strictHeaderViolations(m.Headers) {
+			add("message %s", v)
+		}
+	}
+	if m.Payload == nil {
+		add("payload is nil")
+	}
+	if len(m.Signatures) < 1 {
+		add("message has no signatures")
+	} else if len(m.Signatures) != len(verifiers) {
+		add("%d signatures for %d verifiers", len(m.Signatures), len(verifiers))
+	}
+	return append(violations, m.signatureViolations()...)
+}
+
+// signatureViolations returns violations of the RFC 8152 structure of
+// the message signatures
+func (m *SignMessage) signatureViolations() (violations []string) {
+	add := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
+
+	for i, signature := range m.Signatures {
+		if signature.Headers == nil {
+			add("signature %d headers are nil", i)
+			continue
+		}
+		for _, v := range strictHeaderViolations(signature.Headers) {
+			add("signature %d %s", i, v)
+		}
+		if _, ok := findHeader(signature.Headers.Unprotected, "alg"); ok {
+			add("signature %d alg header is not protected", i)
+		}
+		alg, err := getAlg(signature.Headers)
+		if err != nil {
+			add("signature %d alg: %s", i, err)
+		} else if alg.Value > -1 { // Negative numbers are used for second layer objects (COSE_Signature and COSE_recipient)
+			add("signature %d alg %s is not a signature algorithm", i, alg.Name)
+		}
+		if len(signature.SignatureBytes) < 1 {
+			add("signature %d has no signature bytes", i)
+		}
+	}
+	return violations
+}
+
+// strictHeaderViolations returns violations of the RFC 8152 header
+// structure
+func strictHeaderViolations(h *Headers) (violations []string) {
+	add := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
+
+	seen := map[interface{}]string{}
+	duplicates := false
+	for _, bucket := range []string{"protected", "unprotected"} {
+		headers := h.Protected
+		if bucket == "unprotected" {
+			headers = h.Unprotected
+		}
+		for _, label := range sortedLabels(headers) {
+			normalized := normalizeLabel(label)
+			if other, ok := seen[normalized]; ok && other == bucket {
+				add("header %v is repeated in the %s headers", label, bucket)
+				duplicates = true
+			} else if ok {
+				add("header %v is in the protected and unprotected headers", label)
+				duplicates = true
+			}
+			seen[normalized] = bucket
+		}
+	}
+
+	if h.RawProtected != nil {
+		if len(h.RawProtected) > 0 {
+			protected, err := Unmarshal(h.RawProtected)
+			if _, ok := protected.(map[interface{}]interface{}); err != nil || !ok {
+				add("protected headers are not a CBOR map")
+			}
+		}
+	} else if !duplicates {
+		_, err := Marshal(CompressHeaders(h.Protected))
+		if err != nil {
+			add("protected headers do not encode to a CBOR map: %s", err)
+		}
+	}
+
+	if _, ok := findHeader(h.Unprotected, "crit"); ok {
+		add("crit header is not protected")
+	}
+	if crit, ok := findHeader(h.Protected, "crit"); ok {
+		labels, ok := crit.([]interface{})
+		if !ok || len(labels) < 1 {
+			add("crit header is not a non-empty array of labels")
+		}
+		for _, label := range labels {
+			if _, ok := findHeader(h.Protected, label); !ok {
+				add("crit label %v is not in the protected headers", label)
+			}
+		}
+	}
+	return violations
+}
+
+// normalizeLabel returns the int tag for a common header name or int
+// label and otherwise the label
+func normalizeLabel(label interface{}) interface{} {
+	if name, ok := label.(string); ok {
+		if tag, err := GetCommonHeaderTag(name); err == nil {
+			return tag
+		}
+	}
+	if i, ok := labelToInt64(label); ok {
+		return int(i)
+	}
+	return label
+}
+
+// findHeader returns the value for label in headers when it is
+// present under its common name or int tag
+func findHeader(headers map[interface{}]interface{}, label interface{}) (value interface{}, ok bool) {
+	normalized := normalizeLabel(label)
+	switch normalized.(type) {
+	case int, string:
+	default:
+		// labels are ints or tstrs and other values may not be
+		// comparable
+		return nil, false
+	}
+	for k, v := range headers {
+		if normalizeLabel(k) == normalized {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// ValidateStructure decodes data as a COSE_Sign message and checks
+// its structure without verifying its signatures e.g. to reject
+// malformed messages before routing them
+//
+// It checks the message decodes without trailing data, headers are
+// not repeated, crit is valid, and there is at least one signature
+// with a protected signature alg and signature bytes. A detached
+// (nil) payload is valid.
+func ValidateStructure(data []byte) (err error) {
+	decoded, err := UnmarshalWithOptions(data, DecodeOptions{Strict: true})
+	if err != nil {
+		return err
+	}
+	m, ok := decoded.(SignMessage)
+	if !ok {
+		return errors.Errorf("invalid COSE_Sign message: decoded to %T", decoded)
+	}
+
+	var violations []string
+	for _, v := range strictHeaderViolations(m.Headers) {
+		violations = append(violations, "message "+v)
+	}
+	if len(m.Signatures) < 1 {
+		violations = append(violations, "message has no signatures")
+	}
+	violations = append(violations, m.signatureViolations()...)
+	if len(violations) > 0 {
+		return errors.Errorf("invalid COSE_Sign message: %s", strings.Join(violations, "; "))
+	}
+	return nil
 }
@@ -29,7 +29,7 @@ func RustCoseVerifiesGoCoseSignatures(t *testing.T, testCase RustTestCase) {
 		Protected:   map[interface{}]interface{}{},
 		Unprotected: map[interface{}]interface{}{},
 	}
-	msgHeaders.Protected[kidTag] = testCase.Certs
+	msgHeaders.Protected[CommonHeaderIDKeyID] = testCase.Certs
 	message.Headers = msgHeaders
 	message.Payload = []byte(testCase.SignPayload)
 
@@ -43,8 +43,8 @@ func RustCoseVerifiesGoCoseSignatures(t *testing.T, testCase RustTestCase) {
 		verifiers = append(verifiers, *signer.Verifier())
 
 		sig := NewSignature()
-		sig.Headers.Protected[algTag] = param.algorithm.Value
-		sig.Headers.Protected[kidTag] = param.certificate
+		sig.Headers.Protected[CommonHeaderIDAlg] = param.algorithm.Value
+		sig.Headers.Protected[CommonHeaderIDKeyID] = param.certificate
 
 		message.AddSignature(sig)
 	}
@@ -100,3 +100,50 @@ func TestRustCoseCli(t *testing.T) {
 		})
 	}
 }
+
+// TestSigStructureMatchesCoseRustEncoding checks the Sig_structure
+// bytes for headers that sort differently under canonical (length
+// first) and bytewise key ordering. cose-rust encodes maps with the
+// RFC 7049 section 3.9 canonical ordering, so signatures only verify
+// across implementations when both produce these exact bytes.
+func TestSigStructureMatchesCoseRustEncoding(t *testing.T) {
+	assert := assert.New(t)
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	msg.Headers.Protected = map[interface{}]interface{}{
+		int64(256): 65536,
+		"a":        -65536,
+		-25:        0,
+		24:         int64(-257),
+		-1:         -256,
+		"kid":      []byte("kid"),
+		"alg":      "ES256",
+	}
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+
+	// keys sort by encoded length then bytewise: 01, 04, 20, 1818,
+	// 3818, 6161, 190100
+	bodyProtected := "A7" +
+		"01" + "26" + // alg: -7
+		"04" + "436B6964" + // kid: h'6B6964'
+		"20" + "38FF" + // -1: -256
+		"1818" + "390100" + // 24: -257
+		"3818" + "00" + // -25: 0
+		"6161" + "39FFFF" + // "a": -65536
+		"190100" + "1A00010000" // 256: 65536
+	assert.Equal(HexToBytesOrDie(bodyProtected), msg.Headers.EncodeProtected())
+
+	ToBeSigned, err := msg.SigStructure(nil, sig)
+	assert.Nil(err)
+	assert.Equal(
+		HexToBytesOrDie("85"+
+			"695369676E6174757265"+ // "Signature"
+			"5820"+bodyProtected+
+			"43A10126"+
+			"40"+
+			"477061796C6F6164"),
+		ToBeSigned)
+}
@@ -5,19 +5,26 @@ import (
 	"encoding/hex"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"os"
 	"strings"
 	"testing"
 )
 
 func WGExampleSignsAndVerifies(t *testing.T, example WGExample) {
 	assert := assert.New(t)
-	privateKey := LoadPrivateKey(&example)
 
 	// testcases only include one signature
 	assert.Equal(len(example.Input.Sign.Signers), 1)
 
 	signerInput := example.Input.Sign.Signers[0]
-	alg := getAlgByNameOrPanic(signerInput.Protected.Alg)
+	alg, err := getAlgByName(signerInput.Protected.Alg)
+	if err != nil || alg.KeyType() == KeyTypeUnsupported {
+		t.Skipf("%s: unimplemented algorithm %s", example.Title, signerInput.Protected.Alg)
+	}
+	if signerInput.Key.Kty != "EC2" {
+		t.Skipf("%s: unimplemented key type %s", example.Title, signerInput.Key.Kty)
+	}
+	privateKey := LoadPrivateKey(&example)
 	external := HexToBytesOrDie(signerInput.External)
 
 	decoded, err := Unmarshal(HexToBytesOrDie(example.Output.Cbor))
@@ -95,16 +102,29 @@ func ExpectCastToFail(title string) (shouldFail bool) {
 	return title == "sign-pass-03: Remove CBOR Tag" || title == "sign-fail-01: Wrong CBOR Tag"
 }
 
+// WGExampleDirs are the cose-wg/Examples directories to load examples
+// from. Examples for other message types than COSE_Sign are skipped.
+var WGExampleDirs = []string{
+	"./test/cose-wg-examples/sign-tests",
+	"./test/cose-wg-examples/ecdsa-examples",
+}
+
 func TestWGExamples(t *testing.T) {
-	examples := append(
-		LoadExamples("./test/cose-wg-examples/sign-tests"),
-		LoadExamples("./test/cose-wg-examples/ecdsa-examples")...,
-	)
+	var examples []WGExample
+	for _, dir := range WGExampleDirs {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			t.Skipf("cose-wg examples not found in %s. Run make install to clone them", dir)
+		}
+		examples = append(examples, LoadExamples(dir)...)
+	}
 
 	for _, example := range examples {
 		t.Run(fmt.Sprintf("Example: %s %v", example.Title, example.Fail), func(t *testing.T) {
 			if v, ok := SkipExampleTitles[example.Title]; ok && v {
-				return
+				t.Skipf("%s: skipped example", example.Title)
+			}
+			if len(example.Input.Sign.Signers) < 1 {
+				t.Skipf("%s: not a COSE_Sign example", example.Title)
 			}
 			WGExampleSignsAndVerifies(t, example)
 		})
@@ -1,12 +1,16 @@
 package cose
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"encoding/json"
 	"fmt"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
 )
 
@@ -20,8 +24,8 @@ func TestSignErrors(t *testing.T) {
 	assert.Nil(err, fmt.Sprintf("Error creating signer %s", err))
 
 	sig := NewSignature()
-	sig.Headers.Protected[algTag] = -41 // RSAES-OAEP w/ SHA-256 from [RFC8230]
-	sig.Headers.Protected[kidTag] = 1
+	sig.Headers.Protected[CommonHeaderIDAlg] = -41 // RSAES-OAEP w/ SHA-256 from [RFC8230]
+	sig.Headers.Protected[CommonHeaderIDKeyID] = 1
 
 	msg.Signatures = []Signature{}
 	err = msg.Sign(rand.Reader, []byte(""), []Signer{*signer})
@@ -47,8 +51,8 @@ func TestSignErrors(t *testing.T) {
 
 	msg.Signatures = nil
 	sig.Headers.Protected = map[interface{}]interface{}{}
-	sig.Headers.Protected[algTag] = -41 // RSAES-OAEP w/ SHA-256 from [RFC8230]
-	sig.Headers.Protected[kidTag] = 1
+	sig.Headers.Protected[CommonHeaderIDAlg] = -41 // RSAES-OAEP w/ SHA-256 from [RFC8230]
+	sig.Headers.Protected[CommonHeaderIDKeyID] = 1
 	sig.SignatureBytes = []byte("already signed")
 
 	msg.AddSignature(sig)
@@ -65,7 +69,7 @@ func TestSignErrors(t *testing.T) {
 	err = msg.Sign(rand.Reader, []byte(""), []Signer{*signer})
 	assert.Equal(ErrUnavailableHashFunc, err)
 
-	msg.Signatures[0].Headers.Protected[algTag] = ES256.Value
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDAlg] = ES256.Value
 	signer.alg = ES256
 	signer.PrivateKey = dsaPrivateKey
 	err = msg.Sign(rand.Reader, []byte(""), []Signer{*signer})
@@ -75,19 +79,201 @@ func TestSignErrors(t *testing.T) {
 	err = msg.Sign(rand.Reader, []byte(""), []Signer{*signer})
 	assert.Equal("Signer of type PS256 cannot generate a signature of type ES256", err.Error())
 
-	msg.Signatures[0].Headers.Protected[algTag] = -9000
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDAlg] = -9000
 	err = msg.Sign(rand.Reader, []byte(""), []Signer{*signer})
 	assert.Equal("Algorithm with value -9000 not found", err.Error())
 
-	msg.Signatures[0].Headers.Protected[algTag] = 1
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDAlg] = 1
 	err = msg.Sign(rand.Reader, []byte(""), []Signer{*signer})
 	assert.Equal(ErrInvalidAlg, err)
 
-	delete(msg.Signatures[0].Headers.Protected, algTag)
+	delete(msg.Signatures[0].Headers.Protected, CommonHeaderIDAlg)
 	err = msg.Sign(rand.Reader, []byte(""), []Signer{*signer})
 	assert.Equal(ErrAlgNotFound, err)
 }
 
+func TestSignMessageResign(t *testing.T) {
+	assert := assert.New(t)
+
+	oldSigner, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	newSigner, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	sig.Headers.Unprotected[CommonHeaderIDKeyID] = []byte("old")
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*oldSigner}))
+	assert.Equal("SignMessage signature 0 already has signature bytes", msg.Sign(rand.Reader, nil, []Signer{*newSigner}).Error())
+
+	msg.ClearSignatures()
+	assert.Nil(msg.Signatures[0].SignatureBytes)
+	assert.Equal([]byte("old"), msg.Signatures[0].Headers.Unprotected[CommonHeaderIDKeyID])
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*newSigner}))
+	assert.Nil(msg.Verify(nil, []Verifier{*newSigner.Verifier()}))
+
+	err = msg.SignWithOpts(rand.Reader, nil, []Signer{*oldSigner}, SignOpts{OverwriteSignatures: true})
+	assert.Nil(err)
+	assert.Nil(msg.Verify(nil, []Verifier{*oldSigner.Verifier()}))
+	assert.Equal(ErrECDSAVerification, msg.Verify(nil, []Verifier{*newSigner.Verifier()}))
+
+	var nilMsg *SignMessage
+	nilMsg.ClearSignatures()
+}
+
+func TestSignWithOptsAutoKID(t *testing.T) {
+	assert := assert.New(t)
+
+	ecSigner, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	rsaSigner, err := NewSigner(PS256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := map[string]*Verifier{}
+	for _, signer := range []*Signer{ecSigner, rsaSigner} {
+		thumbprint, err := KeyThumbprint(signer.Public())
+		assert.Nil(err)
+		verifiers[string(thumbprint)] = signer.Verifier()
+	}
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	for _, alg := range []*Algorithm{ES256, PS256, ES256} {
+		sig := NewSignature()
+		sig.Headers.Protected[CommonHeaderIDAlg] = alg.Value
+		msg.AddSignature(sig)
+	}
+	msg.Signatures[2].Headers.Protected["kid"] = []byte("explicit")
+	msg.Signatures[1].Headers.Unprotected = nil
+
+	signers := []Signer{*ecSigner, *rsaSigner, *ecSigner}
+	assert.Nil(msg.SignWithOpts(rand.Reader, nil, signers, SignOpts{AutoKID: true}))
+	assert.Equal([]byte("explicit"), msg.Signatures[2].Headers.Protected[CommonHeaderIDKeyID])
+	assert.Equal(map[interface{}]interface{}{}, msg.Signatures[2].Headers.Unprotected)
+
+	results, err := msg.VerifyAll(nil, func(kid []byte) (*Verifier, error) {
+		if verifier, ok := verifiers[string(kid)]; ok {
+			return verifier, nil
+		}
+		return nil, ErrNoVerifierFound
+	})
+	assert.Nil(err)
+	assert.Nil(results[0].Err)
+	assert.Nil(results[1].Err)
+	assert.Equal(ErrNoVerifierFound, errors.Cause(results[2].Err))
+
+	// signing without AutoKID does not set a kid
+	msg.Signatures[0].Headers.Unprotected = map[interface{}]interface{}{}
+	assert.Nil(msg.SignWithOpts(rand.Reader, nil, signers, SignOpts{OverwriteSignatures: true}))
+	assert.Equal(map[interface{}]interface{}{}, msg.Signatures[0].Headers.Unprotected)
+}
+
+func TestCanVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	es256, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	es384, err := NewSigner(ES384, nil)
+	assert.Nil(err, "Error creating signer")
+	ps256, err := NewSigner(PS256, nil)
+	assert.Nil(err, "Error creating signer")
+
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+
+	assert.True(CanVerify(*sig, *es256.Verifier()))
+	assert.False(CanVerify(*sig, *es384.Verifier()))
+	assert.False(CanVerify(*sig, *ps256.Verifier()))
+
+	// the verifier key must match its alg
+	mismatched := *es384.Verifier()
+	mismatched.Alg = ES256
+	assert.False(CanVerify(*sig, mismatched))
+	mismatched = *ps256.Verifier()
+	mismatched.Alg = ES256
+	assert.False(CanVerify(*sig, mismatched))
+
+	// the alg is from the protected headers
+	sig.Headers.Protected = map[interface{}]interface{}{}
+	sig.Headers.Unprotected[CommonHeaderIDAlg] = ES256.Value
+	assert.False(CanVerify(*sig, *es256.Verifier()))
+	sig.Headers.Protected[CommonHeaderIDAlg] = "PS256"
+	assert.True(CanVerify(*sig, *ps256.Verifier()))
+
+	assert.False(CanVerify(Signature{}, *es256.Verifier()))
+	assert.False(CanVerify(*sig, Verifier{}))
+}
+
+func TestSignEmpty(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+
+	protected := map[interface{}]interface{}{"kid": []byte("device-1"), -70000: uint64(1234)}
+	msg, err := SignEmpty(rand.Reader, []byte("session"), protected, *signer)
+	assert.Nil(err)
+	assert.Equal([]byte{}, msg.Payload)
+	assert.Nil(msg.Verify([]byte("session"), verifiers))
+	assert.Equal(ErrECDSAVerification, msg.Verify(nil, verifiers))
+
+	// the Sig_structure has an empty payload bstr
+	ToBeSigned, err := msg.SigStructure([]byte("session"), &msg.Signatures[0])
+	assert.Nil(err)
+	assert.Equal(byte(0x40), ToBeSigned[len(ToBeSigned)-1])
+
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+	decoded, err := Unmarshal(msgBytes)
+	assert.Nil(err)
+	decodedMsg := decoded.(SignMessage)
+	assert.NotNil(decodedMsg.Payload)
+	assert.Len(decodedMsg.Payload, 0)
+	assert.Nil(decodedMsg.Verify([]byte("session"), verifiers))
+	assert.Equal([]byte("device-1"), decodedMsg.Headers.Protected[CommonHeaderIDKeyID])
+
+	// a nil payload is detached rather than empty
+	decodedMsg.Payload = nil
+	assert.Equal(ErrMissingPayload, decodedMsg.Verify([]byte("session"), verifiers))
+
+	_, err = SignEmpty(rand.Reader, nil, nil, Signer{})
+	assert.Equal("Signer has no algorithm", err.Error())
+}
+
+func TestSignMulti(t *testing.T) {
+	assert := assert.New(t)
+
+	var signers []Signer
+	var verifiers []Verifier
+	for _, alg := range []*Algorithm{ES256, PS256} {
+		signer, err := NewSigner(alg, nil)
+		assert.Nil(err, "Error creating signer")
+		signers = append(signers, *signer)
+		verifiers = append(verifiers, *signer.Verifier())
+	}
+
+	msg, err := SignMulti(rand.Reader, []byte("payload"), []byte("external"), signers)
+	assert.Nil(err)
+	assert.Equal([]byte("payload"), msg.Payload)
+	assert.Len(msg.Signatures, 2)
+	assert.Equal(ES256.Value, msg.Signatures[0].Headers.Protected[CommonHeaderIDAlg])
+	assert.Equal(PS256.Value, msg.Signatures[1].Headers.Protected[CommonHeaderIDAlg])
+	assert.Nil(msg.Verify([]byte("external"), verifiers))
+
+	// verifiers that only support one algorithm can skip the other
+	verifiers[1] = Verifier{}
+	msg.Signatures[1].Headers.Protected[CommonHeaderIDAlg] = -9000
+	assert.Nil(msg.VerifyWithOpts([]byte("external"), verifiers, VerifyOpts{IgnoreUnsupportedAlgs: true}))
+
+	_, err = SignMulti(rand.Reader, []byte("payload"), nil, nil)
+	assert.Equal(ErrNoSignerFound, err)
+	_, err = SignMulti(rand.Reader, []byte("payload"), nil, []Signer{{}})
+	assert.Equal("Signer 0 has no algorithm", err.Error())
+}
+
 func TestSignatureEqual(t *testing.T) {
 	assert := assert.New(t)
 
@@ -108,7 +294,7 @@ func TestSignatureEqual(t *testing.T) {
 	assert.Equal(s1.Equal(s2), true)
 
 	s1.Headers = &Headers{
-		Protected: map[interface{}]interface{}{algTag: -41}, // RSAES-OAEP w/ SHA-256 from [RFC8230]
+		Protected: map[interface{}]interface{}{CommonHeaderIDAlg: -41}, // RSAES-OAEP w/ SHA-256 from [RFC8230]
 	}
 	assert.Equal(s1.Equal(s2), false)
 
@@ -150,6 +336,57 @@ func TestSignatureDecodeErrors(t *testing.T) {
 	assert.Panics(func () { s.Decode(result) })
 }
 
+func TestDecodeSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	msg := signedToken(t, signer, "payload")
+	msg.Signatures[0].Headers.Unprotected[CommonHeaderIDKeyID] = []byte("kid")
+
+	// extract the signature and add it to another message
+	sig := msg.Signatures[0]
+	sigBytes, err := Marshal([]interface{}{sig.Headers.EncodeProtected(), sig.Headers.EncodeUnprotected(), sig.SignatureBytes})
+	assert.Nil(err)
+	decoded, err := DecodeSignature(sigBytes)
+	assert.Nil(err)
+	assert.True(sig.Headers.SignEquivalent(decoded.Headers))
+	assert.Equal(sig.Headers.Unprotected, decoded.Headers.Unprotected)
+	assert.Equal(sig.SignatureBytes, decoded.SignatureBytes)
+
+	other := NewSignMessage()
+	other.Payload = []byte("payload")
+	other.AddSignature(decoded)
+	assert.Nil(other.Verify(nil, []Verifier{*signer.Verifier()}))
+
+	// non-canonical protected headers are kept to verify
+	rawProtected := HexToBytesOrDie("A2" + "04" + "426964" + "01" + "26")
+	decoded, err = DecodeSignature(append(HexToBytesOrDie("83"+"47"), append(rawProtected, HexToBytesOrDie("A0"+"40")...)...))
+	assert.Nil(err)
+	assert.Equal(rawProtected, decoded.Headers.RawProtected)
+	assert.Equal(ES256.Value, decoded.Headers.Protected[CommonHeaderIDAlg])
+
+	var tests = []struct {
+		hex string
+		err string
+	}{
+		{"A0", "error decoding COSE_Signature: cbor: cannot unmarshal map into Go value of type []cbor.RawMessage"},
+		{"82" + "40" + "A0", "can only decode COSE_Signature with 3 items; got 2"},
+		{"83" + "40" + "A0" + "01", "error decoding COSE_Signature: cbor: cannot unmarshal positive integer into Go struct field cose.signature.SignatureBytes of type []uint8"},
+		{"83" + "43A10126" + "A10126" + "40", "Duplicate header 1 found"},
+		{"83" + "40" + "A0" + "40" + "00", ErrTrailingData.Error()},
+		{"83" + "40" + "A1" + "1863" + strings.Repeat("81", MaxHeaderDepth) + "00" + "40", ErrMaxDepthExceeded.Error()},
+		{"83" + "40" + "A1" + "1863" + strings.Repeat("81", 100) + "00" + "40", ErrMaxDepthExceeded.Error()},
+		{"D2" + "84" + "40" + "A0" + "F6" + "40", "expected COSE_Signature; got COSE_Sign1 (tag 18): COSE message type does not match the decoder"},
+		{"D862" + "84" + "40" + "A0" + "F6" + "80", "expected COSE_Signature; got COSE_Sign (tag 98): COSE message type does not match the decoder"},
+		{"84" + "40" + "A0" + "F6" + "80", "expected COSE_Signature; got untagged COSE_Sign: COSE message type does not match the decoder"},
+	}
+	for _, test := range tests {
+		_, err := DecodeSignature(HexToBytesOrDie(test.hex))
+		assert.Equal(test.err, err.Error(), test.hex)
+	}
+}
+
 func TestSignMessageSignatureDigest(t *testing.T) {
 	assert := assert.New(t)
 
@@ -185,7 +422,7 @@ func TestSignMessageSignatureDigest(t *testing.T) {
 
 	msg = NewSignMessage()
 	signature = NewSignature()
-	signature.Headers.Protected[algTag] = ES256
+	signature.Headers.Protected[CommonHeaderIDAlg] = ES256
 	msg.Signatures = []Signature{*signature}
 	digest, err = msg.signatureDigest(nil, signature, hashFunc)
 	assert.Equal(err, nil, "signatureDigest does not accept nil external")
@@ -199,8 +436,8 @@ func TestVerifyErrors(t *testing.T) {
 
 
 	sig := NewSignature()
-	sig.Headers.Protected[algTag] = -41 // RSAES-OAEP w/ SHA-256 from [RFC8230]
-	sig.Headers.Protected[kidTag] = 1
+	sig.Headers.Protected[CommonHeaderIDAlg] = -41 // RSAES-OAEP w/ SHA-256 from [RFC8230]
+	sig.Headers.Protected[CommonHeaderIDKeyID] = 1
 
 	signer, err := NewSigner(ES256, nil)
 	assert.Nil(err, "Error creating signer")
@@ -210,11 +447,16 @@ func TestVerifyErrors(t *testing.T) {
 	verifiers := []Verifier{*verifier}
 	payload := []byte("")
 
+	// a message with its signatures stripped does not verify
 	msg.Signatures = []Signature{}
-	assert.Nil(msg.Verify(payload, verifiers))
+	assert.Equal(ErrNoSignatures, msg.Verify(payload, verifiers))
+	assert.Equal(ErrNoSignatures, msg.VerifyWithOpts(payload, verifiers, VerifyOpts{}))
 
 	msg.Signatures = nil
-	assert.Nil(msg.Verify(payload, verifiers))
+	assert.Equal(ErrNoSignatures, msg.Verify(payload, verifiers))
+
+	var nilMsg *SignMessage
+	assert.Equal(ErrNoSignatures, nilMsg.Verify(payload, verifiers))
 
 	msg.AddSignature(sig)
 	msg.Signatures[0].Headers.Protected = nil
@@ -224,20 +466,20 @@ func TestVerifyErrors(t *testing.T) {
 	assert.Equal(ErrNilSigHeader, msg.Verify(payload, verifiers))
 
 	sig = NewSignature()
-	sig.Headers.Protected[algTag] = -41 // RSAES-OAEP w/ SHA-256 from [RFC8230]
-	sig.Headers.Protected[kidTag] = 1
+	sig.Headers.Protected[CommonHeaderIDAlg] = -41 // RSAES-OAEP w/ SHA-256 from [RFC8230]
+	sig.Headers.Protected[CommonHeaderIDKeyID] = 1
 	msg.Signatures[0] = *sig
 	assert.Equal("SignMessage signature 0 missing signature bytes to verify", msg.Verify(payload, verifiers).Error())
 
-	msg.Signatures[0].Headers.Protected[algTag] = -41 // RSAES-OAEP w/ SHA-256 from [RFC8230]
-	msg.Signatures[0].Headers.Protected[kidTag] = 1
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDAlg] = -41 // RSAES-OAEP w/ SHA-256 from [RFC8230]
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDKeyID] = 1
 	msg.Signatures[0].SignatureBytes = []byte("already signed")
 	assert.Equal(ErrUnavailableHashFunc, msg.Verify(payload, verifiers))
 
-	msg.Signatures[0].Headers.Protected[algTag] = 1
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDAlg] = 1
 	assert.Equal(ErrInvalidAlg, msg.Verify(payload, verifiers))
 
-	msg.Signatures[0].Headers.Protected[algTag] = -7 // ECDSA w/ SHA-256 from [RFC8152]
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDAlg] = -7 // ECDSA w/ SHA-256 from [RFC8152]
 	assert.Equal("Wrong number of signatures 1 and verifiers 0", msg.Verify(payload, []Verifier{}).Error())
 
 	verifiers = []Verifier{
@@ -259,4 +501,825 @@ func TestVerifyErrors(t *testing.T) {
 		},
 	}
 	assert.Equal("invalid signature length: 14", msg.Verify(payload, verifiers).Error())
+
+	msg.Payload = nil
+	assert.Equal(ErrMissingPayload, msg.Verify(payload, verifiers))
+}
+
+func TestVerifyWithOptsUnsupportedAlgs(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+
+	// signatures from newer algorithms added by other signers
+	for _, alg := range []interface{}{-9000, "FOOOO", -16} {
+		sig := NewSignature()
+		sig.Headers.Protected[CommonHeaderIDAlg] = alg
+		sig.SignatureBytes = []byte("signature from the future")
+		msg.AddSignature(sig)
+	}
+	verifiers := []Verifier{*signer.Verifier(), {}, {}, {}}
+
+	assert.Equal(ErrUnsupportedAlg, msg.VerifyWithOpts(nil, verifiers, VerifyOpts{}))
+	assert.Nil(msg.VerifyWithOpts(nil, verifiers, VerifyOpts{IgnoreUnsupportedAlgs: true}))
+
+	// bad signatures are not skipped
+	assert.Equal(ErrECDSAVerification, msg.VerifyWithOpts([]byte("external"), verifiers, VerifyOpts{IgnoreUnsupportedAlgs: true}))
+
+	// at least one signature must be supported
+	msg.Signatures = msg.Signatures[1:]
+	assert.Equal(ErrUnsupportedAlg, msg.VerifyWithOpts(nil, verifiers[1:], VerifyOpts{IgnoreUnsupportedAlgs: true}))
+
+	// malformed signatures are not skipped
+	delete(msg.Signatures[0].Headers.Protected, CommonHeaderIDAlg)
+	assert.Equal(ErrAlgNotFound, msg.VerifyWithOpts(nil, verifiers[1:], VerifyOpts{IgnoreUnsupportedAlgs: true}))
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDAlg] = 1
+	assert.Equal(ErrInvalidAlg, msg.VerifyWithOpts(nil, verifiers[1:], VerifyOpts{IgnoreUnsupportedAlgs: true}))
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDAlg] = -7.0
+	assert.Equal(ErrInvalidAlgEncoding, msg.VerifyWithOpts(nil, verifiers[1:], VerifyOpts{IgnoreUnsupportedAlgs: true}))
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDAlg] = []byte("ES256")
+	assert.Equal(ErrAlgNotFound, msg.VerifyWithOpts(nil, verifiers[1:], VerifyOpts{IgnoreUnsupportedAlgs: true}))
+
+	assert.Equal("Wrong number of signatures 3 and verifiers 1", msg.VerifyWithOpts(nil, verifiers[:1], VerifyOpts{}).Error())
+	msg.Payload = nil
+	assert.Equal(ErrMissingPayload, msg.VerifyWithOpts(nil, verifiers[1:], VerifyOpts{}))
+}
+
+func TestVerifyWithOptsUnderstoodLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+
+	signWithCrit := func(msgCrit, sigCrit []interface{}) *SignMessage {
+		msg := NewSignMessage()
+		msg.Payload = []byte("payload")
+		msg.Headers.Protected[-65537] = "private"
+		msg.Headers.Protected["app"] = "private"
+		msg.Headers.Protected[CommonHeaderIDKeyID] = []byte("kid")
+		msg.Headers.Protected[33] = []byte("cert")
+		if msgCrit != nil {
+			msg.Headers.Protected[CommonHeaderIDCrit] = msgCrit
+		}
+		sig := NewSignature()
+		sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+		sig.Headers.Protected[-65537] = "private"
+		if sigCrit != nil {
+			sig.Headers.Protected["crit"] = sigCrit
+		}
+		msg.AddSignature(sig)
+		assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+		return msg
+	}
+
+	// common headers are understood by default
+	msg := signWithCrit([]interface{}{"kid", 33}, []interface{}{1})
+	assert.Nil(msg.VerifyWithOpts(nil, verifiers, VerifyOpts{}))
+
+	msg = signWithCrit([]interface{}{-65537, "app"}, nil)
+	assert.Equal("crit header label -65537 is not understood", msg.VerifyWithOpts(nil, verifiers, VerifyOpts{}).Error())
+	assert.Equal("crit header label app is not understood", msg.VerifyWithOpts(nil, verifiers, VerifyOpts{
+		UnderstoodLabels: []interface{}{int64(-65537)},
+	}).Error())
+	assert.Nil(msg.VerifyWithOpts(nil, verifiers, VerifyOpts{
+		UnderstoodLabels: []interface{}{int64(-65537), "app"},
+	}))
+
+	msg = signWithCrit(nil, []interface{}{-65537})
+	assert.Equal("crit header label -65537 is not understood", msg.VerifyWithOpts(nil, verifiers, VerifyOpts{}).Error())
+	assert.Nil(msg.VerifyWithOpts(nil, verifiers, VerifyOpts{UnderstoodLabels: []interface{}{-65537}}))
+
+	// a label that is not an int or tstr cannot be signed
+	msg = signWithCrit(nil, nil)
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDCrit] = []interface{}{[]byte("app")}
+	assert.Equal("crit header label [97 112 112] is not understood", msg.VerifyWithOpts(nil, verifiers, VerifyOpts{
+		UnderstoodLabels: []interface{}{[]byte("app")},
+	}).Error())
+
+	msg = signWithCrit(nil, []interface{}{})
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDCrit] = 1
+	assert.Equal("error decoding crit header as array; got int", msg.VerifyWithOpts(nil, verifiers, VerifyOpts{}).Error())
+}
+
+func TestSignCritLabelsPresent(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+
+	newMsg := func() *SignMessage {
+		msg := NewSignMessage()
+		msg.Payload = []byte("payload")
+		sig := NewSignature()
+		sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+		msg.AddSignature(sig)
+		return msg
+	}
+
+	msg := newMsg()
+	msg.Signatures[0].Headers.Protected["crit"] = []interface{}{-65537}
+	msg.Signatures[0].Headers.Unprotected[-65537] = "not protected"
+	err = msg.Sign(rand.Reader, nil, []Signer{*signer})
+	assert.Equal(ErrCriticalHeaderMissing, errors.Cause(err))
+	assert.Equal("SignMessage signature 0: crit label -65537: crit header lists a label that is not in the protected headers", err.Error())
+	assert.Nil(msg.Signatures[0].SignatureBytes)
+
+	msg = newMsg()
+	msg.Headers.Protected["crit"] = []interface{}{"content type"}
+	err = msg.Sign(rand.Reader, nil, []Signer{*signer})
+	assert.Equal("SignMessage: crit label content type: crit header lists a label that is not in the protected headers", err.Error())
+
+	msg.Headers.Protected[CommonHeaderIDCrit] = "content type"
+	err = msg.Sign(rand.Reader, nil, []Signer{*signer})
+	assert.Equal("SignMessage: error decoding crit header as array; got string", err.Error())
+
+	// labels are matched by name or int
+	msg.Headers.Protected[CommonHeaderIDCrit] = []interface{}{"content type", CommonHeaderIDAlg}
+	msg.Headers.Protected[CommonHeaderIDContentType] = "text/plain"
+	msg.Headers.Protected["alg"] = "ES256"
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+	assert.Nil(msg.StrictVerify(nil, []Verifier{*signer.Verifier()}))
+}
+
+func TestVerifyWithOptsMinimalProtectedHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+	opts := VerifyOpts{MinimalProtectedHeaders: true}
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	msg.Headers.Protected["content type"] = 60 // message headers are not checked
+	sig := NewSignature()
+	sig.Headers.Protected["alg"] = "ES256"
+	sig.Headers.Unprotected[CommonHeaderIDKeyID] = []byte("kid")
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+	assert.Nil(msg.VerifyWithOpts(nil, verifiers, opts))
+
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDKeyID] = []byte("kid")
+	msg.Signatures[0].Headers.Unprotected = map[interface{}]interface{}{}
+	msg.Signatures[0].SignatureBytes = nil
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+	assert.Nil(msg.VerifyWithOpts(nil, verifiers, VerifyOpts{}))
+	assert.Equal(
+		"SignMessage signature 0 has protected header 4 other than alg",
+		msg.VerifyWithOpts(nil, verifiers, opts).Error())
+}
+
+func TestVerifyAll(t *testing.T) {
+	assert := assert.New(t)
+
+	signers := map[string]*Signer{}
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	var messageSigners []Signer
+	for _, s := range []struct {
+		kid string
+		alg *Algorithm
+	}{{"es256", ES256}, {"ps256", PS256}, {"es384", ES384}} {
+		kid, alg := s.kid, s.alg
+		signer, err := NewSigner(alg, nil)
+		assert.Nil(err, "Error creating signer")
+		signers[kid] = signer
+		messageSigners = append(messageSigners, *signer)
+
+		sig := NewSignature()
+		sig.Headers.Protected[CommonHeaderIDAlg] = alg.Value
+		sig.Headers.Unprotected[CommonHeaderIDKeyID] = []byte(kid)
+		msg.AddSignature(sig)
+	}
+	assert.Nil(msg.Sign(rand.Reader, nil, messageSigners))
+	lookup := func(kid []byte) (*Verifier, error) {
+		signer, ok := signers[string(kid)]
+		if !ok {
+			return nil, errors.Errorf("no verifier for kid %s", kid)
+		}
+		return signer.Verifier(), nil
+	}
+
+	results, err := msg.VerifyAll(nil, lookup)
+	assert.Nil(err)
+	assert.Equal([]SignatureResult{
+		{Index: 0, AlgID: ES256.Value, KeyID: []byte("es256")},
+		{Index: 1, AlgID: PS256.Value, KeyID: []byte("ps256")},
+		{Index: 2, AlgID: ES384.Value, KeyID: []byte("es384")},
+	}, results)
+
+	// results report each failure
+	delete(signers, "ps256")
+	msg.Signatures[2].SignatureBytes[0] ^= 0xff
+	results, err = msg.VerifyAll(nil, lookup)
+	assert.Nil(err)
+	assert.Nil(results[0].Err)
+	assert.Equal("no verifier for kid ps256", results[1].Err.Error())
+	assert.Equal(ErrECDSAVerification, results[2].Err)
+
+	// StopOnFailure does not verify signatures after the first failure
+	verified := []string{}
+	results, err = msg.VerifyAllWithOpts(nil, func(kid []byte) (*Verifier, error) {
+		verified = append(verified, string(kid))
+		return lookup(kid)
+	}, VerifyAllOpts{StopOnFailure: true})
+	assert.Equal("no verifier for kid ps256", err.Error())
+	assert.Len(results, 2)
+	assert.Nil(results[0].Err)
+	assert.Equal(err, results[1].Err)
+	assert.Equal([]string{"es256", "ps256"}, verified)
+
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDAlg] = -9000
+	results, err = msg.VerifyAll(nil, lookup)
+	assert.Equal(ErrNoSignatureVerified, err)
+	assert.Len(results, 3)
+	assert.Equal(0, results[0].AlgID)
+	assert.Equal([]byte("es256"), results[0].KeyID)
+	assert.Equal("Algorithm with value -9000 not found", results[0].Err.Error())
+
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	results, err = msg.VerifyAll(nil, func(kid []byte) (*Verifier, error) {
+		return signers["es384"].Verifier(), nil
+	})
+	assert.Equal(ErrNoSignatureVerified, err)
+	assert.Equal("Verifier of type ES384 cannot verify a signature of type ES256", results[0].Err.Error())
+
+	results, err = msg.VerifyAll(nil, func(kid []byte) (*Verifier, error) { return nil, nil })
+	assert.Equal(ErrNoSignatureVerified, err)
+	assert.Equal(ErrNoVerifierFound, results[0].Err)
+
+	results, err = msg.VerifyAll(nil, func(kid []byte) (*Verifier, error) {
+		return &Verifier{PublicKey: signers["es256"].Public()}, nil
+	})
+	assert.Equal(ErrNoSignatureVerified, err)
+	assert.Equal("Verifier for kid 6573323536 has no Alg", results[0].Err.Error())
+
+	msg.Payload = nil
+	_, err = msg.VerifyAll(nil, lookup)
+	assert.Equal(ErrMissingPayload, err)
+	_, err = NewSignMessage().VerifyAll(nil, lookup)
+	assert.Equal(ErrNoSignatureVerified, err)
+}
+
+func TestVerifyIgnoresUnprotectedAlg(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	otherSigner, err := NewSigner(ES384, nil)
+	assert.Nil(err, "Error creating signer")
+
+	msg, err := SignMulti(rand.Reader, []byte("payload"), nil, []Signer{*signer})
+	assert.Nil(err)
+
+	// an attacker cannot switch the alg with an unprotected header
+	msg.Signatures[0].Headers.Unprotected[CommonHeaderIDAlg] = ES384.Value
+	assert.Nil(msg.Verify(nil, []Verifier{*signer.Verifier()}))
+	assert.Equal("invalid signature length: 64", msg.Verify(nil, []Verifier{*otherSigner.Verifier()}).Error())
+
+	delete(msg.Signatures[0].Headers.Protected, CommonHeaderIDAlg)
+	assert.Equal(ErrAlgNotFound, msg.Verify(nil, []Verifier{*otherSigner.Verifier()}))
+}
+
+func TestVerifyDetachedPayload(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("detached payload")
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+
+	// detach the payload
+	msg.Payload = nil
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+
+	decoded, err := Unmarshal(msgBytes)
+	assert.Nil(err)
+	decodedMsg := decoded.(SignMessage)
+	assert.Equal(ErrMissingPayload, decodedMsg.Verify(nil, verifiers))
+
+	decodedMsg.Payload = []byte("detached payload")
+	assert.Nil(decodedMsg.Verify(nil, verifiers))
+
+	decodedMsg.Payload = []byte("other payload")
+	assert.Equal(ErrECDSAVerification, decodedMsg.Verify(nil, verifiers))
+}
+
+func TestVerifyWithPayload(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+	msg := signedToken(t, signer, "payload")
+
+	// embedded payload
+	assert.Nil(msg.VerifyWithPayload([]byte("payload"), nil, verifiers))
+	assert.Equal(ErrPayloadMismatch, msg.VerifyWithPayload([]byte("other payload"), nil, verifiers))
+	assert.Equal(ErrMissingPayload, msg.VerifyWithPayload(nil, nil, verifiers))
+
+	// detached payload
+	msg.Payload = nil
+	assert.Nil(msg.VerifyWithPayload([]byte("payload"), nil, verifiers))
+	assert.Nil(msg.Payload)
+	assert.Equal(ErrECDSAVerification, msg.VerifyWithPayload([]byte("other payload"), nil, verifiers))
+	assert.Equal(ErrECDSAVerification, msg.VerifyWithPayload([]byte("payload"), []byte("external"), verifiers))
+
+	assert.Equal(ErrNoSignatures, NewSignMessage().VerifyWithPayload([]byte("payload"), nil, verifiers))
+}
+
+func TestSignAndVerifyWithPayloadCanonicalizer(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+
+	// removes insignificant whitespace as a stand-in for JCS
+	compactJSON := func(payload []byte) ([]byte, error) {
+		var b bytes.Buffer
+		err := json.Compact(&b, payload)
+		return b.Bytes(), err
+	}
+
+	msg := NewSignMessage()
+	msg.Payload = []byte(`{"iss": "issuer",  "sub": "subject"}`)
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+	assert.Nil(msg.SignWithOpts(rand.Reader, nil, []Signer{*signer}, SignOpts{PayloadCanonicalizer: compactJSON}))
+	assert.Equal([]byte(`{"iss": "issuer",  "sub": "subject"}`), msg.Payload)
+
+	opts := VerifyOpts{PayloadCanonicalizer: compactJSON}
+	assert.Nil(msg.VerifyWithOpts(nil, verifiers, opts))
+	msg.Payload = []byte("{\n  \"iss\":\"issuer\",\n  \"sub\":\"subject\"\n}")
+	assert.Nil(msg.VerifyWithOpts(nil, verifiers, opts))
+
+	// both sides must canonicalize
+	assert.Equal(ErrECDSAVerification, msg.Verify(nil, verifiers))
+	msg.Payload = []byte(`{"iss":"issuer","sub":"subject"}`)
+	assert.Nil(msg.Verify(nil, verifiers))
+	msg.Payload = []byte(`{"iss":"other","sub":"subject"}`)
+	assert.Equal(ErrECDSAVerification, msg.VerifyWithOpts(nil, verifiers, opts))
+
+	msg.Payload = []byte("not json")
+	assert.Equal("error canonicalizing payload: invalid character 'o' in literal null (expecting 'u')", msg.VerifyWithOpts(nil, verifiers, opts).Error())
+	err = msg.SignWithOpts(rand.Reader, nil, []Signer{*signer}, SignOpts{OverwriteSignatures: true, PayloadCanonicalizer: compactJSON})
+	assert.Equal("error canonicalizing payload: invalid character 'o' in literal null (expecting 'u')", err.Error())
+}
+
+func TestDecodeSignMessageDetached(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("detached payload")
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+
+	attachedBytes, err := Marshal(msg)
+	assert.Nil(err)
+
+	msg.Payload = nil
+	detachedBytes, err := Marshal(msg)
+	assert.Nil(err)
+
+	decoded, err := DecodeSignMessageDetached(detachedBytes, []byte("detached payload"), DecodeOptions{})
+	assert.Nil(err)
+	assert.Equal([]byte("detached payload"), decoded.Payload)
+	assert.Nil(decoded.Verify(nil, verifiers))
+
+	decoded, err = DecodeSignMessageDetached(detachedBytes, []byte("other payload"), DecodeOptions{})
+	assert.Nil(err)
+	assert.Equal(ErrECDSAVerification, decoded.Verify(nil, verifiers))
+
+	decoded, err = DecodeSignMessageDetached(attachedBytes, []byte("detached payload"), DecodeOptions{})
+	assert.Nil(decoded)
+	assert.Equal(ErrPayloadNotDetached, err)
+
+	decoded, err = DecodeSignMessageDetached([]byte("\x00"), []byte("detached payload"), DecodeOptions{})
+	assert.Nil(decoded)
+	assert.Equal("error decoding COSE_Sign message; got int64", err.Error())
+
+	// trailing data is only rejected with Strict
+	decoded, err = DecodeSignMessageDetached(append(detachedBytes, 0x00), []byte("detached payload"), DecodeOptions{})
+	assert.Nil(err)
+	assert.Nil(decoded.Verify(nil, verifiers))
+	decoded, err = DecodeSignMessageDetached(append(detachedBytes, 0x00), []byte("detached payload"), DecodeOptions{Strict: true})
+	assert.Nil(decoded)
+	assert.Equal(ErrTrailingData, err)
+}
+
+func TestVerifyAndExtract(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload to sign")
+	msg.Headers.Protected["content type"] = "text/plain"
+	msg.Headers.Unprotected["kid"] = []byte("unauthenticated")
+
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+
+	err = msg.Sign(rand.Reader, nil, []Signer{*signer})
+	assert.Nil(err, "Error signing message")
+
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+
+	payload, headers, err := VerifyAndExtract(msgBytes, nil, verifiers)
+	assert.Nil(err)
+	assert.Equal([]byte("payload to sign"), payload)
+	assert.Equal(map[interface{}]interface{}{3: "text/plain"}, headers.Protected)
+	assert.Equal(map[interface{}]interface{}{}, headers.Unprotected)
+
+	// wrong external data
+	payload, headers, err = VerifyAndExtract(msgBytes, []byte("external"), verifiers)
+	assert.Equal(ErrECDSAVerification, err)
+	assert.Nil(payload)
+	assert.Nil(headers)
+
+	// no signatures
+	msgBytes, err = Marshal(NewSignMessage())
+	assert.Nil(err)
+	_, _, err = VerifyAndExtract(msgBytes, nil, verifiers)
+	assert.Equal(ErrNoSignatures, err)
+
+	// not a SignMessage
+	_, _, err = VerifyAndExtract([]byte("\xA0"), nil, verifiers)
+	assert.NotNil(err)
+}
+
+func TestSignMessageDecodePayload(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+
+	// CWT claims set {1: "issuer", 4: 1444064944}
+	claims := map[interface{}]interface{}{1: "issuer", 4: 1444064944}
+	payload, err := Marshal(claims)
+	assert.Nil(err)
+
+	msg := NewSignMessage()
+	msg.Payload = payload
+	msg.Headers.Protected["content type"] = "application/cwt"
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+	decoded, err := Unmarshal(msgBytes)
+	assert.Nil(err)
+	decodedMsg := decoded.(SignMessage)
+	assert.Nil(decodedMsg.Verify(nil, []Verifier{*signer.Verifier()}))
+
+	var result map[interface{}]interface{}
+	assert.Nil(decodedMsg.DecodePayload(&result))
+	assert.Equal(map[interface{}]interface{}{int64(1): "issuer", int64(4): int64(1444064944)}, result)
+
+	type cwtClaims struct {
+		Iss string `cbor:"1,keyasint"`
+		Exp int64  `cbor:"4,keyasint"`
+	}
+	var typed cwtClaims
+	assert.Nil(decodedMsg.DecodePayload(&typed))
+	assert.Equal(cwtClaims{Iss: "issuer", Exp: 1444064944}, typed)
+
+	decodedMsg.Payload = []byte("\xff")
+	assert.NotNil(decodedMsg.DecodePayload(&result))
+
+	decodedMsg.Payload = nil
+	assert.Equal(ErrMissingPayload, decodedMsg.DecodePayload(&result))
+}
+
+func TestStrictVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	msg.Headers.Protected["crit"] = []interface{}{"content type"}
+	msg.Headers.Protected["content type"] = "text/plain"
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+
+	assert.Nil(msg.StrictVerify(nil, verifiers))
+	assert.Equal(ErrECDSAVerification, msg.StrictVerify([]byte("external"), verifiers))
+
+	// all violations are reported at once
+	msg.Headers.Unprotected["content type"] = "text/html"
+	msg.Headers.Protected[CommonHeaderIDCrit] = []interface{}{"kid"}
+	msg.Signatures[0].Headers.Unprotected["alg"] = "ES256"
+	msg.Payload = nil
+	err = msg.StrictVerify(nil, verifiers)
+	assert.Equal(&StrictVerifyError{Violations: []string{
+		"message header content type is in the protected and unprotected headers",
+		"message crit label kid is not in the protected headers",
+		"payload is nil",
+		"signature 0 header alg is in the protected and unprotected headers",
+		"signature 0 alg header is not protected",
+	}}, err)
+	assert.Equal("strict verification failed: message header content type is in the protected and unprotected headers; message crit label kid is not in the protected headers; payload is nil; signature 0 header alg is in the protected and unprotected headers; signature 0 alg header is not protected", err.Error())
+
+	var tests = []struct {
+		msg       *SignMessage
+		verifiers []Verifier
+		violation string
+	}{
+		{
+			&SignMessage{Headers: &Headers{}, Payload: []byte("")},
+			nil,
+			"message has no signatures",
+		},
+		{
+			&SignMessage{Payload: []byte(""), Signatures: []Signature{{Headers: &Headers{Protected: map[interface{}]interface{}{1: -7}}, SignatureBytes: []byte("x")}}},
+			verifiers,
+			"message headers are nil",
+		},
+		{
+			&SignMessage{Headers: &Headers{}, Payload: []byte(""), Signatures: []Signature{{}}},
+			verifiers,
+			"signature 0 headers are nil",
+		},
+		{
+			&SignMessage{Headers: &Headers{}, Payload: []byte(""), Signatures: []Signature{{Headers: &Headers{}, SignatureBytes: []byte("x")}}},
+			verifiers,
+			"signature 0 alg: Error fetching alg",
+		},
+		{
+			&SignMessage{Headers: &Headers{}, Payload: []byte(""), Signatures: []Signature{{Headers: &Headers{Protected: map[interface{}]interface{}{1: 1}}, SignatureBytes: []byte("x")}}},
+			verifiers,
+			"signature 0 alg A128GCM is not a signature algorithm",
+		},
+		{
+			&SignMessage{Headers: &Headers{}, Payload: []byte(""), Signatures: []Signature{{Headers: &Headers{Protected: map[interface{}]interface{}{1: -7}}}}},
+			verifiers,
+			"signature 0 has no signature bytes",
+		},
+		{
+			&SignMessage{Headers: &Headers{}, Payload: []byte(""), Signatures: []Signature{{Headers: &Headers{Protected: map[interface{}]interface{}{1: -7}}, SignatureBytes: []byte("x")}}},
+			nil,
+			"1 signatures for 0 verifiers",
+		},
+		{
+			&SignMessage{Headers: &Headers{RawProtected: []byte("\x80")}, Payload: []byte(""), Signatures: []Signature{{Headers: &Headers{Protected: map[interface{}]interface{}{1: -7}}, SignatureBytes: []byte("x")}}},
+			verifiers,
+			"message protected headers are not a CBOR map",
+		},
+		{
+			&SignMessage{Headers: &Headers{Protected: map[interface{}]interface{}{"alg": "ES256", 1: -7}}, Payload: []byte(""), Signatures: []Signature{{Headers: &Headers{Protected: map[interface{}]interface{}{1: -7}}, SignatureBytes: []byte("x")}}},
+			verifiers,
+			"message header alg is repeated in the protected headers",
+		},
+		{
+			&SignMessage{Headers: &Headers{Unprotected: map[interface{}]interface{}{2: []interface{}{3}}}, Payload: []byte(""), Signatures: []Signature{{Headers: &Headers{Protected: map[interface{}]interface{}{1: -7}}, SignatureBytes: []byte("x")}}},
+			verifiers,
+			"message crit header is not protected",
+		},
+		{
+			&SignMessage{Headers: &Headers{Protected: map[interface{}]interface{}{2: []interface{}{}}}, Payload: []byte(""), Signatures: []Signature{{Headers: &Headers{Protected: map[interface{}]interface{}{1: -7}}, SignatureBytes: []byte("x")}}},
+			verifiers,
+			"message crit header is not a non-empty array of labels",
+		},
+	}
+	for _, test := range tests {
+		err = test.msg.StrictVerify(nil, test.verifiers)
+		assert.Equal(&StrictVerifyError{Violations: []string{test.violation}}, err, test.violation)
+	}
+
+	var nilMsg *SignMessage
+	assert.Equal("strict verification failed: message is nil", nilMsg.StrictVerify(nil, nil).Error())
+}
+
+func TestSetSignatureKeyID(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	sig.Headers.Unprotected["kid"] = []byte("old-kid")
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+
+	assert.Nil(msg.SetSignatureKeyID(0, []byte("new-kid")))
+	assert.Equal(map[interface{}]interface{}{CommonHeaderIDKeyID: []byte("new-kid")}, msg.Signatures[0].Headers.Unprotected)
+	assert.Nil(msg.Verify(nil, verifiers))
+
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+	decoded, err := Unmarshal(msgBytes)
+	assert.Nil(err)
+	decodedMsg := decoded.(SignMessage)
+	assert.Equal([]byte("new-kid"), decodedMsg.Signatures[0].Headers.Unprotected[CommonHeaderIDKeyID])
+	assert.Nil(decodedMsg.Verify(nil, verifiers))
+
+	msg.Signatures[0].Headers.Unprotected = nil
+	assert.Nil(msg.SetSignatureKeyID(0, []byte("kid")))
+	assert.Equal([]byte("kid"), msg.Signatures[0].Headers.Unprotected[CommonHeaderIDKeyID])
+
+	assert.Equal("SignMessage has no signature 1", msg.SetSignatureKeyID(1, []byte("kid")).Error())
+	assert.Equal("SignMessage has no signature -1", msg.SetSignatureKeyID(-1, []byte("kid")).Error())
+
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDKeyID] = []byte("signed-kid")
+	err = msg.SetSignatureKeyID(0, []byte("new-kid"))
+	assert.Equal("SignMessage signature 0 has a protected kid that cannot change without re-signing", err.Error())
+
+	msg.Signatures[0].Headers = nil
+	assert.Equal(ErrNilSigHeader, msg.SetSignatureKeyID(0, []byte("kid")))
+}
+
+func TestValidateStructure(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload")
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	sig.Headers.Unprotected[CommonHeaderIDKeyID] = []byte("kid")
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+	assert.Nil(ValidateStructure(msgBytes))
+
+	// a detached payload is structurally valid
+	msg.Payload = nil
+	detachedBytes, err := Marshal(msg)
+	assert.Nil(err)
+	assert.Nil(ValidateStructure(detachedBytes))
+
+	assert.Equal(ErrTrailingData, ValidateStructure(append(msgBytes, 0x00)))
+	assert.NotNil(ValidateStructure([]byte("garbage")))
+	assert.NotNil(ValidateStructure(msgBytes[:len(msgBytes)-1]))
+
+	err = ValidateStructure(HexToBytesOrDie("01"))
+	assert.Contains(err.Error(), "invalid COSE_Sign message: decoded to")
+
+	var tests = []struct {
+		hex string
+		err string
+	}{
+		{
+			// no signatures
+			"D862" + "84" + "40" + "A0" + "40" + "80",
+			"invalid COSE_Sign message: message has no signatures",
+		},
+		{
+			// signature without an alg or signature bytes
+			"D862" + "84" + "40" + "A0" + "40" + "81" + "83" + "40" + "A0" + "40",
+			"invalid COSE_Sign message: signature 0 alg: Error fetching alg; signature 0 has no signature bytes",
+		},
+		{
+			// unprotected alg
+			"D862" + "84" + "40" + "A0" + "40" + "81" + "83" + "40" + "A10126" + "4101",
+			"invalid COSE_Sign message: signature 0 alg header is not protected; signature 0 alg: Error fetching alg",
+		},
+		{
+			// first layer alg
+			"D862" + "84" + "40" + "A0" + "40" + "81" + "83" + "43A10101" + "A0" + "4101",
+			"invalid COSE_Sign message: signature 0 alg A128GCM is not a signature algorithm",
+		},
+		{
+			// crit listing an unprotected header
+			"D862" + "84" + "44A1028104" + "A1044101" + "40" + "81" + "83" + "43A10126" + "A0" + "4101",
+			"invalid COSE_Sign message: message crit label 4 is not in the protected headers",
+		},
+	}
+	for _, test := range tests {
+		err = ValidateStructure(HexToBytesOrDie(test.hex))
+		assert.NotNil(err, test.hex)
+		if err != nil {
+			assert.Equal(test.err, err.Error())
+		}
+	}
+}
+
+func TestBatchSign(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifier := signer.Verifier()
+
+	payloads := [][]byte{[]byte("first"), []byte("second"), {}}
+	protected := map[interface{}]interface{}{"kid": []byte("key 1")}
+	messages, err := BatchSign(rand.Reader, payloads, protected, *signer)
+	assert.Nil(err)
+	assert.Len(messages, len(payloads))
+	assert.Equal(map[interface{}]interface{}{"kid": []byte("key 1")}, protected)
+
+	for i, message := range messages {
+		decoded, err := Unmarshal(message)
+		assert.Nil(err)
+		msg, ok := decoded.(SignMessage)
+		assert.True(ok)
+		assert.Equal(payloads[i], msg.Payload)
+		assert.Equal([]byte("key 1"), msg.Signatures[0].Headers.Protected[CommonHeaderIDKeyID])
+		assert.Nil(msg.Verify(nil, []Verifier{*verifier}))
+	}
+
+	_, err = BatchSign(rand.Reader, payloads, map[interface{}]interface{}{"alg": "ES384"}, *signer)
+	assert.Equal("Signer of type ES256 cannot generate a signature of type ES384", err.Error())
+
+	_, err = BatchSign(rand.Reader, payloads, map[interface{}]interface{}{"alg": "A128GCM"}, *signer)
+	assert.Equal(ErrInvalidAlg, err)
+
+	_, err = BatchSign(rand.Reader, payloads, nil, Signer{})
+	assert.Equal("Signer has no algorithm", err.Error())
+}
+
+func benchmarkPayloads() [][]byte {
+	payloads := make([][]byte, 100)
+	for i := range payloads {
+		payloads[i] = []byte(fmt.Sprintf("payload %d", i))
+	}
+	return payloads
+}
+
+func BenchmarkBatchSign(b *testing.B) {
+	signer, err := NewSigner(ES256, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	payloads := benchmarkPayloads()
+	protected := map[interface{}]interface{}{"kid": []byte("key 1")}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, err := BatchSign(rand.Reader, payloads, protected, *signer)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSignIndividually(b *testing.B) {
+	signer, err := NewSigner(ES256, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	payloads := benchmarkPayloads()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, payload := range payloads {
+			msg := NewSignMessage()
+			msg.Payload = payload
+			sig := NewSignature()
+			sig.Headers.Protected["alg"] = "ES256"
+			sig.Headers.Protected["kid"] = []byte("key 1")
+			msg.AddSignature(sig)
+			err := msg.Sign(rand.Reader, nil, []Signer{*signer})
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, err = Marshal(msg)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
 }
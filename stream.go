@@ -0,0 +1,91 @@
+package cose
+
+import (
+	"hash"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// sigStructurePrefix returns the encoded Sig_structure for signature
+// up to and including the head of its payload bstr
+//
+// The payload is the last Sig_structure item, so the Sig_structure
+// digest can be computed by writing the prefix and then the payload
+// bytes to a hash without having the payload in memory.
+func (m *SignMessage) sigStructurePrefix(external []byte, signature *Signature, size int64) (prefix []byte, err error) {
+	ToBeSigned, err := buildAndMarshalSigStructure(
+		m.Headers.EncodeProtected(),
+		signature.Headers.EncodeProtected(),
+		external,
+		[]byte{})
+	if err != nil {
+		return nil, err
+	}
+	// replace the trailing empty payload bstr with the head of a bstr
+	// of size bytes
+	prefix = ToBeSigned[:len(ToBeSigned)-1]
+	return append(prefix, cborHead(cborMajorTypeByteString, uint64(size))...), nil
+}
+
+// VerifyStream verifies all signatures on a SignMessage with a
+// detached payload read from payload e.g. for a file too large to
+// load into memory
+//
+// size is the length of the payload in bytes and is needed to encode
+// the Sig_structure before reading the payload e.g. the file size
+// from os.File.Stat or the size of an io.SectionReader over an
+// io.ReaderAt. payload is read once and must have exactly size bytes.
+// The message Payload must be nil (ErrPayloadNotDetached) since it is
+// not used.
+func (m *SignMessage) VerifyStream(payload io.Reader, size int64, external []byte, verifiers []Verifier) (err error) {
+	if m == nil || len(m.Signatures) < 1 {
+		return ErrNoSignatures
+	}
+	if m.Payload != nil {
+		return ErrPayloadNotDetached
+	}
+	if size < 0 {
+		return errors.Errorf("invalid payload size %d", size)
+	}
+	if len(m.Signatures) != len(verifiers) {
+		return errors.Errorf("Wrong number of signatures %d and verifiers %d", len(m.Signatures), len(verifiers))
+	}
+
+	hashers := make([]hash.Hash, len(m.Signatures))
+	writers := make([]io.Writer, len(m.Signatures))
+	for i, signature := range m.Signatures {
+		alg, err := signatureToVerifyAlg(i, &signature)
+		if err != nil {
+			return err
+		}
+		if !alg.HashFunc.Available() {
+			return ErrUnavailableHashFunc
+		}
+		prefix, err := m.sigStructurePrefix(external, &signature, size)
+		if err != nil {
+			return err
+		}
+
+		hashers[i] = alg.HashFunc.New()
+		_, _ = hashers[i].Write(prefix) // Write() on hash never fails
+		writers[i] = hashers[i]
+	}
+
+	n, err := io.CopyN(io.MultiWriter(writers...), payload, size)
+	if err != nil {
+		return errors.Wrapf(err, "error reading payload after %d of %d bytes", n, size)
+	}
+	var extra [1]byte
+	if n, _ := io.ReadFull(payload, extra[:]); n > 0 {
+		return errors.Errorf("payload is longer than %d bytes", size)
+	}
+
+	for i, signature := range m.Signatures {
+		err = verifySignatureDigest(&verifiers[i], &signature, hashers[i].Sum(nil))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
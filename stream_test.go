@@ -0,0 +1,129 @@
+package cose
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSigStructurePrefix(t *testing.T) {
+	assert := assert.New(t)
+
+	msg := NewSignMessage()
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+
+	for _, size := range []int{0, 23, 24, 255, 256, 65535, 65536} {
+		msg.Payload = bytes.Repeat([]byte("a"), size)
+		ToBeSigned, err := msg.SigStructure(nil, sig)
+		assert.Nil(err)
+
+		prefix, err := msg.sigStructurePrefix(nil, sig, int64(size))
+		assert.Nil(err)
+		assert.Equal(ToBeSigned, append(prefix, msg.Payload...), "payload size %d", size)
+	}
+}
+
+func TestVerifyStream(t *testing.T) {
+	assert := assert.New(t)
+
+	var signers []Signer
+	msg := NewSignMessage()
+	msg.Payload = bytes.Repeat([]byte("firmware"), 40000)
+	for _, alg := range []*Algorithm{ES256, ES384, PS256} {
+		signer, err := NewSigner(alg, nil)
+		assert.Nil(err, "Error creating signer")
+		signers = append(signers, *signer)
+
+		sig := NewSignature()
+		sig.Headers.Protected[CommonHeaderIDAlg] = alg.Value
+		msg.AddSignature(sig)
+	}
+	assert.Nil(msg.Sign(rand.Reader, []byte("external"), signers))
+
+	var verifiers []Verifier
+	for _, signer := range signers {
+		verifiers = append(verifiers, *signer.Verifier())
+	}
+	payload := msg.Payload
+	size := int64(len(payload))
+	msg.Payload = nil
+
+	assert.Nil(msg.VerifyStream(bytes.NewReader(payload), size, []byte("external"), verifiers))
+	assert.Equal(ErrECDSAVerification, msg.VerifyStream(bytes.NewReader(payload), size, nil, verifiers))
+
+	tampered := append([]byte{}, payload...)
+	tampered[size-1] ^= 0xff
+	assert.Equal(ErrECDSAVerification, msg.VerifyStream(bytes.NewReader(tampered), size, []byte("external"), verifiers))
+
+	assert.Equal(
+		"error reading payload after 319999 of 320000 bytes: EOF",
+		msg.VerifyStream(bytes.NewReader(payload[1:]), size, []byte("external"), verifiers).Error())
+	assert.Equal(
+		"payload is longer than 319999 bytes",
+		msg.VerifyStream(bytes.NewReader(payload), size-1, []byte("external"), verifiers).Error())
+	assert.Equal(
+		"invalid payload size -1",
+		msg.VerifyStream(bytes.NewReader(payload), -1, []byte("external"), verifiers).Error())
+	assert.Equal(
+		"Wrong number of signatures 3 and verifiers 1",
+		msg.VerifyStream(bytes.NewReader(payload), size, []byte("external"), verifiers[:1]).Error())
+
+	msg.Payload = payload
+	assert.Equal(ErrPayloadNotDetached, msg.VerifyStream(bytes.NewReader(payload), size, []byte("external"), verifiers))
+}
+
+func TestDecodeSignMessageDetachedAndVerifyStreamFile(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*signer.Verifier()}
+
+	payload := bytes.Repeat([]byte("large payload "), 10000)
+	dir, err := ioutil.TempDir("", "go-cose")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "payload.bin")
+	assert.Nil(ioutil.WriteFile(path, payload, 0600))
+
+	msg := NewSignMessage()
+	msg.Payload = payload
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+	attached, err := Marshal(msg)
+	assert.Nil(err)
+	msg.Payload = nil
+	detached, err := Marshal(msg)
+	assert.Nil(err)
+
+	decoded, err := DecodeSignMessageDetached(detached, nil, DecodeOptions{Strict: true})
+	assert.Nil(err)
+	assert.Nil(decoded.Payload)
+
+	file, err := os.Open(path)
+	assert.Nil(err)
+	defer file.Close()
+	info, err := file.Stat()
+	assert.Nil(err)
+	assert.Nil(decoded.VerifyStream(file, info.Size(), nil, verifiers))
+
+	// an io.ReaderAt through an io.SectionReader
+	section := io.NewSectionReader(file, 0, info.Size())
+	assert.Nil(decoded.VerifyStream(section, section.Size(), nil, verifiers))
+
+	_, err = DecodeSignMessageDetached(attached, nil, DecodeOptions{Strict: true})
+	assert.Equal(ErrPayloadNotDetached, err)
+	_, err = DecodeSignMessageDetached(append(detached, 0x00), nil, DecodeOptions{Strict: true})
+	assert.Equal(ErrTrailingData, err)
+	_, err = DecodeSignMessageDetached([]byte("\xA0"), nil, DecodeOptions{Strict: true})
+	assert.Equal("error decoding COSE_Sign message; got map[interface {}]interface {}", err.Error())
+}
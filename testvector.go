@@ -0,0 +1,164 @@
+package cose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// TestVector is a COSE_Sign message and the COSE_Key to verify it
+// with for testing other implementations against this package
+//
+// Byte strings are hex encoded, so the TestVector can be marshaled to
+// JSON.
+type TestVector struct {
+	Alg       string `json:"alg"`
+	Payload   string `json:"payload"`
+	Message   string `json:"message"`
+	PublicKey string `json:"public_key"`
+}
+
+// testVectorSeed is the seed of the private keys GenerateTestVector
+// signs with
+const testVectorSeed = "go-cose test vector key "
+
+// GenerateTestVector returns a TestVector with a COSE_Sign message
+// with payload signed with the ECDSA algorithm named algName (ES256,
+// ES384, or ES512)
+//
+// The output is the same for the same arguments: the private key is
+// derived from algName and signatures use deterministic ECDSA. The
+// keys are public, so only use them for tests.
+//
+// https://tools.ietf.org/html/rfc6979
+func GenerateTestVector(algName string, payload []byte) (vector TestVector, err error) {
+	alg, err := getAlgByName(algName)
+	if err != nil {
+		return vector, err
+	}
+	if alg.privateKeyType != KeyTypeECDSA || alg.privateKeyECDSACurve == nil {
+		return vector, errors.Errorf("cannot generate a deterministic test vector for %s; use an ECDSA algorithm", alg.Name)
+	}
+	if !alg.HashFunc.Available() {
+		return vector, ErrUnavailableHashFunc
+	}
+
+	// derive a private key in [1, n-1] from the seed
+	curve := alg.privateKeyECDSACurve
+	seed := sha512.Sum512([]byte(testVectorSeed + alg.Name))
+	one := big.NewInt(1)
+	d := new(big.Int).SetBytes(seed[:])
+	d.Mod(d, new(big.Int).Sub(curve.Params().N, one))
+	d.Add(d, one)
+	key := &ecdsa.PrivateKey{D: d}
+	key.Curve = curve
+	key.X, key.Y = curve.ScalarBaseMult(d.Bytes())
+
+	msg := NewSignMessage()
+	msg.Payload = payload
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = alg.Value
+	msg.AddSignature(sig)
+
+	digest, err := msg.signatureDigest(nil, sig, alg.HashFunc)
+	if err != nil {
+		return vector, err
+	}
+	r, s := signDeterministicECDSA(key, alg.HashFunc, digest)
+	msg.Signatures[0].SignatureBytes, err = ecdsaSignatureBytes(r, s, ecdsaCurveKeyBytesSize(curve))
+	if err != nil {
+		return vector, err
+	}
+	encoded, err := Marshal(msg)
+	if err != nil {
+		return vector, err
+	}
+
+	coseKey, err := publicKeyToCOSEKey(&key.PublicKey)
+	if err != nil {
+		return vector, err
+	}
+	coseKey[keyLabelAlg] = alg.Value
+	encodedKey, err := Marshal(coseKey)
+	if err != nil {
+		return vector, err
+	}
+
+	return TestVector{
+		Alg:       alg.Name,
+		Payload:   hex.EncodeToString(payload),
+		Message:   hex.EncodeToString(encoded),
+		PublicKey: hex.EncodeToString(encodedKey),
+	}, nil
+}
+
+// signDeterministicECDSA returns the ECDSA signature of digest with
+// the nonce k generated from the key and digest with HMAC_DRBG
+//
+// https://tools.ietf.org/html/rfc6979#section-3.2
+func signDeterministicECDSA(key *ecdsa.PrivateKey, hashFunc crypto.Hash, digest []byte) (r, s *big.Int) {
+	n := key.Curve.Params().N
+	qlen := n.BitLen()
+	rolen := (qlen + 7) / 8
+
+	bits2int := func(b []byte) *big.Int {
+		i := new(big.Int).SetBytes(b)
+		if excess := len(b)*8 - qlen; excess > 0 {
+			i.Rsh(i, uint(excess))
+		}
+		return i
+	}
+	e := bits2int(digest)
+	h1 := new(big.Int).Set(e)
+	if h1.Cmp(n) >= 0 {
+		h1.Sub(h1, n)
+	}
+
+	mac := func(k []byte, data ...[]byte) []byte {
+		h := hmac.New(hashFunc.New, k)
+		for _, d := range data {
+			_, _ = h.Write(d) // Write() on hash never fails
+		}
+		return h.Sum(nil)
+	}
+	x, h := I2OSP(key.D, rolen), I2OSP(h1, rolen)
+	v := make([]byte, hashFunc.Size())
+	k := make([]byte, hashFunc.Size())
+	for i := range v {
+		v[i] = 0x01
+	}
+	k = mac(k, v, []byte{0x00}, x, h)
+	v = mac(k, v)
+	k = mac(k, v, []byte{0x01}, x, h)
+	v = mac(k, v)
+
+	for {
+		var t []byte
+		for len(t)*8 < qlen {
+			v = mac(k, v)
+			t = append(t, v...)
+		}
+		nonce := bits2int(t)
+		if nonce.Sign() > 0 && nonce.Cmp(n) < 0 {
+			x1, _ := key.Curve.ScalarBaseMult(nonce.Bytes())
+			r = new(big.Int).Mod(x1, n)
+			if r.Sign() > 0 {
+				// s = k^-1 (e + r d) mod n
+				s = new(big.Int).Mul(r, key.D)
+				s.Add(s, e)
+				s.Mul(s, new(big.Int).ModInverse(nonce, n))
+				s.Mod(s, n)
+				if s.Sign() > 0 {
+					return r, s
+				}
+			}
+		}
+		k = mac(k, v, []byte{0x00})
+		v = mac(k, v)
+	}
+}
@@ -0,0 +1,82 @@
+package cose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func TestSignDeterministicECDSA(t *testing.T) {
+	assert := assert.New(t)
+
+	// https://tools.ietf.org/html/rfc6979#appendix-A.2.5
+	d, _ := new(big.Int).SetString("C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721", 16)
+	key := &ecdsa.PrivateKey{D: d}
+	key.Curve = elliptic.P256()
+	key.X, key.Y = key.Curve.ScalarBaseMult(d.Bytes())
+
+	digest := sha256.Sum256([]byte("sample"))
+	r, s := signDeterministicECDSA(key, crypto.SHA256, digest[:])
+	assert.Equal("efd48b2aacb6a8fd1140dd9cd45e81d69d2c877b56aaf991c34d0ea84eaf3716", hex.EncodeToString(r.Bytes()))
+	assert.Equal("f7cb1c942d657c41d436c7a1b6e29f65f3e900dbb9aff4064dc4ab2f843acda8", hex.EncodeToString(s.Bytes()))
+	assert.True(ecdsa.Verify(&key.PublicKey, digest[:], r, s))
+}
+
+func TestGenerateTestVector(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, alg := range []*Algorithm{ES256, ES384, ES512} {
+		vector, err := GenerateTestVector(alg.Name, []byte("payload"))
+		assert.Nil(err)
+		again, err := GenerateTestVector(alg.Name, []byte("payload"))
+		assert.Nil(err)
+		assert.Equal(vector, again, "%s test vector is not deterministic", alg.Name)
+		assert.Equal(alg.Name, vector.Alg)
+		assert.Equal("7061796c6f6164", vector.Payload)
+
+		// verify the message with the COSE_Key
+		keyBytes, err := hex.DecodeString(vector.PublicKey)
+		assert.Nil(err)
+		decodedKey, err := Unmarshal(keyBytes)
+		assert.Nil(err)
+		coseKey := decodedKey.(map[interface{}]interface{})
+		assert.Equal(int64(alg.Value), coseKey[int64(keyLabelAlg)])
+		pub := &ecdsa.PublicKey{
+			Curve: alg.privateKeyECDSACurve,
+			X:     new(big.Int).SetBytes(coseKey[int64(keyLabelX)].([]byte)),
+			Y:     new(big.Int).SetBytes(coseKey[int64(keyLabelY)].([]byte)),
+		}
+		verifier, err := NewVerifierFromPublicKey(alg.Name, pub)
+		assert.Nil(err)
+
+		msgBytes, err := hex.DecodeString(vector.Message)
+		assert.Nil(err)
+		decoded, err := Unmarshal(msgBytes)
+		assert.Nil(err)
+		msg := decoded.(SignMessage)
+		assert.Equal([]byte("payload"), msg.Payload)
+		assert.Nil(msg.Verify(nil, []Verifier{*verifier}), alg.Name)
+
+		jsonBytes, err := json.Marshal(vector)
+		assert.Nil(err)
+		assert.Contains(string(jsonBytes), `"public_key":"`+vector.PublicKey+`"`)
+	}
+
+	other, err := GenerateTestVector("ES256", []byte("other payload"))
+	assert.Nil(err)
+	vector, err := GenerateTestVector("ES256", []byte("payload"))
+	assert.Nil(err)
+	assert.NotEqual(vector.Message, other.Message)
+	assert.Equal(vector.PublicKey, other.PublicKey)
+
+	_, err = GenerateTestVector("PS256", []byte("payload"))
+	assert.Equal("cannot generate a deterministic test vector for PS256; use an ECDSA algorithm", err.Error())
+	_, err = GenerateTestVector("ES0", []byte("payload"))
+	assert.NotNil(err)
+}
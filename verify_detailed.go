@@ -0,0 +1,88 @@
+package cose
+
+import (
+	"crypto"
+	"crypto/x509"
+)
+
+// VerifiedSignature describes a message signature that verified
+type VerifiedSignature struct {
+	// Index is the index of the signature in the message Signatures
+	Index int
+
+	// Alg is the protected signature algorithm
+	Alg *Algorithm
+
+	// KeyID is the signature kid header or nil when it has none and
+	// KeyIDProtected is true when the kid is a protected header
+	KeyID          []byte
+	KeyIDProtected bool
+
+	// PublicKey and Certificate are from the Verifier that verified
+	// the signature. Certificate is nil when the Verifier has none.
+	PublicKey   crypto.PublicKey
+	Certificate *x509.Certificate
+}
+
+// newVerifiedSignature returns the VerifiedSignature for the message
+// signature at index i that verifier verified with alg
+func newVerifiedSignature(i int, alg *Algorithm, signature *Signature, verifier *Verifier) VerifiedSignature {
+	verified := VerifiedSignature{
+		Index:       i,
+		Alg:         alg,
+		PublicKey:   verifier.PublicKey,
+		Certificate: verifier.Certificate,
+	}
+	if kid, ok := findHeader(signature.Headers.Protected, CommonHeaderIDKeyID); ok {
+		verified.KeyID, _ = kid.([]byte)
+		verified.KeyIDProtected = true
+	} else if kid, ok := findHeader(signature.Headers.Unprotected, CommonHeaderIDKeyID); ok {
+		verified.KeyID, _ = kid.([]byte)
+	}
+	return verified
+}
+
+// VerificationResult describes a SignMessage that verified e.g. for
+// an authorization policy to check without decoding the message again
+type VerificationResult struct {
+	// Signatures are the signatures that verified. Signatures that
+	// VerifyOpts.IgnoreUnsupportedAlgs skipped are not included.
+	Signatures []VerifiedSignature
+
+	// ContentType is the message content type header or nil when it
+	// has none and ContentTypeProtected is true when it is a
+	// protected header
+	ContentType          interface{}
+	ContentTypeProtected bool
+
+	// Claims is the payload decoded as a CWT claims set or nil when
+	// the payload is not a CBOR map
+	Claims map[interface{}]interface{}
+}
+
+// VerifyDetailed verifies the signatures on the SignMessage like
+// VerifyWithOpts and returns a VerificationResult describing them
+//
+// The result is nil when verification fails.
+func (m *SignMessage) VerifyDetailed(external []byte, verifiers []Verifier, opts VerifyOpts) (result *VerificationResult, err error) {
+	verified, err := m.verifyWithOpts(external, verifiers, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result = &VerificationResult{Signatures: verified}
+	if m.Headers != nil {
+		if contentType, ok := findHeader(m.Headers.Protected, CommonHeaderIDContentType); ok {
+			result.ContentType = contentType
+			result.ContentTypeProtected = true
+		} else if contentType, ok := findHeader(m.Headers.Unprotected, CommonHeaderIDContentType); ok {
+			result.ContentType = contentType
+		}
+	}
+
+	var claims map[interface{}]interface{}
+	if m.DecodePayload(&claims) == nil {
+		result.Claims = claims
+	}
+	return result, nil
+}
@@ -0,0 +1,82 @@
+package cose
+
+import (
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVerifyDetailed(t *testing.T) {
+	assert := assert.New(t)
+
+	_, _, cert, key := testCertChain(t)
+	ecSigner, err := NewSignerFromKey(ES256, key)
+	assert.Nil(err, "Error creating signer")
+	psSigner, err := NewSigner(PS256, nil)
+	assert.Nil(err, "Error creating signer")
+	ecVerifier := *ecSigner.Verifier()
+	ecVerifier.Certificate = cert
+	verifiers := []Verifier{ecVerifier, *psSigner.Verifier()}
+
+	payload, err := Marshal(map[interface{}]interface{}{1: "coap://as.example.com", 2: "erikw"})
+	assert.Nil(err)
+	msg := NewSignMessage()
+	msg.Payload = payload
+	msg.Headers.Protected[CommonHeaderIDContentType] = 61 // application/cwt
+	ecSig := NewSignature()
+	ecSig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	ecSig.Headers.Protected[CommonHeaderIDKeyID] = []byte("ec kid")
+	msg.AddSignature(ecSig)
+	psSig := NewSignature()
+	psSig.Headers.Protected[CommonHeaderIDAlg] = PS256.Value
+	psSig.Headers.Unprotected[CommonHeaderIDKeyID] = []byte("ps kid")
+	msg.AddSignature(psSig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*ecSigner, *psSigner}))
+
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+	decoded, err := Unmarshal(msgBytes)
+	assert.Nil(err)
+	decodedMsg := decoded.(SignMessage)
+
+	result, err := decodedMsg.VerifyDetailed(nil, verifiers, VerifyOpts{})
+	assert.Nil(err)
+	assert.Len(result.Signatures, 2)
+	assert.Equal(0, result.Signatures[0].Index)
+	assert.Equal(ES256.Value, result.Signatures[0].Alg.Value)
+	assert.Equal([]byte("ec kid"), result.Signatures[0].KeyID)
+	assert.True(result.Signatures[0].KeyIDProtected)
+	assert.Equal(ecSigner.Public(), result.Signatures[0].PublicKey)
+	assert.Equal(cert, result.Signatures[0].Certificate)
+	assert.Equal(1, result.Signatures[1].Index)
+	assert.Equal(PS256.Value, result.Signatures[1].Alg.Value)
+	assert.Equal([]byte("ps kid"), result.Signatures[1].KeyID)
+	assert.False(result.Signatures[1].KeyIDProtected)
+	assert.Nil(result.Signatures[1].Certificate)
+	assert.Equal(61, result.ContentType)
+	assert.True(result.ContentTypeProtected)
+	assert.Equal("erikw", result.Claims[int64(2)])
+
+	// unsupported signatures are skipped
+	decodedMsg.Signatures[1].Headers.Protected[CommonHeaderIDAlg] = -65535
+	decodedMsg.Signatures[1].Headers.RawProtected = nil
+	result, err = decodedMsg.VerifyDetailed(nil, verifiers, VerifyOpts{IgnoreUnsupportedAlgs: true})
+	assert.Nil(err)
+	assert.Len(result.Signatures, 1)
+	assert.Equal(0, result.Signatures[0].Index)
+
+	// a payload that is not a claims set
+	signer, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	token := signedToken(t, signer, "not a claims set")
+	token.Headers.Unprotected["content type"] = "text/plain"
+	result, err = token.VerifyDetailed(nil, []Verifier{*signer.Verifier()}, VerifyOpts{})
+	assert.Nil(err)
+	assert.Nil(result.Claims)
+	assert.Equal("text/plain", result.ContentType)
+	assert.False(result.ContentTypeProtected)
+
+	result, err = token.VerifyDetailed([]byte("external"), []Verifier{*signer.Verifier()}, VerifyOpts{})
+	assert.Nil(result)
+	assert.Equal(ErrECDSAVerification, err)
+}
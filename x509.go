@@ -0,0 +1,300 @@
+package cose
+
+import (
+	"bytes"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+)
+
+// decodeCertHash returns the hash Algorithm and hash value of a
+// COSE_CertHash with CDDL fragment:
+//
+// COSE_CertHash = [ hashAlg: (int / tstr), hashValue: bstr ]
+//
+// https://tools.ietf.org/html/rfc9360#section-2
+func decodeCertHash(o interface{}) (alg *Algorithm, hashValue []byte, err error) {
+	array, ok := o.([]interface{})
+	if !ok || len(array) != 2 {
+		return nil, nil, errors.Errorf("error decoding COSE_CertHash as 2-item array; got %T", o)
+	}
+
+	if hashAlg, ok := intFromInteger(array[0]); ok {
+		alg, err = getAlgByValue(hashAlg)
+	} else if hashAlg, ok := array[0].(string); ok {
+		alg, err = getAlgByName(hashAlg)
+	} else {
+		err = errors.Errorf("error decoding COSE_CertHash hashAlg as int or tstr; got %T", array[0])
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isHashAlgorithm(alg) {
+		return nil, nil, errors.Errorf("COSE_CertHash hashAlg %s is not a hash algorithm", alg.Name)
+	}
+
+	hashValue, ok = array[1].([]byte)
+	if !ok {
+		return nil, nil, errors.Errorf("error decoding COSE_CertHash hashValue as bstr; got %T", array[1])
+	}
+	return alg, hashValue, nil
+}
+
+// certThumbprint returns the thumbprint of cert computed with the hash
+// algorithm alg
+func certThumbprint(alg *Algorithm, cert *x509.Certificate) (thumbprint []byte, err error) {
+	if !isHashAlgorithm(alg) {
+		return nil, errors.New("x5t hashAlg is not a hash algorithm")
+	}
+	if !alg.HashFunc.Available() {
+		return nil, ErrUnavailableHashFunc
+	}
+	hasher := alg.HashFunc.New()
+	_, _ = hasher.Write(cert.Raw) // Write() on hash never fails
+	return hasher.Sum(nil), nil
+}
+
+// X5T returns the hash algorithm and hash value of the x5t header
+// certificate thumbprint returning ErrX5TNotFound when the x5t is
+// missing
+//
+// https://tools.ietf.org/html/rfc9360#section-2
+func (h *Headers) X5T() (alg *Algorithm, hashValue []byte, err error) {
+	o, ok := getCommonHeader(h, "x5t")
+	if !ok {
+		return nil, nil, ErrX5TNotFound
+	}
+	return decodeCertHash(o)
+}
+
+// SetX5T sets the protected x5t header to the thumbprint of cert
+// computed with the hash algorithm alg e.g. SHA-256 or SHA-384
+func (h *Headers) SetX5T(alg *Algorithm, cert *x509.Certificate) (err error) {
+	thumbprint, err := certThumbprint(alg, cert)
+	if err != nil {
+		return err
+	}
+	if h.Protected == nil {
+		h.Protected = map[interface{}]interface{}{}
+	}
+	delete(h.Protected, "x5t")
+	delete(h.Unprotected, "x5t")
+	delete(h.Unprotected, CommonHeaderIDX5T)
+	h.Protected[CommonHeaderIDX5T] = []interface{}{alg.Value, thumbprint}
+	return nil
+}
+
+// verifyCertThumbprint checks that the x5t header (when present)
+// matches the thumbprint of cert computed with the x5t hash algorithm
+func verifyCertThumbprint(h *Headers, cert *x509.Certificate) (err error) {
+	alg, expected, err := h.X5T()
+	if err == ErrX5TNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	thumbprint, err := certThumbprint(alg, cert)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(thumbprint, expected) {
+		return ErrCertThumbprintMismatch
+	}
+	return nil
+}
+
+// X5U returns the protected x5u header URL of the certificate chain
+// returning an error when the x5u is missing or not a tstr
+//
+// An unprotected x5u header returns ErrX5UNotProtected since anyone
+// could add or change it to pick the certificate chain to fetch.
+//
+// https://tools.ietf.org/html/rfc9360#section-2
+func (h *Headers) X5U() (url string, err error) {
+	if h == nil {
+		return "", ErrX5UNotFound
+	}
+	if _, ok := findHeader(h.Unprotected, CommonHeaderIDX5U); ok {
+		return "", ErrX5UNotProtected
+	}
+	o, ok := findHeader(h.Protected, CommonHeaderIDX5U)
+	if !ok {
+		return "", ErrX5UNotFound
+	}
+	url, ok = o.(string)
+	if !ok {
+		return "", errors.Errorf("error casting x5u to tstr; got %T", o)
+	}
+	return url, nil
+}
+
+// x5uVerifier returns a Verifier for alg with the leaf certificate of
+// the chain fetch returns for the protected x5u header of h or
+// verifier when h has no x5u header
+func x5uVerifier(h *Headers, alg *Algorithm, fetch func(url string) ([]*x509.Certificate, error), verifier *Verifier) (*Verifier, error) {
+	url, err := h.X5U()
+	if err == ErrX5UNotFound {
+		return verifier, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	chain, err := fetch(url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error fetching x5u %s", url)
+	}
+	if len(chain) < 1 || chain[0] == nil {
+		return nil, errors.Errorf("x5u %s has no leaf certificate", url)
+	}
+
+	leafVerifier, err := NewVerifierFromPublicKey(alg.Name, chain[0].PublicKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "x5u %s leaf certificate", url)
+	}
+	leafVerifier.Certificate = chain[0]
+	return leafVerifier, nil
+}
+
+// X5Chain returns the certificates of the x5chain header returning
+// ErrX5ChainNotFound when the x5chain is missing
+//
+// The x5chain is a single bstr certificate or an array of them with
+// the leaf certificate first.
+//
+// https://tools.ietf.org/html/rfc9360#section-2
+func (h *Headers) X5Chain() (chain []*x509.Certificate, err error) {
+	o, ok := getCommonHeader(h, "x5chain")
+	if !ok {
+		return nil, ErrX5ChainNotFound
+	}
+	return decodeX5Chain(o)
+}
+
+// decodeX5Chain returns the certificates of an x5chain header value
+// with CDDL fragment:
+//
+// COSE_X509 = bstr / [ 2*certs: bstr ]
+//
+// https://tools.ietf.org/html/rfc9360#section-2
+func decodeX5Chain(o interface{}) (chain []*x509.Certificate, err error) {
+	var certs []interface{}
+	switch v := o.(type) {
+	case []byte:
+		certs = []interface{}{v}
+	case []interface{}:
+		certs = v
+	default:
+		return nil, errors.Errorf("error decoding x5chain as bstr or array; got %T", o)
+	}
+	if len(certs) < 1 {
+		return nil, errors.New("x5chain has no certificates")
+	}
+
+	for i, c := range certs {
+		der, ok := c.([]byte)
+		if !ok {
+			return nil, errors.Errorf("error decoding x5chain certificate %d as bstr; got %T", i, c)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing x5chain certificate %d", i)
+		}
+		chain = append(chain, cert)
+	}
+	return chain, nil
+}
+
+// MatchCertBySKI returns the first certificate in certs with a
+// Subject Key Identifier extension equal to kid returning an error
+// when none match
+//
+// Use it with a VerifierLookup when kid headers carry the certificate
+// SKI e.g.
+//
+//	lookup := func(kid []byte) (*Verifier, error) {
+//		cert, err := MatchCertBySKI(kid, certs)
+//		if err != nil {
+//			return nil, err
+//		}
+//		verifier, err := NewVerifierFromPublicKey("ES256", cert.PublicKey)
+//		if err != nil {
+//			return nil, err
+//		}
+//		verifier.Certificate = cert
+//		return verifier, nil
+//	}
+func MatchCertBySKI(kid []byte, certs []*x509.Certificate) (cert *x509.Certificate, err error) {
+	if len(kid) < 1 {
+		return nil, errors.New("cannot match an empty kid to a Subject Key Identifier")
+	}
+	for _, cert := range certs {
+		if cert != nil && len(cert.SubjectKeyId) > 0 && bytes.Equal(cert.SubjectKeyId, kid) {
+			return cert, nil
+		}
+	}
+	return nil, errors.Errorf("no certificate with Subject Key Identifier %x", kid)
+}
+
+// VerifyWithTrustStore verifies all signatures on the SignMessage with
+// the leaf certificate key of their x5chain header after validating
+// the chain up to a certificate in roots
+//
+// The x5chain header of each signature must start with the leaf
+// certificate and include the intermediate certificates. opts.Roots
+// and opts.Intermediates are set from roots and the x5chain header.
+// x509 validation checks the server authentication extended key
+// usage when opts.KeyUsages is empty, so set it e.g. to
+// x509.ExtKeyUsageCodeSigning.
+func (m *SignMessage) VerifyWithTrustStore(external []byte, roots *x509.CertPool, opts x509.VerifyOptions) (err error) {
+	if m == nil || len(m.Signatures) < 1 {
+		return ErrNoSignatures
+	}
+	if m.Payload == nil {
+		return ErrMissingPayload
+	}
+	if roots == nil {
+		return errors.New("VerifyWithTrustStore requires a root certificate pool")
+	}
+
+	for i, signature := range m.Signatures {
+		alg, err := signatureToVerifyAlg(i, &signature)
+		if err != nil {
+			return err
+		}
+
+		o, ok := getCommonHeader(signature.Headers, "x5chain")
+		if !ok {
+			return errors.Errorf("SignMessage signature %d has no x5chain header", i)
+		}
+		chain, err := decodeX5Chain(o)
+		if err != nil {
+			return err
+		}
+
+		opts.Roots = roots
+		opts.Intermediates = x509.NewCertPool()
+		for _, cert := range chain[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err = chain[0].Verify(opts)
+		if err != nil {
+			return errors.Wrapf(err, "SignMessage signature %d x5chain is not trusted", i)
+		}
+
+		verifier, err := NewVerifierFromPublicKey(alg.Name, chain[0].PublicKey)
+		if err != nil {
+			return errors.Wrapf(err, "SignMessage signature %d x5chain leaf certificate", i)
+		}
+		verifier.Certificate = chain[0]
+
+		digest, err := m.signatureDigest(external, &signature, alg.HashFunc)
+		if err != nil {
+			return err
+		}
+		err = verifySignatureDigest(verifier, &signature, digest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package cose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyCertThumbprint(t *testing.T) {
+	assert := assert.New(t)
+
+	cert, err := x509.ParseCertificate(P256_EE[:])
+	assert.Nil(err, "Error parsing P256_EE certificate")
+	key, err := x509.ParsePKCS8PrivateKey(PKCS8_P256_EE[:])
+	assert.Nil(err, "Error parsing PKCS8_P256_EE private key")
+
+	signer, err := NewSignerFromKey(ES256, key)
+	assert.Nil(err, "Error creating signer")
+	verifier := signer.Verifier()
+	verifier.Certificate = cert
+
+	thumbprint := sha256.Sum256(cert.Raw)
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload to sign")
+	sig := NewSignature()
+	sig.Headers.Protected["alg"] = "ES256"
+	sig.Headers.Protected["x5t"] = []interface{}{-16, thumbprint[:]}
+	msg.AddSignature(sig)
+
+	err = msg.Sign(rand.Reader, nil, []Signer{*signer})
+	assert.Nil(err, "Error signing message")
+	assert.Nil(msg.Verify(nil, []Verifier{*verifier}))
+
+	// round trip decodes x5t with int64 hashAlg
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+	decoded, err := Unmarshal(msgBytes)
+	assert.Nil(err)
+	decodedMsg := decoded.(SignMessage)
+	assert.Nil(decodedMsg.Verify(nil, []Verifier{*verifier}))
+
+	// a different certificate does not match the thumbprint
+	otherCert, err := x509.ParseCertificate(P384_EE[:])
+	assert.Nil(err, "Error parsing P384_EE certificate")
+	verifier.Certificate = otherCert
+	assert.Equal(ErrCertThumbprintMismatch, msg.Verify(nil, []Verifier{*verifier}))
+
+	// no x5t header skips the check
+	verifier.Certificate = cert
+	msg.Signatures[0].Headers.Protected = map[interface{}]interface{}{"alg": "ES256"}
+	msg.Signatures[0].SignatureBytes = nil
+	err = msg.Sign(rand.Reader, nil, []Signer{*signer})
+	assert.Nil(err, "Error signing message")
+	verifier.Certificate = otherCert
+	assert.Nil(msg.Verify(nil, []Verifier{*verifier}))
+}
+
+func TestHeadersX5T(t *testing.T) {
+	assert := assert.New(t)
+
+	cert, err := x509.ParseCertificate(P256_EE[:])
+	assert.Nil(err, "Error parsing P256_EE certificate")
+	key, err := x509.ParsePKCS8PrivateKey(PKCS8_P256_EE[:])
+	assert.Nil(err, "Error parsing PKCS8_P256_EE private key")
+	signer, err := NewSignerFromKey(ES256, key)
+	assert.Nil(err, "Error creating signer")
+	verifier := signer.Verifier()
+	verifier.Certificate = cert
+
+	h := &Headers{}
+	_, _, err = h.X5T()
+	assert.Equal(ErrX5TNotFound, err)
+
+	for _, name := range []string{"SHA-256", "SHA-384", "SHA-512"} {
+		hashAlg := getAlgByNameOrPanic(name)
+		sig := NewSignature()
+		sig.Headers.Protected["alg"] = "ES256"
+		sig.Headers.Unprotected["x5t"] = []interface{}{-16, []byte("stale")}
+		assert.Nil(sig.Headers.SetX5T(hashAlg, cert))
+		assert.Equal(map[interface{}]interface{}{}, sig.Headers.Unprotected)
+
+		alg, thumbprint, err := sig.Headers.X5T()
+		assert.Nil(err)
+		assert.Equal(hashAlg, alg)
+		hasher := hashAlg.HashFunc.New()
+		hasher.Write(cert.Raw)
+		assert.Equal(hasher.Sum(nil), thumbprint)
+
+		// verification uses the x5t hashAlg
+		msg := NewSignMessage()
+		msg.Payload = []byte("payload to sign")
+		msg.AddSignature(sig)
+		assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+		msgBytes, err := Marshal(msg)
+		assert.Nil(err)
+		decoded, err := Unmarshal(msgBytes)
+		assert.Nil(err)
+		decodedMsg := decoded.(SignMessage)
+		assert.Nil(decodedMsg.Verify(nil, []Verifier{*verifier}), name)
+
+		otherCert, err := x509.ParseCertificate(P384_EE[:])
+		assert.Nil(err, "Error parsing P384_EE certificate")
+		otherVerifier := *verifier
+		otherVerifier.Certificate = otherCert
+		assert.Equal(ErrCertThumbprintMismatch, decodedMsg.Verify(nil, []Verifier{otherVerifier}), name)
+	}
+
+	assert.Equal("x5t hashAlg is not a hash algorithm", h.SetX5T(ES256, cert).Error())
+	assert.Equal("x5t hashAlg is not a hash algorithm", h.SetX5T(nil, cert).Error())
+}
+
+func TestDecodeCertHashErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	_, _, err := decodeCertHash([]byte("not an array"))
+	assert.Equal("error decoding COSE_CertHash as 2-item array; got []uint8", err.Error())
+
+	_, _, err = decodeCertHash([]interface{}{true, []byte("")})
+	assert.Equal("error decoding COSE_CertHash hashAlg as int or tstr; got bool", err.Error())
+
+	_, _, err = decodeCertHash([]interface{}{"ES256", []byte("")})
+	assert.Equal("COSE_CertHash hashAlg ES256 is not a hash algorithm", err.Error())
+
+	_, _, err = decodeCertHash([]interface{}{-9000, []byte("")})
+	assert.Equal("Algorithm with value -9000 not found", err.Error())
+
+	_, _, err = decodeCertHash([]interface{}{"SHA-256", "text"})
+	assert.Equal("error decoding COSE_CertHash hashValue as bstr; got string", err.Error())
+
+	alg, hashValue, err := decodeCertHash([]interface{}{int64(-43), []byte("abc")})
+	assert.Nil(err)
+	assert.Equal("SHA-384", alg.Name)
+	assert.Equal([]byte("abc"), hashValue)
+}
+
+func TestVerifyWithOptsFetchX5U(t *testing.T) {
+	assert := assert.New(t)
+
+	cert, err := x509.ParseCertificate(P256_EE[:])
+	assert.Nil(err, "Error parsing P256_EE certificate")
+	otherCert, err := x509.ParseCertificate(P384_EE[:])
+	assert.Nil(err, "Error parsing P384_EE certificate")
+	key, err := x509.ParsePKCS8PrivateKey(PKCS8_P256_EE[:])
+	assert.Nil(err, "Error parsing PKCS8_P256_EE private key")
+	signer, err := NewSignerFromKey(ES256, key)
+	assert.Nil(err, "Error creating signer")
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload to sign")
+	sig := NewSignature()
+	sig.Headers.Protected["alg"] = "ES256"
+	sig.Headers.Protected["x5u"] = "https://example.com/chain.pem"
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+
+	url, err := msg.Signatures[0].Headers.X5U()
+	assert.Nil(err)
+	assert.Equal("https://example.com/chain.pem", url)
+
+	var fetched []string
+	fetch := func(chain ...*x509.Certificate) func(url string) ([]*x509.Certificate, error) {
+		return func(url string) ([]*x509.Certificate, error) {
+			fetched = append(fetched, url)
+			return chain, nil
+		}
+	}
+
+	// the leaf key verifies instead of the verifier
+	otherSigner, err := NewSigner(ES256, nil)
+	assert.Nil(err, "Error creating signer")
+	verifiers := []Verifier{*otherSigner.Verifier()}
+	assert.Equal(ErrECDSAVerification, msg.VerifyWithOpts(nil, verifiers, VerifyOpts{}))
+	assert.Nil(msg.VerifyWithOpts(nil, verifiers, VerifyOpts{FetchX5U: fetch(cert, otherCert)}))
+	assert.Equal([]string{"https://example.com/chain.pem"}, fetched)
+
+	assert.Equal(
+		"x5u https://example.com/chain.pem leaf certificate: Expected 256 bit key, got 384 bits instead",
+		msg.VerifyWithOpts(nil, verifiers, VerifyOpts{FetchX5U: fetch(otherCert)}).Error())
+	assert.Equal(
+		"x5u https://example.com/chain.pem has no leaf certificate",
+		msg.VerifyWithOpts(nil, verifiers, VerifyOpts{FetchX5U: fetch()}).Error())
+	assert.Equal(
+		"error fetching x5u https://example.com/chain.pem: not found",
+		msg.VerifyWithOpts(nil, verifiers, VerifyOpts{FetchX5U: func(url string) ([]*x509.Certificate, error) {
+			return nil, errors.New("not found")
+		}}).Error())
+
+	// the x5t header is checked against the leaf certificate
+	otherThumbprint := sha256.Sum256(otherCert.Raw)
+	msg.Signatures[0].Headers.Unprotected["x5t"] = []interface{}{-16, otherThumbprint[:]}
+	assert.Equal(ErrCertThumbprintMismatch, msg.VerifyWithOpts(nil, verifiers, VerifyOpts{FetchX5U: fetch(cert)}))
+	delete(msg.Signatures[0].Headers.Unprotected, "x5t")
+
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDX5U] = []byte("https://example.com/chain.pem")
+	_, err = msg.Signatures[0].Headers.X5U()
+	assert.Equal("error casting x5u to tstr; got []uint8", err.Error())
+	assert.Equal(err.Error(), msg.VerifyWithOpts(nil, verifiers, VerifyOpts{FetchX5U: fetch(cert)}).Error())
+
+	// without an x5u header the verifier is used
+	delete(msg.Signatures[0].Headers.Protected, CommonHeaderIDX5U)
+	_, err = msg.Signatures[0].Headers.X5U()
+	assert.Equal(ErrX5UNotFound, err)
+	assert.Equal(ErrECDSAVerification, msg.VerifyWithOpts(nil, verifiers, VerifyOpts{FetchX5U: fetch(cert)}))
+
+	// an x5u added to the unprotected headers cannot pick the key
+	fetched = nil
+	unprotected := NewSignMessage()
+	unprotected.Payload = []byte("payload to sign")
+	unprotectedSig := NewSignature()
+	unprotectedSig.Headers.Protected["alg"] = "ES256"
+	unprotected.AddSignature(unprotectedSig)
+	assert.Nil(unprotected.Sign(rand.Reader, nil, []Signer{*otherSigner}))
+	unprotected.Signatures[0].Headers.Unprotected["x5u"] = "https://example.com/chain.pem"
+	_, err = unprotected.Signatures[0].Headers.X5U()
+	assert.Equal(ErrX5UNotProtected, err)
+	assert.Equal(ErrX5UNotProtected, unprotected.VerifyWithOpts(nil, verifiers, VerifyOpts{FetchX5U: fetch(cert)}))
+	assert.Nil(fetched)
+	assert.Nil(unprotected.VerifyWithOpts(nil, verifiers, VerifyOpts{}))
+
+	// including alongside a protected x5u
+	msg.Signatures[0].Headers.Protected[CommonHeaderIDX5U] = "https://example.com/chain.pem"
+	msg.Signatures[0].Headers.Unprotected[CommonHeaderIDX5U] = "https://example.com/other.pem"
+	_, err = msg.Signatures[0].Headers.X5U()
+	assert.Equal(ErrX5UNotProtected, err)
+	assert.Equal(ErrX5UNotProtected, msg.VerifyWithOpts(nil, verifiers, VerifyOpts{FetchX5U: fetch(cert)}))
+	assert.Nil(fetched)
+}
+
+// testCertChain returns a generated root, intermediate, and ES256 leaf
+// certificate chain valid in 2020 and the leaf private key
+func testCertChain(t *testing.T) (root, intermediate, leaf *x509.Certificate, leafKey *ecdsa.PrivateKey) {
+	newCert := func(cn string, isCA bool, parent *x509.Certificate, parentKey, key *ecdsa.PrivateKey) *x509.Certificate {
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: cn},
+			NotBefore:             time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+			NotAfter:              time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+			BasicConstraintsValid: true,
+			IsCA:                  isCA,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		assert.Nil(t, err, "Error creating certificate")
+		cert, err := x509.ParseCertificate(der)
+		assert.Nil(t, err, "Error parsing certificate")
+		return cert
+	}
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.Nil(t, err, "Error generating key")
+		return key
+	}
+
+	rootKey, intermediateKey, leafKey := newKey(), newKey(), newKey()
+	root = newCert("root", true, nil, nil, rootKey)
+	intermediate = newCert("intermediate", true, root, rootKey, intermediateKey)
+	leaf = newCert("leaf", false, intermediate, intermediateKey, leafKey)
+	return root, intermediate, leaf, leafKey
+}
+
+func TestVerifyWithTrustStore(t *testing.T) {
+	assert := assert.New(t)
+
+	root, intermediate, leaf, key := testCertChain(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	opts := x509.VerifyOptions{
+		CurrentTime: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+
+	signer, err := NewSignerFromKey(ES256, key)
+	assert.Nil(err, "Error creating signer")
+
+	msg := NewSignMessage()
+	msg.Payload = []byte("payload to sign")
+	sig := NewSignature()
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+	sig.Headers.Unprotected[CommonHeaderIDX5Chain] = []interface{}{leaf.Raw, intermediate.Raw}
+	msg.AddSignature(sig)
+	assert.Nil(msg.Sign(rand.Reader, nil, []Signer{*signer}))
+
+	assert.Nil(msg.VerifyWithTrustStore(nil, roots, opts))
+
+	// round trip decodes the x5chain
+	msgBytes, err := Marshal(msg)
+	assert.Nil(err)
+	decoded, err := Unmarshal(msgBytes)
+	assert.Nil(err)
+	decodedMsg := decoded.(SignMessage)
+	assert.Nil(decodedMsg.VerifyWithTrustStore(nil, roots, opts))
+
+	assert.Equal(ErrECDSAVerification, msg.VerifyWithTrustStore([]byte("external"), roots, opts))
+	assert.Equal("VerifyWithTrustStore requires a root certificate pool", msg.VerifyWithTrustStore(nil, nil, opts).Error())
+
+	expired := opts
+	expired.CurrentTime = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Contains(msg.VerifyWithTrustStore(nil, roots, expired).Error(), "SignMessage signature 0 x5chain is not trusted: x509: certificate has expired")
+	assert.Contains(msg.VerifyWithTrustStore(nil, x509.NewCertPool(), opts).Error(), "SignMessage signature 0 x5chain is not trusted: x509: certificate signed by unknown authority")
+
+	// the intermediate must be in the x5chain
+	sig.Headers.Unprotected[CommonHeaderIDX5Chain] = leaf.Raw
+	assert.Contains(msg.VerifyWithTrustStore(nil, roots, opts).Error(), "certificate signed by unknown authority")
+
+	// the leaf key must be for the signature alg
+	sig.Headers.Unprotected[CommonHeaderIDX5Chain] = []interface{}{leaf.Raw, intermediate.Raw}
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES384.Value
+	assert.Equal(
+		"SignMessage signature 0 x5chain leaf certificate: Expected 384 bit key, got 256 bits instead",
+		msg.VerifyWithTrustStore(nil, roots, opts).Error())
+	sig.Headers.Protected[CommonHeaderIDAlg] = ES256.Value
+
+	sig.Headers.Unprotected[CommonHeaderIDX5Chain] = []interface{}{}
+	assert.Equal("x5chain has no certificates", msg.VerifyWithTrustStore(nil, roots, opts).Error())
+	sig.Headers.Unprotected[CommonHeaderIDX5Chain] = []interface{}{"cert"}
+	assert.Equal("error decoding x5chain certificate 0 as bstr; got string", msg.VerifyWithTrustStore(nil, roots, opts).Error())
+	sig.Headers.Unprotected[CommonHeaderIDX5Chain] = []byte("cert")
+	assert.Contains(msg.VerifyWithTrustStore(nil, roots, opts).Error(), "error parsing x5chain certificate 0: ")
+	sig.Headers.Unprotected[CommonHeaderIDX5Chain] = 1
+	assert.Equal("error decoding x5chain as bstr or array; got int", msg.VerifyWithTrustStore(nil, roots, opts).Error())
+	delete(sig.Headers.Unprotected, CommonHeaderIDX5Chain)
+	assert.Equal("SignMessage signature 0 has no x5chain header", msg.VerifyWithTrustStore(nil, roots, opts).Error())
+}
+
+func TestMatchCertBySKI(t *testing.T) {
+	assert := assert.New(t)
+
+	root, intermediate, leaf, key := testCertChain(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "leaf with ski"},
+		NotBefore:    time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		SubjectKeyId: []byte("leaf ski"),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(err, "Error creating certificate")
+	skiCert, err := x509.ParseCertificate(der)
+	assert.Nil(err, "Error parsing certificate")
+	certs := []*x509.Certificate{root, nil, intermediate, leaf, skiCert}
+
+	cert, err := MatchCertBySKI([]byte("leaf ski"), certs)
+	assert.Nil(err)
+	assert.Equal(skiCert, cert)
+
+	_, err = MatchCertBySKI([]byte("other ski"), certs)
+	assert.Equal("no certificate with Subject Key Identifier 6f7468657220736b69", err.Error())
+	_, err = MatchCertBySKI(nil, certs)
+	assert.Equal("cannot match an empty kid to a Subject Key Identifier", err.Error())
+
+	// verify with the certificate matching the signature kid
+	signer, err := NewSignerFromKey(ES256, key)
+	assert.Nil(err, "Error creating signer")
+	msg := signedToken(t, signer, "payload")
+	assert.Nil(msg.SetSignatureKeyID(0, skiCert.SubjectKeyId))
+	results, err := msg.VerifyAll(nil, func(kid []byte) (*Verifier, error) {
+		cert, err := MatchCertBySKI(kid, certs)
+		if err != nil {
+			return nil, err
+		}
+		verifier, err := NewVerifierFromPublicKey(ES256.Name, cert.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		verifier.Certificate = cert
+		return verifier, nil
+	})
+	assert.Nil(err)
+	assert.Nil(results[0].Err)
+}